@@ -1,14 +1,38 @@
 package config
 
 import (
+	"log"
+	"strings"
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	MQTT     		MQTTConfig
-	JayaApi  		JayaApiConfig
-	Redis    		RedisConfig
-	TimescaleDB TimescaleDBConfig
+	MQTT         MQTTConfig
+	JayaApi      JayaApiConfig
+	Redis        RedisConfig
+	TimescaleDB  TimescaleDBConfig
+	Cache        CacheConfig
+	HTTP         HTTPConfig
+	Conversion   ConversionConfig
+	Gas          GasConfig
+	Flow         FlowConfig
+	Consumption  ConsumptionConfig
+	Liveness     LivenessConfig
+	Health       HealthConfig
+	Solar        SolarConfig
+	Inventory    InventoryConfig
+	OTA          OTAConfig
+	Provisioning ProvisioningConfig
+	Credentials  CredentialsConfig
+	PKI          PKIConfig
+	Stream       StreamConfig
+	Events       EventsConfig
+	Outbox       OutboxConfig
+	Export       ExportConfig
+	Validation   ValidationConfig
+	Clock        ClockConfig
 }
 
 type MQTTConfig struct {
@@ -41,10 +65,223 @@ type TimescaleDBConfig struct {
 	Enabled  bool
 }
 
+type CacheConfig struct {
+	Size        int
+	LocalTTL    time.Duration
+	StaleTTL    time.Duration
+	RedisTTL    time.Duration
+	NegativeTTL time.Duration
+}
+
+type HTTPConfig struct {
+	Addr         string
+	WebhookToken string
+	APIKeys      map[string]string // API key to hospital ID, empty for fleet-wide keys
+}
+
+type ConversionConfig struct {
+	Clamp       bool
+	Interpolate bool
+	Tolerance   float64
+}
+
+type GasConfig struct {
+	Default              string
+	ReferenceTemperature float64
+	ReferencePressure    float64
+}
+
+type FlowConfig struct {
+	CounterModulus float64
+}
+
+type ConsumptionConfig struct {
+	Location *time.Location
+}
+
+type LivenessConfig struct {
+	ScanInterval    time.Duration
+	DefaultInterval time.Duration
+	Intervals       map[string]time.Duration
+	MissedIntervals int
+}
+
+type HealthConfig struct {
+	LowRSSI        int
+	HighTemp       float64
+	HighHumidity   float64
+	MemoryLeakRate float64
+	MemoryWindow   int
+}
+
+type SolarConfig struct {
+	CapacityWh  float64
+	LowBattery  int
+	HighTemp    int
+	LowAutonomy float64
+}
+
+type InventoryConfig struct {
+	TouchInterval time.Duration
+}
+
+type OTAConfig struct {
+	ArtifactDir      string
+	BaseURL          string
+	MaxArtifactSize  int64
+	FailureThreshold float64
+	EvaluateInterval time.Duration
+	DeviceTimeout    time.Duration
+	// MaxRetryBackoff caps the delay before a command that couldn't be
+	// published is sent again.
+	MaxRetryBackoff time.Duration
+}
+
+type ProvisioningConfig struct {
+	Mode             string
+	AllowLegacyTopic bool
+	SerialLimit      int
+	SerialWindow     time.Duration
+	GlobalLimit      int
+	// RequireEncryption refuses password provisioning requests without a
+	// public key to encrypt the credentials to. When disabled, credentials
+	// of such requests go out in cleartext and the broker ACL must only let
+	// a client read provisioning/<serial>/<nonce>/response of its own
+	// requests.
+	RequireEncryption bool
+}
+
+type CredentialsConfig struct {
+	RotationInterval time.Duration
+	CheckInterval    time.Duration
+	ConfirmTimeout   time.Duration
+	BatchSize        int
+}
+
+type PKIConfig struct {
+	CACertFile   string
+	CAKeyFile    string
+	Organization string
+	Validity     time.Duration
+	// CRLValidity is how long a CRL served on /pki/crl stays valid. The
+	// broker must fetch it again before then to see new revocations.
+	CRLValidity time.Duration
+}
+
+type StreamConfig struct {
+	HistorySize    int
+	ClientBuffer   int
+	Heartbeat      time.Duration
+	AllowedOrigins []string
+}
+
+type EventsConfig struct {
+	Streams      bool
+	StreamPrefix string
+	StreamMaxLen int64
+}
+
+type OutboxConfig struct {
+	RelayInterval time.Duration
+	BatchSize     int
+	Grace         time.Duration
+	MaxBackoff    time.Duration
+	Retention     time.Duration
+}
+
+type ExportConfig struct {
+	Sink        string // kafka, nats or empty to disable
+	Brokers     []string
+	TopicPrefix string
+	Format      string
+	Channels    []string
+}
+
+type ValidationConfig struct {
+	Enabled   bool
+	MaxFuture time.Duration
+	MaxPast   time.Duration
+}
+
+type ClockConfig struct {
+	MaxSkew          time.Duration
+	BrokenSkew       time.Duration
+	CorrectionWindow time.Duration // zero disables correction
+}
+
 func LoadConfig() *Config {
 	viper.SetConfigFile(".env")
+	viper.SetDefault("CACHE_SIZE", 10000)
+	viper.SetDefault("CACHE_LOCAL_TTL", "5m")
+	viper.SetDefault("CACHE_STALE_TTL", "10m")
+	viper.SetDefault("CACHE_REDIS_TTL", "3h")
+	viper.SetDefault("CACHE_NEGATIVE_TTL", "1m")
+	viper.SetDefault("HTTP_ADDR", ":8080")
+	viper.SetDefault("CONVERSION_TOLERANCE", 0.01)
+	viper.SetDefault("GAS_DEFAULT", "liquid_oxygen")
+	viper.SetDefault("GAS_REFERENCE_TEMPERATURE_C", 15.0)
+	viper.SetDefault("GAS_REFERENCE_PRESSURE_KPA", 101.325)
+	viper.SetDefault("FLOW_COUNTER_MODULUS", 4294967296.0)
+	viper.SetDefault("CONSUMPTION_TIMEZONE", "Asia/Jakarta")
+	viper.SetDefault("LIVENESS_SCAN_INTERVAL", "1m")
+	viper.SetDefault("LIVENESS_DEFAULT_INTERVAL", "10m")
+	viper.SetDefault("LIVENESS_INTERVALS", "level=10m,flow=5m,pressure=5m")
+	viper.SetDefault("LIVENESS_MISSED_INTERVALS", 3)
+	viper.SetDefault("HEALTH_LOW_RSSI", -100)
+	viper.SetDefault("HEALTH_HIGH_TEMP", 60.0)
+	viper.SetDefault("HEALTH_HIGH_HUMIDITY", 85.0)
+	viper.SetDefault("HEALTH_MEMORY_LEAK_RATE", 1024.0)
+	viper.SetDefault("HEALTH_MEMORY_WINDOW", 24)
+	viper.SetDefault("SOLAR_BATTERY_CAPACITY_WH", 1200.0)
+	viper.SetDefault("SOLAR_LOW_BATTERY", 20)
+	viper.SetDefault("SOLAR_HIGH_TEMP", 50)
+	viper.SetDefault("SOLAR_LOW_AUTONOMY_DAYS", 2.0)
+	viper.SetDefault("INVENTORY_TOUCH_INTERVAL", "1h")
+	viper.SetDefault("OTA_ARTIFACT_DIR", "./firmware")
+	viper.SetDefault("OTA_MAX_ARTIFACT_SIZE", 16<<20)
+	viper.SetDefault("OTA_FAILURE_THRESHOLD", 0.1)
+	viper.SetDefault("OTA_EVALUATE_INTERVAL", "1m")
+	viper.SetDefault("OTA_DEVICE_TIMEOUT", "2h")
+	viper.SetDefault("OTA_MAX_RETRY_BACKOFF", "30m")
+	viper.SetDefault("PROVISIONING_MODE", "allowlist")
+	viper.SetDefault("PROVISIONING_SERIAL_LIMIT", 3)
+	viper.SetDefault("PROVISIONING_SERIAL_WINDOW", "10m")
+	viper.SetDefault("PROVISIONING_GLOBAL_LIMIT", 60)
+	viper.SetDefault("PROVISIONING_REQUIRE_ENCRYPTION", true)
+	viper.SetDefault("CREDENTIALS_ROTATION_INTERVAL", "2160h")
+	viper.SetDefault("CREDENTIALS_CHECK_INTERVAL", "10m")
+	viper.SetDefault("CREDENTIALS_CONFIRM_TIMEOUT", "24h")
+	viper.SetDefault("CREDENTIALS_BATCH_SIZE", 50)
+	viper.SetDefault("PKI_CERT_VALIDITY", "8760h")
+	viper.SetDefault("PKI_CRL_VALIDITY", "24h")
+	viper.SetDefault("STREAM_HISTORY_SIZE", 1000)
+	viper.SetDefault("STREAM_CLIENT_BUFFER", 256)
+	viper.SetDefault("STREAM_HEARTBEAT", "15s")
+	viper.SetDefault("EVENT_STREAMS_ENABLED", true)
+	viper.SetDefault("EVENT_STREAM_PREFIX", "stream:")
+	viper.SetDefault("EVENT_STREAM_MAXLEN", 100000)
+	viper.SetDefault("OUTBOX_RELAY_INTERVAL", "1s")
+	viper.SetDefault("OUTBOX_BATCH_SIZE", 100)
+	viper.SetDefault("OUTBOX_GRACE", "5s")
+	viper.SetDefault("OUTBOX_MAX_BACKOFF", "5m")
+	viper.SetDefault("OUTBOX_RETENTION", "24h")
+	viper.SetDefault("EXPORT_TOPIC_PREFIX", "medgas.")
+	viper.SetDefault("EXPORT_FORMAT", "json")
+	viper.SetDefault("EXPORT_CHANNELS", "sensor:level,sensor:flow,sensor:pressure,filling:transaction,solar:alert,device:health,device:status,device:clock")
+	viper.SetDefault("VALIDATION_ENABLED", true)
+	viper.SetDefault("VALIDATION_MAX_FUTURE", "10m")
+	viper.SetDefault("VALIDATION_MAX_PAST", "2160h")
+	viper.SetDefault("CLOCK_MAX_SKEW", "2m")
+	viper.SetDefault("CLOCK_BROKEN_SKEW", "24h")
+	viper.SetDefault("CLOCK_CORRECTION_WINDOW", "0")
 	viper.ReadInConfig()
 
+	consumptionLocation, err := time.LoadLocation(viper.GetString("CONSUMPTION_TIMEZONE"))
+	if err != nil {
+		log.Printf("Invalid CONSUMPTION_TIMEZONE, using UTC: %v", err)
+		consumptionLocation = time.UTC
+	}
+
 	return &Config{
 		MQTT: MQTTConfig{
 			Broker:   viper.GetString("MQTT_BROKER"),
@@ -72,5 +309,190 @@ func LoadConfig() *Config {
 			SSLMode:  viper.GetString("TIMESCALEDB_SSL_MODE"),
 			Enabled:  viper.GetBool("TIMESCALEDB_ENABLED"),
 		},
+		Cache: CacheConfig{
+			Size:        viper.GetInt("CACHE_SIZE"),
+			LocalTTL:    viper.GetDuration("CACHE_LOCAL_TTL"),
+			StaleTTL:    viper.GetDuration("CACHE_STALE_TTL"),
+			RedisTTL:    viper.GetDuration("CACHE_REDIS_TTL"),
+			NegativeTTL: viper.GetDuration("CACHE_NEGATIVE_TTL"),
+		},
+		HTTP: HTTPConfig{
+			Addr:         viper.GetString("HTTP_ADDR"),
+			WebhookToken: viper.GetString("JAYA_WEBHOOK_TOKEN"),
+			APIKeys:      parseAPIKeys(viper.GetString("API_KEYS")),
+		},
+		Conversion: ConversionConfig{
+			Clamp:       viper.GetBool("CONVERSION_CLAMP"),
+			Interpolate: viper.GetBool("CONVERSION_INTERPOLATE"),
+			Tolerance:   viper.GetFloat64("CONVERSION_TOLERANCE"),
+		},
+		Gas: GasConfig{
+			Default:              viper.GetString("GAS_DEFAULT"),
+			ReferenceTemperature: viper.GetFloat64("GAS_REFERENCE_TEMPERATURE_C"),
+			ReferencePressure:    viper.GetFloat64("GAS_REFERENCE_PRESSURE_KPA"),
+		},
+		Flow: FlowConfig{
+			CounterModulus: viper.GetFloat64("FLOW_COUNTER_MODULUS"),
+		},
+		Consumption: ConsumptionConfig{
+			Location: consumptionLocation,
+		},
+		Liveness: LivenessConfig{
+			ScanInterval:    positiveDuration("LIVENESS_SCAN_INTERVAL", time.Minute),
+			DefaultInterval: positiveDuration("LIVENESS_DEFAULT_INTERVAL", 10*time.Minute),
+			Intervals:       parseDurations(viper.GetString("LIVENESS_INTERVALS")),
+			MissedIntervals: positiveInt("LIVENESS_MISSED_INTERVALS", 3),
+		},
+		Health: HealthConfig{
+			LowRSSI:        viper.GetInt("HEALTH_LOW_RSSI"),
+			HighTemp:       viper.GetFloat64("HEALTH_HIGH_TEMP"),
+			HighHumidity:   viper.GetFloat64("HEALTH_HIGH_HUMIDITY"),
+			MemoryLeakRate: viper.GetFloat64("HEALTH_MEMORY_LEAK_RATE"),
+			MemoryWindow:   viper.GetInt("HEALTH_MEMORY_WINDOW"),
+		},
+		Solar: SolarConfig{
+			CapacityWh:  viper.GetFloat64("SOLAR_BATTERY_CAPACITY_WH"),
+			LowBattery:  viper.GetInt("SOLAR_LOW_BATTERY"),
+			HighTemp:    viper.GetInt("SOLAR_HIGH_TEMP"),
+			LowAutonomy: viper.GetFloat64("SOLAR_LOW_AUTONOMY_DAYS"),
+		},
+		Inventory: InventoryConfig{
+			TouchInterval: viper.GetDuration("INVENTORY_TOUCH_INTERVAL"),
+		},
+		OTA: OTAConfig{
+			ArtifactDir:      viper.GetString("OTA_ARTIFACT_DIR"),
+			BaseURL:          viper.GetString("OTA_BASE_URL"),
+			MaxArtifactSize:  viper.GetInt64("OTA_MAX_ARTIFACT_SIZE"),
+			FailureThreshold: viper.GetFloat64("OTA_FAILURE_THRESHOLD"),
+			EvaluateInterval: positiveDuration("OTA_EVALUATE_INTERVAL", time.Minute),
+			DeviceTimeout:    viper.GetDuration("OTA_DEVICE_TIMEOUT"),
+			MaxRetryBackoff:  viper.GetDuration("OTA_MAX_RETRY_BACKOFF"),
+		},
+		Provisioning: ProvisioningConfig{
+			Mode:              viper.GetString("PROVISIONING_MODE"),
+			AllowLegacyTopic:  viper.GetBool("PROVISIONING_ALLOW_LEGACY_TOPIC"),
+			SerialLimit:       viper.GetInt("PROVISIONING_SERIAL_LIMIT"),
+			SerialWindow:      viper.GetDuration("PROVISIONING_SERIAL_WINDOW"),
+			GlobalLimit:       viper.GetInt("PROVISIONING_GLOBAL_LIMIT"),
+			RequireEncryption: viper.GetBool("PROVISIONING_REQUIRE_ENCRYPTION"),
+		},
+		Credentials: CredentialsConfig{
+			RotationInterval: viper.GetDuration("CREDENTIALS_ROTATION_INTERVAL"),
+			CheckInterval:    positiveDuration("CREDENTIALS_CHECK_INTERVAL", 10*time.Minute),
+			ConfirmTimeout:   viper.GetDuration("CREDENTIALS_CONFIRM_TIMEOUT"),
+			BatchSize:        viper.GetInt("CREDENTIALS_BATCH_SIZE"),
+		},
+		PKI: PKIConfig{
+			CACertFile:   viper.GetString("PKI_CA_CERT_FILE"),
+			CAKeyFile:    viper.GetString("PKI_CA_KEY_FILE"),
+			Organization: viper.GetString("PKI_ORGANIZATION"),
+			Validity:     viper.GetDuration("PKI_CERT_VALIDITY"),
+			CRLValidity:  positiveDuration("PKI_CRL_VALIDITY", 24*time.Hour),
+		},
+		Stream: StreamConfig{
+			HistorySize:    viper.GetInt("STREAM_HISTORY_SIZE"),
+			ClientBuffer:   viper.GetInt("STREAM_CLIENT_BUFFER"),
+			Heartbeat:      positiveDuration("STREAM_HEARTBEAT", 15*time.Second),
+			AllowedOrigins: splitList(viper.GetString("STREAM_ALLOWED_ORIGINS")),
+		},
+		Events: EventsConfig{
+			Streams:      viper.GetBool("EVENT_STREAMS_ENABLED"),
+			StreamPrefix: viper.GetString("EVENT_STREAM_PREFIX"),
+			StreamMaxLen: viper.GetInt64("EVENT_STREAM_MAXLEN"),
+		},
+		Outbox: OutboxConfig{
+			RelayInterval: positiveDuration("OUTBOX_RELAY_INTERVAL", time.Second),
+			BatchSize:     viper.GetInt("OUTBOX_BATCH_SIZE"),
+			Grace:         viper.GetDuration("OUTBOX_GRACE"),
+			MaxBackoff:    viper.GetDuration("OUTBOX_MAX_BACKOFF"),
+			Retention:     viper.GetDuration("OUTBOX_RETENTION"),
+		},
+		Export: ExportConfig{
+			Sink:        viper.GetString("EXPORT_SINK"),
+			Brokers:     splitList(viper.GetString("EXPORT_BROKERS")),
+			TopicPrefix: viper.GetString("EXPORT_TOPIC_PREFIX"),
+			Format:      viper.GetString("EXPORT_FORMAT"),
+			Channels:    splitList(viper.GetString("EXPORT_CHANNELS")),
+		},
+		Validation: ValidationConfig{
+			Enabled:   viper.GetBool("VALIDATION_ENABLED"),
+			MaxFuture: viper.GetDuration("VALIDATION_MAX_FUTURE"),
+			MaxPast:   viper.GetDuration("VALIDATION_MAX_PAST"),
+		},
+		Clock: ClockConfig{
+			MaxSkew:          viper.GetDuration("CLOCK_MAX_SKEW"),
+			BrokenSkew:       viper.GetDuration("CLOCK_BROKEN_SKEW"),
+			CorrectionWindow: viper.GetDuration("CLOCK_CORRECTION_WINDOW"),
+		},
+	}
+}
+
+// positiveDuration reads a duration used as a ticker interval. Tickers panic
+// on intervals that aren't positive, so those fall back to fallback.
+func positiveDuration(key string, fallback time.Duration) time.Duration {
+	d := viper.GetDuration(key)
+	if d <= 0 {
+		log.Printf("Invalid %s %q, using %v", key, viper.GetString(key), fallback)
+		return fallback
+	}
+	return d
+}
+
+// positiveInt reads a count that must be at least one, falling back to
+// fallback otherwise.
+func positiveInt(key string, fallback int) int {
+	n := viper.GetInt(key)
+	if n <= 0 {
+		log.Printf("Invalid %s %q, using %d", key, viper.GetString(key), fallback)
+		return fallback
+	}
+	return n
+}
+
+// parseDurations parses "name=duration" pairs separated by commas.
+func parseDurations(value string) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, item := range splitList(value) {
+		name, raw, ok := strings.Cut(item, "=")
+		if !ok {
+			log.Printf("Ignoring malformed duration %q", item)
+			continue
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil {
+			log.Printf("Ignoring malformed duration %q: %v", item, err)
+			continue
+		}
+		if d <= 0 {
+			log.Printf("Ignoring duration %q that isn't positive", item)
+			continue
+		}
+		durations[strings.TrimSpace(name)] = d
+	}
+	return durations
+}
+
+// parseAPIKeys parses "key,key=hospital,..." into a map of API key to the
+// hospital it is scoped to. Keys without a hospital are fleet-wide.
+func parseAPIKeys(value string) map[string]string {
+	keys := make(map[string]string)
+	for _, item := range splitList(value) {
+		key, hospital, _ := strings.Cut(item, "=")
+		if key = strings.TrimSpace(key); key == "" {
+			log.Printf("Ignoring API key entry %q without a key", item)
+			continue
+		}
+		keys[key] = strings.TrimSpace(hospital)
+	}
+	return keys
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
\ No newline at end of file
@@ -0,0 +1,395 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"medical-gas-transport-service/internal/clock"
+	"medical-gas-transport-service/internal/services"
+)
+
+const backfillChannel = "sensor:backfill"
+
+// BackfillResult summarizes one bulk insert of historical readings. Failed
+// readings couldn't be stored for now; a device should keep its buffer and
+// resend it when Failed is non-zero.
+type BackfillResult struct {
+	Received   int        `json:"received"`
+	Inserted   int        `json:"inserted"`
+	Duplicates int        `json:"duplicates"`
+	Invalid    int        `json:"invalid"`
+	Failed     int        `json:"failed"`
+	From       *time.Time `json:"from,omitempty"`
+	To         *time.Time `json:"to,omitempty"`
+}
+
+// recordInserted counts a stored reading and widens the stored range.
+func (r *BackfillResult) recordInserted(t time.Time) {
+	r.Inserted++
+	r.widen(&t)
+}
+
+// add counts the stored and duplicate readings of a batch.
+func (r *BackfillResult) add(batch BackfillResult) {
+	r.Inserted += batch.Inserted
+	r.Duplicates += batch.Duplicates
+	r.widen(batch.From)
+	r.widen(batch.To)
+}
+
+func (r *BackfillResult) widen(t *time.Time) {
+	if t == nil {
+		return
+	}
+	if r.From == nil || t.Before(*r.From) {
+		r.From = t
+	}
+	if r.To == nil || t.After(*r.To) {
+		r.To = t
+	}
+}
+
+// BackfillEvent announces one insert batch of backfilled readings.
+type BackfillEvent struct {
+	SerialNumber string         `json:"serial_number"`
+	Type         string         `json:"type"`
+	Result       BackfillResult `json:"result"`
+}
+
+// timestamped is a reading that carries a device timestamp.
+type timestamped interface {
+	deviceTimestamp() time.Time
+}
+
+func (d SensorLevelData) deviceTimestamp() time.Time    { return clock.FromUnix(d.Ts, d.TsUnit) }
+func (d SensorFlowData) deviceTimestamp() time.Time     { return clock.FromUnix(d.Ts, d.TsUnit) }
+func (d SensorPressureData) deviceTimestamp() time.Time { return clock.FromUnix(d.Ts, d.TsUnit) }
+
+// sortByDeviceTime orders readings oldest first. Readings of one batch may
+// use different timestamp units, so Ts can't be compared directly.
+func sortByDeviceTime[T timestamped](readings []T) {
+	sort.SliceStable(readings, func(i, j int) bool {
+		return readings[i].deviceTimestamp().Before(readings[j].deviceTimestamp())
+	})
+}
+
+func isBatchPayload(payload []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(payload), []byte("["))
+}
+
+// handleSensorBatch processes an array of readings sent on a live topic.
+// Only the newest reading is handled as live, including clock tracking; the
+// rest are stored as historical readings.
+func (s *Service) handleSensorBatch(topic string, payload []byte, receivedAt time.Time) {
+	kind := topic[strings.LastIndex(topic, "/")+1:]
+	serialNumber, device, ok := s.backfillDevice(topic)
+	if !ok {
+		return
+	}
+
+	s.touchDevice(serialNumber, kind)
+
+	var raws []json.RawMessage
+	if err := json.Unmarshal(payload, &raws); err != nil {
+		log.Printf("Error unmarshaling sensor %s batch: %v", kind, err)
+		return
+	}
+
+	switch kind {
+	case "level":
+		readings, invalid := decodeValid[SensorLevelData](s, serialNumber, topic, kind, raws, receivedAt)
+		if len(readings) == 0 {
+			return
+		}
+		sortByDeviceTime(readings)
+		last := len(readings) - 1
+		s.reportBackfill(serialNumber, kind, withInvalid(s.backfillLevel(serialNumber, device, readings[:last], receivedAt), invalid))
+		readings[last].ReceivedAt = receivedAt
+		readings[last].ClockOffset = s.observeDeviceTime(serialNumber, readings[last].deviceTimestamp(), receivedAt)
+		s.processSensorLevel(serialNumber, device, readings[last])
+	case "flow":
+		readings, invalid := decodeValid[SensorFlowData](s, serialNumber, topic, kind, raws, receivedAt)
+		if len(readings) == 0 {
+			return
+		}
+		sortByDeviceTime(readings)
+		last := len(readings) - 1
+		s.reportBackfill(serialNumber, kind, withInvalid(s.backfillFlow(serialNumber, device, readings[:last], receivedAt), invalid))
+		readings[last].ReceivedAt = receivedAt
+		readings[last].ClockOffset = s.observeDeviceTime(serialNumber, readings[last].deviceTimestamp(), receivedAt)
+		s.processSensorFlow(serialNumber, device, readings[last])
+	case "pressure":
+		readings, invalid := decodeValid[SensorPressureData](s, serialNumber, topic, kind, raws, receivedAt)
+		if len(readings) == 0 {
+			return
+		}
+		sortByDeviceTime(readings)
+		last := len(readings) - 1
+		s.reportBackfill(serialNumber, kind, withInvalid(s.backfillPressure(serialNumber, device, readings[:last], receivedAt), invalid))
+		readings[last].ReceivedAt = receivedAt
+		readings[last].ClockOffset = s.observeDeviceTime(serialNumber, readings[last].deviceTimestamp(), receivedAt)
+		s.processSensorPressure(serialNumber, device, readings[last])
+	default:
+		log.Printf("Unknown topic: %s", topic)
+	}
+}
+
+// HandleBackfill stores readings a device buffered while it was offline.
+// Backfilled readings are stored and announced on sensor:backfill once per
+// insert batch, through the outbox in the transaction that stores them, but
+// aren't announced one by one since consumers of the reading channels treat
+// those as live. They don't count as liveness, health, inventory or alarm
+// input since they describe the past. The device gets the counts on
+// backfill-response so it can drop its buffer. Timestamps are stored as reported: the skew a buffered reading
+// was taken with is unknown, so clock correction only applies to live ones.
+func (s *Service) HandleBackfill(topic string, payload []byte, receivedAt time.Time) {
+	serialNumber, device, ok := s.backfillDevice(topic)
+	if !ok {
+		return
+	}
+
+	var backfill BackfillPayload
+	if err := json.Unmarshal(payload, &backfill); err != nil {
+		log.Printf("Error unmarshaling backfill payload: %v", err)
+		return
+	}
+
+	response := BackfillResponse{Nonce: backfill.Nonce}
+	if len(backfill.Level) > 0 {
+		readings, invalid := decodeValid[SensorLevelData](s, serialNumber, topic, "level", backfill.Level, receivedAt)
+		r := withInvalid(s.backfillLevel(serialNumber, device, readings, receivedAt), invalid)
+		s.reportBackfill(serialNumber, "level", r)
+		response.Level = &r
+	}
+	if len(backfill.Flow) > 0 {
+		readings, invalid := decodeValid[SensorFlowData](s, serialNumber, topic, "flow", backfill.Flow, receivedAt)
+		sortByDeviceTime(readings)
+		r := withInvalid(s.backfillFlow(serialNumber, device, readings, receivedAt), invalid)
+		s.reportBackfill(serialNumber, "flow", r)
+		response.Flow = &r
+	}
+	if len(backfill.Pressure) > 0 {
+		readings, invalid := decodeValid[SensorPressureData](s, serialNumber, topic, "pressure", backfill.Pressure, receivedAt)
+		r := withInvalid(s.backfillPressure(serialNumber, device, readings, receivedAt), invalid)
+		s.reportBackfill(serialNumber, "pressure", r)
+		response.Pressure = &r
+	}
+
+	responseTopic := fmt.Sprintf("JI/v2/%s/backfill-response", serialNumber)
+	if err := s.publishMQTT(responseTopic, 1, response); err != nil {
+		log.Printf("Error publishing backfill response for device %s: %v", serialNumber, err)
+	}
+}
+
+// withInvalid adds readings rejected before the backfill to its result.
+func withInvalid(result BackfillResult, invalid int) BackfillResult {
+	result.Received += invalid
+	result.Invalid += invalid
+	return result
+}
+
+func (s *Service) backfillDevice(topic string) (string, *services.Device, bool) {
+	serialNumber, err := extractSerialNumberFromTopic(topic)
+	if err != nil {
+		log.Printf("Error extracting serial number: %v", err)
+		return "", nil, false
+	}
+
+	device, err := s.getDeviceFromCacheOrService(serialNumber)
+	if err != nil {
+		log.Printf("Error getting device info: %v", err)
+		return "", nil, false
+	}
+	if device == nil {
+		log.Printf("Device not found for serial number: %s", serialNumber)
+		return "", nil, false
+	}
+	return serialNumber, device, true
+}
+
+func (s *Service) reportBackfill(serialNumber, kind string, result BackfillResult) {
+	if result.Received == 0 {
+		return
+	}
+	log.Printf("Backfilled %d of %d %s readings for device %s (%d duplicates, %d invalid, %d failed)",
+		result.Inserted, result.Received, kind, serialNumber, result.Duplicates, result.Invalid, result.Failed)
+}
+
+// backfillMessages returns the outbox messages announcing a stored batch.
+func (s *Service) backfillMessages(serialNumber, kind string, result BackfillResult) ([]outboxMessage, error) {
+	payload, err := json.Marshal(BackfillEvent{SerialNumber: serialNumber, Type: kind, Result: result})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling backfill event: %w", err)
+	}
+	return s.eventMessages(backfillChannel, payload), nil
+}
+
+func (s *Service) backfillLevel(serialNumber string, device *services.Device, readings []SensorLevelData, receivedAt time.Time) BackfillResult {
+	result := BackfillResult{Received: len(readings)}
+	var rows [][]interface{}
+	for _, levelData := range readings {
+		if levelData.Level < 0 {
+			result.Invalid++
+			continue
+		}
+		levelData.SerialNumber = serialNumber
+		levelData.DeviceTime = clock.FromUnix(levelData.Ts, levelData.TsUnit)
+		levelData.Timestamp = levelData.DeviceTime
+		levelData.ReceivedAt = receivedAt
+
+		var LevelInKilograms, LevelInMetersCubics *float64
+		kg, m3, err := s.convertLevel(serialNumber, device, levelData.Level)
+		switch {
+		case err == nil:
+			LevelInKilograms, LevelInMetersCubics = &kg, &m3
+		case !isConversionError(err):
+			log.Printf("Error getting conversion table: %v", err)
+			result.Failed = result.Received - result.Invalid
+			return result
+		}
+		rows = append(rows, levelRow(levelData, LevelInKilograms, LevelInMetersCubics, true))
+	}
+
+	s.insertBackfill(serialNumber, "level", "sensor_level", levelColumns, rows, &result)
+	return result
+}
+
+// backfillFlow stores readings in one transaction, each with its deltas
+// derived from its stored neighbours, and announces them in the same
+// transaction. It expects readings sorted by time so the totalizer advances
+// in order. A reading that fails is rolled back to its savepoint and
+// counted, so it doesn't take the rest of the batch with it.
+func (s *Service) backfillFlow(serialNumber string, device *services.Device, readings []SensorFlowData, receivedAt time.Time) BackfillResult {
+	result := BackfillResult{Received: len(readings)}
+	var msgs []outboxMessage
+	err := s.inTransaction(time.Minute, func(ctx context.Context, tx *sql.Tx) error {
+		result = BackfillResult{Received: len(readings)}
+		for _, flowData := range readings {
+			flowData.SerialNumber = serialNumber
+			flowData.DeviceTime = clock.FromUnix(flowData.Ts, flowData.TsUnit)
+			flowData.Timestamp = flowData.DeviceTime
+			flowData.ReceivedAt = receivedAt
+			flowData.TotalVolume = (flowData.VHi * 65536) + (flowData.VLo) + (flowData.VDec / 1000)
+			flowData.FlowRate = ((flowData.FRateHi * 65536) + flowData.FRateLo) / 1000
+
+			if _, err := tx.ExecContext(ctx, `SAVEPOINT backfill_reading`); err != nil {
+				return fmt.Errorf("error creating savepoint: %w", err)
+			}
+			inserted, err := s.insertFlowReading(ctx, tx, device, &flowData, true)
+			if err != nil {
+				log.Printf("Error backfilling sensor_flow for device %s at %v: %v", serialNumber, flowData.Timestamp, err)
+				result.Failed++
+				if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT backfill_reading`); err != nil {
+					return fmt.Errorf("error rolling back to savepoint: %w", err)
+				}
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT backfill_reading`); err != nil {
+				return fmt.Errorf("error releasing savepoint: %w", err)
+			}
+			if inserted {
+				result.recordInserted(flowData.Timestamp)
+			} else {
+				result.Duplicates++
+			}
+		}
+		if result.Inserted == 0 {
+			return nil
+		}
+
+		stored, err := s.backfillMessages(serialNumber, "flow", result)
+		if err != nil {
+			return err
+		}
+		if err := s.insertOutbox(ctx, tx, stored); err != nil {
+			return err
+		}
+		msgs = stored
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error backfilling sensor_flow for device %s: %v", serialNumber, err)
+		return BackfillResult{Received: len(readings), Failed: len(readings)}
+	}
+
+	s.deliverOutbox(msgs)
+	return result
+}
+
+func (s *Service) backfillPressure(serialNumber string, device *services.Device, readings []SensorPressureData, receivedAt time.Time) BackfillResult {
+	result := BackfillResult{Received: len(readings)}
+	var rows [][]interface{}
+	for _, pressureData := range readings {
+		pressureData.SerialNumber = serialNumber
+		pressureData.DeviceTime = clock.FromUnix(pressureData.Ts, pressureData.TsUnit)
+		pressureData.Timestamp = pressureData.DeviceTime
+		pressureData.ReceivedAt = receivedAt
+		rows = append(rows, pressureRow(pressureData, true))
+	}
+
+	s.insertBackfill(serialNumber, "pressure", "sensor_pressure", pressureColumns, rows, &result)
+	return result
+}
+
+// insertBackfill bulk inserts rows, skipping readings that are already
+// stored, and announces every batch that stored any.
+func (s *Service) insertBackfill(serialNumber, kind, table string, columns []string, rows [][]interface{}, result *BackfillResult) {
+	// Postgres allows at most 65535 parameters per statement.
+	batchSize := min(65535/len(columns), 1000)
+
+	for start := 0; start < len(rows); start += batchSize {
+		batch := rows[start:min(start+batchSize, len(rows))]
+		var args []interface{}
+		for _, row := range batch {
+			args = append(args, row...)
+		}
+
+		var stored BackfillResult
+		_, err := s.writeWithOutboxTx(func(ctx context.Context, tx *sql.Tx) ([]outboxMessage, bool, error) {
+			times, err := insertReturningTimes(ctx, tx, insertStatement(table, columns, len(batch))+"RETURNING time", args)
+			if err != nil {
+				return nil, false, err
+			}
+			stored = BackfillResult{Received: len(batch), Duplicates: len(batch) - len(times)}
+			for _, t := range times {
+				stored.recordInserted(t)
+			}
+			if stored.Inserted == 0 {
+				return nil, false, nil
+			}
+			msgs, err := s.backfillMessages(serialNumber, kind, stored)
+			return msgs, true, err
+		})
+		if err != nil {
+			log.Printf("Error backfilling %s: %v", table, err)
+			result.Failed += len(batch)
+			continue
+		}
+		result.add(stored)
+	}
+}
+
+func insertReturningTimes(ctx context.Context, tx *sql.Tx, query string, args []interface{}) ([]time.Time, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var times []time.Time
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return nil, fmt.Errorf("error scanning inserted row: %w", err)
+		}
+		times = append(times, t)
+	}
+	return times, rows.Err()
+}
@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// notFoundMarker is stored in Redis for negative entries so other instances
+// also skip the upstream lookup while the entry is alive.
+const notFoundMarker = "__not_found__"
+
+// fetchTimeout bounds a load shared by concurrent callers, which runs
+// detached from any one caller's context so a caller that gives up doesn't
+// fail the others.
+const fetchTimeout = 30 * time.Second
+
+type Loader[T any] func(ctx context.Context, key string) (T, error)
+
+type Options struct {
+	// Prefix is prepended to the key to build the Redis key, e.g. "device/".
+	Prefix      string
+	Size        int
+	LocalTTL    time.Duration
+	StaleTTL    time.Duration
+	RedisTTL    time.Duration
+	NegativeTTL time.Duration
+}
+
+// Cache is a two tier cache: an in-process LRU in front of Redis, backed by
+// a loader for the source of truth. Concurrent misses for the same key are
+// collapsed into one load, loader errors matching NotFound are cached for
+// NegativeTTL, and entries older than LocalTTL are still served for StaleTTL
+// while a background refresh runs.
+type Cache[T any] struct {
+	opts     Options
+	local    *LRU
+	rdb      *redis.Client
+	load     Loader[T]
+	notFound error
+	group    singleflight.Group
+
+	refreshing sync.Map
+
+	// gens counts invalidations per key. A fetch only stores its result if
+	// the key wasn't invalidated while it ran, so a load that read the old
+	// value can't put it back after Invalidate.
+	mu   sync.Mutex
+	gens map[string]uint64
+}
+
+func New[T any](rdb *redis.Client, opts Options, load Loader[T], notFound error) *Cache[T] {
+	return &Cache[T]{
+		opts:     opts,
+		local:    NewLRU(opts.Size),
+		rdb:      rdb,
+		load:     load,
+		notFound: notFound,
+		gens:     make(map[string]uint64),
+	}
+}
+
+func (c *Cache[T]) Get(ctx context.Context, key string) (T, error) {
+	if e, ok := c.local.Get(key); ok {
+		if time.Now().After(e.freshUntil) {
+			if _, busy := c.refreshing.LoadOrStore(key, struct{}{}); !busy {
+				go c.refresh(key)
+			}
+		}
+		return c.result(e)
+	}
+
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		fetchCtx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+		e, err := c.fetch(fetchCtx, key)
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	})
+
+	var zero T
+	select {
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case r := <-ch:
+		if r.Err != nil {
+			return zero, r.Err
+		}
+		return c.result(r.Val.(entry))
+	}
+}
+
+// Invalidate evicts key from both the local and the Redis tier.
+func (c *Cache[T]) Invalidate(ctx context.Context, key string) error {
+	c.InvalidateLocal(key)
+	return c.rdb.Del(ctx, c.opts.Prefix+key).Err()
+}
+
+// InvalidateLocal evicts key from the in-process tier only.
+func (c *Cache[T]) InvalidateLocal(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[key]++
+	c.group.Forget(key)
+	c.local.Delete(key)
+}
+
+func (c *Cache[T]) generation(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gens[key]
+}
+
+// current reports whether key hasn't been invalidated since gen was read.
+func (c *Cache[T]) current(key string, gen uint64) bool {
+	return c.generation(key) == gen
+}
+
+func (c *Cache[T]) result(e entry) (T, error) {
+	if e.notFound {
+		var zero T
+		return zero, c.notFound
+	}
+	return e.value.(T), nil
+}
+
+func (c *Cache[T]) refresh(key string) {
+	defer c.refreshing.Delete(key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	_, err, _ := c.group.Do(key, func() (interface{}, error) {
+		return c.fetch(ctx, key)
+	})
+	if err != nil {
+		log.Printf("Error refreshing cache entry %s%s: %v", c.opts.Prefix, key, err)
+	}
+}
+
+func (c *Cache[T]) fetch(ctx context.Context, key string) (entry, error) {
+	redisKey := c.opts.Prefix + key
+	gen := c.generation(key)
+
+	raw, err := c.rdb.Get(ctx, redisKey).Result()
+	switch {
+	case err == nil && raw == notFoundMarker:
+		return c.store(key, gen, nil, true, c.opts.NegativeTTL), nil
+	case err == nil:
+		var value T
+		if err := json.Unmarshal([]byte(raw), &value); err == nil {
+			return c.store(key, gen, value, false, c.opts.LocalTTL), nil
+		}
+		log.Printf("Error parsing cached %s, reloading: %v", redisKey, err)
+	case err != redis.Nil:
+		log.Printf("Error reading %s from Redis, falling back to loader: %v", redisKey, err)
+	}
+
+	value, err := c.load(ctx, key)
+	if err != nil {
+		if c.notFound != nil && errors.Is(err, c.notFound) {
+			if c.current(key, gen) {
+				c.rdb.Set(ctx, redisKey, notFoundMarker, c.opts.NegativeTTL)
+			}
+			return c.store(key, gen, nil, true, c.opts.NegativeTTL), nil
+		}
+		return entry{}, err
+	}
+
+	if encoded, err := json.Marshal(value); err == nil && c.current(key, gen) {
+		c.rdb.Set(ctx, redisKey, encoded, c.opts.RedisTTL)
+	}
+	return c.store(key, gen, value, false, c.opts.LocalTTL), nil
+}
+
+// store caches the entry locally unless key was invalidated after gen was
+// read, and returns it either way so the callers waiting on it get a result.
+func (c *Cache[T]) store(key string, gen uint64, value interface{}, notFound bool, ttl time.Duration) entry {
+	now := time.Now()
+	e := entry{
+		key:        key,
+		value:      value,
+		notFound:   notFound,
+		freshUntil: now.Add(ttl),
+		staleUntil: now.Add(ttl),
+	}
+	if !notFound {
+		e.staleUntil = e.freshUntil.Add(c.opts.StaleTTL)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gens[key] == gen {
+		c.local.Set(e)
+	}
+	return e
+}
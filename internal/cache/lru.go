@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	key        string
+	value      interface{}
+	notFound   bool
+	freshUntil time.Time
+	staleUntil time.Time
+}
+
+// LRU is a size bounded in-process cache. Entries carry their own fresh and
+// stale deadlines so callers can serve stale values while they revalidate.
+type LRU struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func NewLRU(capacity int) *LRU {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &LRU{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the entry for key if it has not passed its stale deadline.
+func (c *LRU) Get(key string) (entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return entry{}, false
+	}
+
+	e := el.Value.(*entry)
+	if time.Now().After(e.staleUntil) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return entry{}, false
+	}
+
+	c.ll.MoveToFront(el)
+	return *e, true
+}
+
+func (c *LRU) Set(e entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[e.key]; ok {
+		*el.Value.(*entry) = e
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[e.key] = c.ll.PushFront(&e)
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry).key)
+	}
+}
+
+func (c *LRU) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"medical-gas-transport-service/internal/pki"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// HandleCertificateProvisioning signs a client certificate for a device that
+// submitted a CSR on provisioning/csr. The request goes through the same
+// allowlist, rate limits, nonce topic and audit as password provisioning.
+func (s *Service) HandleCertificateProvisioning(payload []byte) {
+	var req CertificateProvisionRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		log.Printf("error unmarshaling JSON: %v\n", err)
+		return
+	}
+
+	provisionRequest := ProvisionRequest{
+		SerialNumber: req.SerialNumber,
+		ClaimToken:   req.ClaimToken,
+		Nonce:        req.Nonce,
+	}
+	log.Printf("Received certificate provisioning request from %s", req.SerialNumber)
+
+	claimed := false
+	reject := func(responseTopic, reason string) {
+		if claimed {
+			s.releaseClaim(req.SerialNumber)
+		}
+		s.auditProvisioning(provisionRequest, "certificate", "rejected", reason)
+		log.Printf("Rejected certificate provisioning request from %s: %s", req.SerialNumber, reason)
+		if responseTopic != "" && reason != "rate limited" {
+			s.publishCertificateResponse(responseTopic, CertificateProvisionResponseData{Status: "rejected", Reason: reason})
+		}
+	}
+
+	if s.ca == nil {
+		reject("", "certificate provisioning is not configured")
+		return
+	}
+
+	responseTopic, reason := s.authorizeProvisioning(provisionRequest)
+	if reason != "" {
+		reject(responseTopic, reason)
+		return
+	}
+	claimed = true
+
+	if _, err := s.getDeviceFromCacheOrService(req.SerialNumber); err != nil {
+		reject(responseTopic, "device not registered in Jaya")
+		return
+	}
+
+	csr, err := pki.ParseCSR([]byte(req.CSR))
+	if err != nil {
+		reject(responseTopic, err.Error())
+		return
+	}
+	if csr.Subject.CommonName != req.SerialNumber {
+		reject(responseTopic, "CSR common name does not match serial number")
+		return
+	}
+
+	issued, err := s.ca.SignClientCertificate(csr, req.SerialNumber, s.cfg.PKI.Validity)
+	if err != nil {
+		s.releaseClaim(req.SerialNumber)
+		s.auditProvisioning(provisionRequest, "certificate", "error", err.Error())
+		log.Printf("error signing certificate for device %s: %v", req.SerialNumber, err)
+		return
+	}
+
+	err = s.writeToTimescaleDBWithRetry(`
+		INSERT INTO device_certificate (serial_number, cert_serial, fingerprint, not_after, issued_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, req.SerialNumber, issued.SerialNumber, issued.Fingerprint, issued.NotAfter, time.Now())
+	if err != nil {
+		s.releaseClaim(req.SerialNumber)
+		s.auditProvisioning(provisionRequest, "certificate", "error", err.Error())
+		log.Printf("error recording certificate for device %s: %v", req.SerialNumber, err)
+		return
+	}
+
+	s.publishCertificateResponse(responseTopic, CertificateProvisionResponseData{
+		Status:      "success",
+		Certificate: string(issued.CertificatePEM),
+		CAChain:     string(issued.ChainPEM),
+		ExpiresAt:   issued.NotAfter,
+	})
+	s.auditProvisioning(provisionRequest, "certificate", "provisioned", "")
+	log.Printf("Issued certificate %s for device %s, valid until %v", issued.SerialNumber, req.SerialNumber, issued.NotAfter)
+}
+
+func (s *Service) publishCertificateResponse(topic string, data CertificateProvisionResponseData) {
+	p, err := json.Marshal(CertificateProvisionResponse{Pattern: topic, Data: data})
+	if err != nil {
+		log.Printf("error building JSON: %v", err)
+		return
+	}
+	s.mqttClient.Client.Publish(s.ctx, &paho.Publish{
+		Topic:   topic,
+		QoS:     2,
+		Payload: p,
+	})
+}
+
+// handleCRL serves the CA's revocation list of device certificates in DER
+// form on GET /pki/crl. Revoking a device only sets revoked_at; the MQTT
+// broker sees it by fetching this list, e.g. into mosquitto's crlfile or
+// EMQX's CRL cache, more often than PKI_CRL_VALIDITY. The list is signed, so
+// it is served without an API key.
+func (s *Service) handleCRL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if s.ca == nil {
+		writeError(w, http.StatusNotFound, "certificate provisioning is not configured")
+		return
+	}
+
+	// Expired certificates are rejected anyway and can leave the list.
+	rows, err := s.timescaleClient.DB.QueryContext(r.Context(), `
+		SELECT cert_serial, revoked_at FROM device_certificate
+		WHERE revoked_at IS NOT NULL AND not_after > NOW()
+		ORDER BY revoked_at
+	`)
+	if err != nil {
+		log.Printf("Error querying revoked certificates: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to build CRL")
+		return
+	}
+	defer rows.Close()
+
+	var revoked []pki.Revoked
+	for rows.Next() {
+		var r pki.Revoked
+		if err := rows.Scan(&r.SerialNumber, &r.RevokedAt); err != nil {
+			log.Printf("Error scanning revoked certificate: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to build CRL")
+			return
+		}
+		revoked = append(revoked, r)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error querying revoked certificates: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to build CRL")
+		return
+	}
+
+	crl, err := s.ca.CreateCRL(revoked, time.Now().Unix(), s.cfg.PKI.CRLValidity)
+	if err != nil {
+		log.Printf("Error creating CRL: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to build CRL")
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Write(crl)
+}
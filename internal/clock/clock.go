@@ -0,0 +1,133 @@
+// Package clock tracks how far a device's clock is from the server clock
+// and decides when its timestamps can be corrected.
+package clock
+
+import (
+	"fmt"
+	"time"
+)
+
+type Thresholds struct {
+	// MaxSkew is the skew tolerated before a clock counts as skewed. It also
+	// absorbs network latency and jitter.
+	MaxSkew time.Duration
+	// BrokenSkew is the skew beyond which the clock is considered broken,
+	// e.g. a dead RTC battery reporting 1970.
+	BrokenSkew time.Duration
+	// CorrectionWindow is the largest skew that is corrected. Zero disables
+	// correction.
+	CorrectionWindow time.Duration
+}
+
+// State is what Observe needs to remember between readings of one device.
+type State struct {
+	// Skew is the smoothed device time minus receive time; positive means
+	// the device clock is ahead.
+	Skew           time.Duration `json:"skew"`
+	Samples        int           `json:"samples"`
+	LastDeviceTime time.Time     `json:"last_device_time"`
+	LastReceivedAt time.Time     `json:"last_received_at"`
+	Skewed         bool          `json:"skewed"`
+	Broken         bool          `json:"broken"`
+}
+
+type Event struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+type Result struct {
+	State  State
+	Events []Event
+	// Offset is subtracted from the device time to correct it, zero when
+	// the reading is not corrected.
+	Offset time.Duration
+}
+
+// smoothing is the inverse weight of a new sample in the smoothed skew.
+const smoothing = 8
+
+// Observe updates the skew of a device from a reading's device time and the
+// time the broker delivered it, and reports clocks turning skewed, broken or
+// back to normal.
+func Observe(prev *State, deviceTime, receivedAt time.Time, t Thresholds) Result {
+	var state State
+	if prev != nil {
+		state = *prev
+	}
+
+	skew := deviceTime.Sub(receivedAt)
+	if state.Samples == 0 || abs(skew-state.Skew) > t.MaxSkew {
+		// First reading, or the clock jumped (reset or resynced).
+		state.Skew = skew
+	} else {
+		state.Skew += (skew - state.Skew) / smoothing
+	}
+	state.Samples++
+	state.LastDeviceTime = deviceTime
+	state.LastReceivedAt = receivedAt
+
+	wasSkewed, wasBroken := state.Skewed, state.Broken
+	state.Broken = abs(state.Skew) > t.BrokenSkew
+	state.Skewed = !state.Broken && abs(state.Skew) > t.MaxSkew
+
+	result := Result{State: state}
+	detail := fmt.Sprintf("device clock %s by %s", direction(state.Skew), abs(state.Skew).Round(time.Second))
+	switch {
+	case state.Broken && !wasBroken:
+		result.Events = append(result.Events, Event{Type: "clock_broken", Detail: detail})
+	case state.Skewed && !wasSkewed:
+		result.Events = append(result.Events, Event{Type: "clock_skewed", Detail: detail})
+	case !state.Broken && !state.Skewed && (wasBroken || wasSkewed):
+		result.Events = append(result.Events, Event{Type: "clock_recovered", Detail: detail})
+	}
+
+	// The offset is rounded to whole seconds: a resent reading is then
+	// corrected to the same time and still deduplicated, and the device's
+	// sub-second precision is kept.
+	if t.CorrectionWindow > 0 && abs(state.Skew) > t.MaxSkew && abs(state.Skew) <= t.CorrectionWindow {
+		result.Offset = state.Skew.Round(time.Second)
+	}
+	return result
+}
+
+func direction(skew time.Duration) string {
+	if skew < 0 {
+		return "behind"
+	}
+	return "ahead"
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Units are the timestamp units a payload may name in tsUnit.
+var Units = []string{"s", "ms", "us"}
+
+// FromUnix converts a device timestamp in unit to a time. When unit is empty
+// or unknown it is inferred from the magnitude: values below 1e11 are
+// seconds (until the year 5138), below 1e14 milliseconds and microseconds
+// beyond.
+func FromUnix(ts int64, unit string) time.Time {
+	switch unit {
+	case "s":
+		return time.Unix(ts, 0)
+	case "ms":
+		return time.UnixMilli(ts)
+	case "us":
+		return time.UnixMicro(ts)
+	}
+
+	switch {
+	case ts < 1e11:
+		return time.Unix(ts, 0)
+	case ts < 1e14:
+		return time.UnixMilli(ts)
+	default:
+		return time.UnixMicro(ts)
+	}
+}
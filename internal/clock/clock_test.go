@@ -0,0 +1,90 @@
+package clock
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	received   = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	thresholds = Thresholds{MaxSkew: 5 * time.Second, BrokenSkew: 24 * time.Hour, CorrectionWindow: time.Hour}
+)
+
+func eventTypes(events []Event) []string {
+	types := []string{}
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestObserve(t *testing.T) {
+	tests := []struct {
+		name   string
+		skews  []time.Duration
+		th     Thresholds
+		events []string
+		skew   time.Duration
+		offset time.Duration
+	}{
+		{"in sync", []time.Duration{time.Second}, thresholds, []string{}, time.Second, 0},
+		{"skewed", []time.Duration{10 * time.Minute}, thresholds, []string{"clock_skewed"}, 10 * time.Minute, 10 * time.Minute},
+		{"skewed once", []time.Duration{10 * time.Minute, 10 * time.Minute}, thresholds, []string{}, 10 * time.Minute, 10 * time.Minute},
+		{"behind", []time.Duration{-10 * time.Minute}, thresholds, []string{"clock_skewed"}, -10 * time.Minute, -10 * time.Minute},
+		{"outside correction window", []time.Duration{2 * time.Hour}, thresholds, []string{"clock_skewed"}, 2 * time.Hour, 0},
+		{"correction disabled", []time.Duration{10 * time.Minute}, Thresholds{MaxSkew: 5 * time.Second, BrokenSkew: 24 * time.Hour}, []string{"clock_skewed"}, 10 * time.Minute, 0},
+		{"broken", []time.Duration{-54 * 365 * 24 * time.Hour}, thresholds, []string{"clock_broken"}, -54 * 365 * 24 * time.Hour, 0},
+		{"recovered after a jump", []time.Duration{10 * time.Minute, 0}, thresholds, []string{"clock_recovered"}, 0, 0},
+		// Small changes are smoothed: 8s + (4s - 8s) / 8 = 7.5s.
+		{"smoothed", []time.Duration{8 * time.Second, 4 * time.Second}, thresholds, []string{}, 7500 * time.Millisecond, 8 * time.Second},
+		{"rounded offset", []time.Duration{90*time.Second + 400*time.Millisecond}, thresholds, []string{"clock_skewed"}, 90*time.Second + 400*time.Millisecond, 90 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prev *State
+			var result Result
+			for i, skew := range tt.skews {
+				at := received.Add(time.Duration(i) * time.Minute)
+				result = Observe(prev, at.Add(skew), at, tt.th)
+				prev = &result.State
+			}
+			if got := eventTypes(result.Events); !reflect.DeepEqual(got, tt.events) {
+				t.Errorf("events = %v, want %v", got, tt.events)
+			}
+			if result.State.Skew != tt.skew {
+				t.Errorf("skew = %v, want %v", result.State.Skew, tt.skew)
+			}
+			if result.Offset != tt.offset {
+				t.Errorf("offset = %v, want %v", result.Offset, tt.offset)
+			}
+			if result.State.Samples != len(tt.skews) {
+				t.Errorf("samples = %d, want %d", result.State.Samples, len(tt.skews))
+			}
+		})
+	}
+}
+
+func TestFromUnix(t *testing.T) {
+	want := time.Unix(1700000000, 0)
+	tests := []struct {
+		ts   int64
+		unit string
+	}{
+		{1700000000, "s"},
+		{1700000000000, "ms"},
+		{1700000000000000, "us"},
+		{1700000000, ""},
+		{1700000000000, ""},
+		{1700000000000000, ""},
+		{1700000000000, "ns"},
+	}
+	for _, tt := range tests {
+		if got := FromUnix(tt.ts, tt.unit); !got.Equal(want) {
+			t.Errorf("FromUnix(%d, %q) = %v, want %v", tt.ts, tt.unit, got, want)
+		}
+	}
+	if got := FromUnix(60, "ms"); !got.Equal(time.Unix(0, 60*int64(time.Millisecond))) {
+		t.Errorf("FromUnix(60, ms) = %v", got)
+	}
+}
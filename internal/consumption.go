@@ -0,0 +1,260 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"medical-gas-transport-service/internal/services"
+)
+
+var consumptionScopes = []string{"hospital", "building", "floor", "room", "bed"}
+
+type ConsumptionRollup struct {
+	Bucket   time.Time `json:"bucket"`
+	Period   string    `json:"period"`
+	Scope    string    `json:"scope"`
+	ScopeKey string    `json:"scope_key"`
+	Hospital string    `json:"hospital"`
+	Building *string   `json:"building"`
+	Floor    *string   `json:"floor"`
+	Room     *string   `json:"room"`
+	Bed      *string   `json:"bed"`
+	Volume   float64   `json:"volume"`
+	Readings int       `json:"readings"`
+}
+
+// consumptionLocation is the location hierarchy of a flow installation point.
+type consumptionLocation struct {
+	Hospital string
+	Building *string
+	Floor    *string
+	Room     *string
+	Bed      *string
+}
+
+func locationForDevice(device *services.Device) consumptionLocation {
+	ipf := device.InstallationPointFlow
+	hospital := ipf.Hospital
+	if hospital == "" {
+		hospital = device.Hospital.ID
+	}
+	return consumptionLocation{
+		Hospital: hospital,
+		Building: ipf.Building,
+		Floor:    ipf.Floor,
+		Room:     ipf.Room,
+		Bed:      ipf.Bed,
+	}
+}
+
+// scopeKeys returns the key for every level of the hierarchy down to the most
+// specific one known. Missing intermediate levels are written as "-" so keys
+// stay unambiguous.
+func (l consumptionLocation) scopeKeys() map[string]string {
+	keys := map[string]string{"hospital": l.Hospital}
+	parts := []string{l.Hospital}
+	levels := []*string{l.Building, l.Floor, l.Room, l.Bed}
+
+	deepest := -1
+	for i, v := range levels {
+		if v != nil {
+			deepest = i
+		}
+	}
+
+	for i := 0; i <= deepest; i++ {
+		part := "-"
+		if levels[i] != nil {
+			part = *levels[i]
+		}
+		parts = append(parts, part)
+		keys[consumptionScopes[i+1]] = strings.Join(parts, "/")
+	}
+	return keys
+}
+
+// atScope returns the location as stored on a rollup row of scope: the
+// levels below the scope are left out so a building row doesn't carry the
+// floor, room and bed of whichever reading created it.
+func (l consumptionLocation) atScope(scope string) consumptionLocation {
+	levels := []**string{&l.Building, &l.Floor, &l.Room, &l.Bed}
+	for i, level := range levels {
+		if containsString(consumptionScopes[:i+1], scope) {
+			*level = nil
+		}
+	}
+	return l
+}
+
+// allocateConsumption adds a flow delta to the hourly and daily rollups of
+// every location level the installation point belongs to. It runs in the
+// transaction that stores the reading so a rollup never counts a reading
+// that wasn't stored, or misses one that was.
+func (s *Service) allocateConsumption(ctx context.Context, tx *sql.Tx, device *services.Device, flowData SensorFlowData) error {
+	if flowData.DeltaVolume <= 0 {
+		return nil
+	}
+	return s.allocateVolume(ctx, tx, device, flowData.SerialNumber, flowData.Timestamp, flowData.DeltaVolume, 1)
+}
+
+// allocateVolume adds volume and a reading count to the rollups of the
+// buckets t falls in. The volume is negative when a stored delta is revised
+// down.
+func (s *Service) allocateVolume(ctx context.Context, tx *sql.Tx, device *services.Device, serialNumber string, t time.Time, volume float64, readings int) error {
+	location := locationForDevice(device)
+	if location.Hospital == "" {
+		log.Printf("Device %s has no hospital to allocate consumption to", serialNumber)
+		return nil
+	}
+
+	local := t.In(s.cfg.Consumption.Location)
+	buckets := map[string]time.Time{
+		"hour": time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, local.Location()),
+		"day":  time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location()),
+	}
+	keys := location.scopeKeys()
+
+	// Rows are always written in the same order so concurrent readings of one
+	// hospital lock the shared rollup rows in the same order and can't
+	// deadlock.
+	var values []string
+	var args []interface{}
+	for _, period := range []string{"hour", "day"} {
+		bucket := buckets[period]
+		for _, scope := range consumptionScopes {
+			key, ok := keys[scope]
+			if !ok {
+				continue
+			}
+			row := location.atScope(scope)
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+				n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10, n+11))
+			args = append(args, bucket, period, scope, key, row.Hospital,
+				row.Building, row.Floor, row.Room, row.Bed, volume, readings)
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO consumption_rollup (
+			bucket, period, scope, scope_key, hospital, building, floor, room, bed, volume, readings
+		) VALUES `+strings.Join(values, ", ")+`
+		ON CONFLICT (period, scope, scope_key, bucket) DO UPDATE
+		SET volume = consumption_rollup.volume + EXCLUDED.volume,
+			readings = consumption_rollup.readings + EXCLUDED.readings
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("error allocating consumption: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) handleConsumption(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	scope := q.Get("scope")
+	if scope == "" {
+		scope = "hospital"
+	}
+	if !containsString(consumptionScopes, scope) {
+		writeError(w, http.StatusBadRequest, "scope must be one of "+strings.Join(consumptionScopes, ", "))
+		return
+	}
+
+	period := q.Get("period")
+	if period == "" {
+		period = "day"
+	}
+	if period != "hour" && period != "day" {
+		writeError(w, http.StatusBadRequest, "period must be hour or day")
+		return
+	}
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, "from must be RFC3339")
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, "to must be RFC3339")
+			return
+		}
+	}
+
+	hospital, ok := scopedHospital(w, r, q.Get("hospital"))
+	if !ok {
+		return
+	}
+
+	limit := 1000
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > 10000 {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 10000")
+			return
+		}
+	}
+
+	query := `
+		SELECT bucket, period, scope, scope_key, hospital, building, floor, room, bed, volume, readings
+		FROM consumption_rollup
+		WHERE period = $1 AND scope = $2 AND bucket >= $3 AND bucket < $4
+		AND ($5 = '' OR hospital = $5)
+		AND ($6 = '' OR scope_key LIKE $6 || '%')
+		ORDER BY bucket, scope_key
+		LIMIT $7
+	`
+	rows, err := s.timescaleClient.DB.QueryContext(r.Context(), query, period, scope, from, to, hospital, escapeLike(q.Get("key_prefix")), limit)
+	if err != nil {
+		log.Printf("Error querying consumption rollups: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to query consumption")
+		return
+	}
+	defer rows.Close()
+
+	rollups := []ConsumptionRollup{}
+	for rows.Next() {
+		var c ConsumptionRollup
+		if err := rows.Scan(&c.Bucket, &c.Period, &c.Scope, &c.ScopeKey, &c.Hospital, &c.Building, &c.Floor, &c.Room, &c.Bed, &c.Volume, &c.Readings); err != nil {
+			log.Printf("Error scanning consumption rollup: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to query consumption")
+			return
+		}
+		rollups = append(rollups, c)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   rollups,
+	})
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
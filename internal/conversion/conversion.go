@@ -0,0 +1,144 @@
+// Package conversion turns tank level readings (inH2O) into liquid mass using
+// the piecewise linear conversion tables maintained in Jaya core.
+package conversion
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+var (
+	ErrInvalidTable = errors.New("invalid conversion table")
+	ErrOutOfRange   = errors.New("reading outside conversion table range")
+)
+
+// Segment converts readings in [Min, Max] with Slope*x + Intercept.
+type Segment struct {
+	Min       float64
+	Max       float64
+	Slope     float64
+	Intercept float64
+}
+
+func (seg Segment) eval(x float64) float64 {
+	return seg.Slope*x + seg.Intercept
+}
+
+type Options struct {
+	// Clamp converts readings outside the table as the nearest table bound
+	// instead of returning ErrOutOfRange.
+	Clamp bool
+	// Interpolate converts by linear interpolation between the segment
+	// boundaries instead of the per segment formula, which removes the steps
+	// between adjacent segments whose formulas do not meet exactly.
+	Interpolate bool
+	// Tolerance is the gap or overlap allowed between adjacent segments.
+	Tolerance float64
+}
+
+type point struct {
+	x, y float64
+}
+
+type Table struct {
+	segments []Segment
+	knots    []point
+	opts     Options
+}
+
+// New validates segments and builds a table. Segments may be given in any
+// order but, once sorted, must be contiguous and non-overlapping.
+func New(segments []Segment, opts Options) (*Table, error) {
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("%w: table is empty", ErrInvalidTable)
+	}
+
+	sorted := make([]Segment, len(segments))
+	copy(sorted, segments)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Min < sorted[j].Min })
+
+	for i, seg := range sorted {
+		for _, v := range []float64{seg.Min, seg.Max, seg.Slope, seg.Intercept} {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, fmt.Errorf("%w: segment %d has a non-finite value", ErrInvalidTable, i)
+			}
+		}
+		if seg.Min >= seg.Max {
+			return nil, fmt.Errorf("%w: segment %d has min %v >= max %v", ErrInvalidTable, i, seg.Min, seg.Max)
+		}
+		if i == 0 {
+			continue
+		}
+
+		prev := sorted[i-1]
+		switch diff := seg.Min - prev.Max; {
+		case diff > opts.Tolerance:
+			return nil, fmt.Errorf("%w: gap between %v and %v", ErrInvalidTable, prev.Max, seg.Min)
+		case diff < -opts.Tolerance:
+			return nil, fmt.Errorf("%w: segments overlap between %v and %v", ErrInvalidTable, seg.Min, prev.Max)
+		}
+	}
+
+	t := &Table{segments: sorted, opts: opts}
+	t.buildKnots()
+	return t, nil
+}
+
+func (t *Table) buildKnots() {
+	first := t.segments[0]
+	t.knots = append(t.knots, point{first.Min, first.eval(first.Min)})
+	for i := 0; i < len(t.segments)-1; i++ {
+		cur, next := t.segments[i], t.segments[i+1]
+		x := (cur.Max + next.Min) / 2
+		t.knots = append(t.knots, point{x, (cur.eval(x) + next.eval(x)) / 2})
+	}
+	last := t.segments[len(t.segments)-1]
+	t.knots = append(t.knots, point{last.Max, last.eval(last.Max)})
+}
+
+// Min and Max return the range covered by the table.
+func (t *Table) Min() float64 { return t.segments[0].Min }
+func (t *Table) Max() float64 { return t.segments[len(t.segments)-1].Max }
+
+// Convert returns the mass for reading x. Both ends of every segment are
+// inclusive, so the final segment covers the top of the table.
+func (t *Table) Convert(x float64) (float64, error) {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return 0, fmt.Errorf("%w: %v is not a finite reading", ErrOutOfRange, x)
+	}
+
+	if x < t.Min() || x > t.Max() {
+		if !t.opts.Clamp {
+			return 0, fmt.Errorf("%w: %v not in [%v, %v]", ErrOutOfRange, x, t.Min(), t.Max())
+		}
+		x = math.Max(t.Min(), math.Min(x, t.Max()))
+	}
+
+	if t.opts.Interpolate {
+		return t.interpolate(x), nil
+	}
+
+	i := sort.Search(len(t.segments), func(i int) bool { return t.segments[i].Max >= x })
+	if i == len(t.segments) {
+		i = len(t.segments) - 1
+	}
+	return t.segments[i].eval(x), nil
+}
+
+func (t *Table) interpolate(x float64) float64 {
+	i := sort.Search(len(t.knots), func(i int) bool { return t.knots[i].x >= x })
+	switch {
+	case i == 0:
+		return t.knots[0].y
+	case i == len(t.knots):
+		return t.knots[len(t.knots)-1].y
+	}
+
+	lo, hi := t.knots[i-1], t.knots[i]
+	if hi.x == lo.x {
+		return hi.y
+	}
+	return lo.y + (x-lo.x)*(hi.y-lo.y)/(hi.x-lo.x)
+}
@@ -0,0 +1,110 @@
+package conversion
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// y = 2x on [0, 10], then y = 3x - 10 on [10, 20]; both give 20 at 10.
+var segments = []Segment{
+	{Min: 0, Max: 10, Slope: 2, Intercept: 0},
+	{Min: 10, Max: 20, Slope: 3, Intercept: -10},
+}
+
+func TestNewValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments []Segment
+		opts     Options
+		wantErr  bool
+	}{
+		{"sorted", segments, Options{}, false},
+		{"unsorted", []Segment{segments[1], segments[0]}, Options{}, false},
+		{"empty", nil, Options{}, true},
+		{"duplicate", []Segment{segments[0], segments[0]}, Options{}, true},
+		{"min equals max", []Segment{{Min: 5, Max: 5, Slope: 1}}, Options{}, true},
+		{"min above max", []Segment{{Min: 6, Max: 5, Slope: 1}}, Options{}, true},
+		{"non-finite", []Segment{{Min: 0, Max: 1, Slope: math.NaN()}}, Options{}, true},
+		{"gap", []Segment{segments[0], {Min: 10.5, Max: 20, Slope: 3, Intercept: -10}}, Options{}, true},
+		{"gap within tolerance", []Segment{segments[0], {Min: 10.5, Max: 20, Slope: 3, Intercept: -10}}, Options{Tolerance: 0.5}, false},
+		{"overlap", []Segment{segments[0], {Min: 9.5, Max: 20, Slope: 3, Intercept: -10}}, Options{}, true},
+		{"overlap within tolerance", []Segment{segments[0], {Min: 9.5, Max: 20, Slope: 3, Intercept: -10}}, Options{Tolerance: 0.5}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.segments, tt.opts)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidTable) {
+					t.Errorf("New() error = %v, want ErrInvalidTable", err)
+				}
+			} else if err != nil {
+				t.Errorf("New() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	// The second segment starts one unit above where the first one ends, so
+	// the interpolated table meets at the midpoint 20.5.
+	stepped := []Segment{segments[0], {Min: 10, Max: 20, Slope: 3, Intercept: -9}}
+
+	tests := []struct {
+		name     string
+		segments []Segment
+		opts     Options
+		x        float64
+		want     float64
+		wantErr  bool
+	}{
+		{"first segment", segments, Options{}, 5, 10, false},
+		{"boundary", segments, Options{}, 10, 20, false},
+		{"last segment", segments, Options{}, 15, 35, false},
+		{"table bottom", segments, Options{}, 0, 0, false},
+		{"table top", segments, Options{}, 20, 50, false},
+		{"below table", segments, Options{}, -1, 0, true},
+		{"above table", segments, Options{}, 21, 0, true},
+		{"not finite", segments, Options{Clamp: true}, math.Inf(1), 0, true},
+		{"clamped below", segments, Options{Clamp: true}, -5, 0, false},
+		{"clamped above", segments, Options{Clamp: true}, 25, 50, false},
+		{"formula at step", stepped, Options{}, 10, 20, false},
+		{"formula after step", stepped, Options{}, 10.5, 22.5, false},
+		{"interpolated at step", stepped, Options{Interpolate: true}, 10, 20.5, false},
+		{"interpolated in first segment", stepped, Options{Interpolate: true}, 5, 10.25, false},
+		{"interpolated in last segment", stepped, Options{Interpolate: true}, 15, 35.75, false},
+		{"interpolated top", stepped, Options{Interpolate: true}, 20, 51, false},
+		{"interpolated clamped", stepped, Options{Interpolate: true, Clamp: true}, 30, 51, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := New(tt.segments, tt.opts)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			got, err := table.Convert(tt.x)
+			if tt.wantErr {
+				if !errors.Is(err, ErrOutOfRange) {
+					t.Errorf("Convert(%v) error = %v, want ErrOutOfRange", tt.x, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Convert(%v) error = %v", tt.x, err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Convert(%v) = %v, want %v", tt.x, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRange(t *testing.T) {
+	table, err := New([]Segment{segments[1], segments[0]}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Min() != 0 || table.Max() != 20 {
+		t.Errorf("range = [%v, %v], want [0, 20]", table.Min(), table.Max())
+	}
+}
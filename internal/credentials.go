@@ -0,0 +1,531 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"medical-gas-transport-service/internal/services"
+
+	"github.com/lib/pq"
+	nanoid "github.com/matoous/go-nanoid/v2"
+)
+
+const credentialRotationLock = "credentials:rotation"
+
+var (
+	errRotationPending    = errors.New("credential rotation already pending")
+	errCredentialsRevoked = errors.New("device credentials are revoked")
+)
+
+// CredentialsCommand is published on JI/v2/<sn>/credentials, which only the
+// device's current credentials can subscribe to.
+type CredentialsCommand struct {
+	RotationID string `json:"rotation_id"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+}
+
+// CredentialsAck is published on JI/v2/<sn>/credentials-ack/<username> by
+// the device once it has reconnected with the new credentials. The broker
+// ACL must only let a client publish to the topic ending in its own
+// username, which is what proves the device logged in with them.
+type CredentialsAck struct {
+	RotationID string `json:"rotation_id"`
+}
+
+type CredentialRotation struct {
+	ID           string     `json:"id"`
+	SerialNumber string     `json:"serial_number"`
+	OldUsername  string     `json:"old_username"`
+	NewUsername  string     `json:"new_username"`
+	Status       string     `json:"status"`
+	Reason       string     `json:"reason"`
+	RequestedAt  time.Time  `json:"requested_at"`
+	CompletedAt  *time.Time `json:"completed_at"`
+}
+
+// recordCredentials remembers which username a device currently holds.
+func (s *Service) recordCredentials(serialNumber, username string) {
+	_, err := s.timescaleClient.DB.ExecContext(s.ctx, `
+		INSERT INTO device_credential (serial_number, username, status, issued_at)
+		VALUES ($1, $2, 'active', NOW())
+		ON CONFLICT (serial_number) DO UPDATE SET username = EXCLUDED.username, status = 'active', issued_at = NOW()
+	`, serialNumber, username)
+	if err != nil {
+		log.Printf("Error recording credentials for device %s: %v", serialNumber, err)
+	}
+}
+
+// activeUsername returns the username a device currently holds, empty when
+// its credentials were revoked or it has none. Devices provisioned before
+// usernames were recorded are looked up in Jaya and recorded.
+func (s *Service) activeUsername(serialNumber string) (string, error) {
+	var username, status string
+	err := s.timescaleClient.DB.QueryRowContext(s.ctx,
+		`SELECT username, status FROM device_credential WHERE serial_number = $1`, serialNumber,
+	).Scan(&username, &status)
+	if err == nil {
+		if status != "active" {
+			return "", nil
+		}
+		return username, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	result, err := s.jayaClient.GetCredentials(serialNumber)
+	if err == services.ErrDeviceNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if result.Username == "" {
+		return "", nil
+	}
+	s.recordCredentials(serialNumber, result.Username)
+	return result.Username, nil
+}
+
+// rotateCredentials obtains new credentials from Jaya and delivers them to
+// the device. The old credentials are only revoked once the device confirms.
+// Revoked devices can't rotate: that would hand a stolen device a new login.
+func (s *Service) rotateCredentials(serialNumber, reason string) (*CredentialRotation, error) {
+	db := s.timescaleClient.DB
+
+	var status string
+	err := db.QueryRowContext(s.ctx,
+		`SELECT status FROM device_credential WHERE serial_number = $1`, serialNumber,
+	).Scan(&status)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if status == "revoked" {
+		return nil, errCredentialsRevoked
+	}
+
+	oldUsername, err := s.activeUsername(serialNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	rotation := &CredentialRotation{
+		SerialNumber: serialNumber,
+		OldUsername:  oldUsername,
+		Status:       "pending",
+		Reason:       reason,
+		RequestedAt:  time.Now(),
+	}
+	if rotation.ID, err = nanoid.New(); err != nil {
+		return nil, err
+	}
+
+	// The rotation is recorded before Jaya issues anything, so of two
+	// concurrent rotations only the one that wins the pending index goes on.
+	_, err = db.ExecContext(s.ctx, `
+		INSERT INTO credential_rotation (id, serial_number, old_username, new_username, status, reason, requested_at)
+		VALUES ($1, $2, $3, '', $4, $5, $6)
+	`, rotation.ID, rotation.SerialNumber, rotation.OldUsername, rotation.Status, rotation.Reason, rotation.RequestedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return nil, errRotationPending
+	} else if err != nil {
+		return nil, err
+	}
+
+	result, err := s.jayaClient.RotateCredentials(serialNumber)
+	if err != nil {
+		s.finishRotation(rotation.ID, "failed")
+		return nil, err
+	}
+	rotation.NewUsername = result.Username
+	_, err = db.ExecContext(s.ctx,
+		`UPDATE credential_rotation SET new_username = $2 WHERE id = $1`, rotation.ID, rotation.NewUsername)
+	if err != nil {
+		s.finishRotation(rotation.ID, "failed")
+		if err := s.jayaClient.RevokeCredentials(serialNumber, result.Username); err != nil {
+			log.Printf("Error revoking unused credentials of device %s: %v", serialNumber, err)
+		}
+		return nil, err
+	}
+
+	err = s.publishMQTT(fmt.Sprintf("JI/v2/%s/credentials", serialNumber), 2, CredentialsCommand{
+		RotationID: rotation.ID,
+		Username:   result.Username,
+		Password:   result.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Started credential rotation %s for device %s (%s)", rotation.ID, serialNumber, reason)
+	return rotation, nil
+}
+
+// HandleCredentialsAck completes a rotation: the device is now connected
+// with the new credentials, so the old ones are revoked. Only acks on the
+// topic of the rotation's new username count.
+func (s *Service) HandleCredentialsAck(topic string, payload []byte) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 5 || parts[4] == "" {
+		log.Printf("Ignoring credentials ack on %s without the username of the publishing client", topic)
+		return
+	}
+	serialNumber, username := parts[2], parts[4]
+
+	var ack CredentialsAck
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		log.Printf("Error parsing credentials ack from device %s: %v", serialNumber, err)
+		return
+	}
+
+	var oldUsername, newUsername string
+	err := s.timescaleClient.DB.QueryRowContext(s.ctx, `
+		UPDATE credential_rotation SET status = 'revoke_pending'
+		WHERE id = $1 AND serial_number = $2 AND new_username = $3 AND status = 'pending'
+		RETURNING old_username, new_username
+	`, ack.RotationID, serialNumber, username).Scan(&oldUsername, &newUsername)
+	if err == sql.ErrNoRows {
+		log.Printf("Ignoring credentials ack from device %s for unknown or finished rotation %s", serialNumber, ack.RotationID)
+		return
+	} else if err != nil {
+		log.Printf("Error confirming credential rotation for device %s: %v", serialNumber, err)
+		return
+	}
+
+	s.recordCredentials(serialNumber, newUsername)
+	if err := s.revokeOldCredentials(ack.RotationID, serialNumber, oldUsername); err != nil {
+		log.Printf("Error revoking old credentials of device %s, retrying later: %v", serialNumber, err)
+		return
+	}
+	log.Printf("Device %s confirmed credential rotation %s, old credentials revoked", serialNumber, ack.RotationID)
+}
+
+// revokeOldCredentials revokes the credentials a confirmed rotation
+// replaced and completes the rotation. Until that succeeds the rotation
+// stays revoke_pending and runScheduledRotations retries it.
+func (s *Service) revokeOldCredentials(rotationID, serialNumber, oldUsername string) error {
+	if oldUsername != "" {
+		if err := s.jayaClient.RevokeCredentials(serialNumber, oldUsername); err != nil {
+			return err
+		}
+	}
+	s.finishRotation(rotationID, "confirmed")
+	return nil
+}
+
+// finishRotation moves a rotation to a final status.
+func (s *Service) finishRotation(rotationID, status string) {
+	err := s.writeToTimescaleDBWithRetry(
+		`UPDATE credential_rotation SET status = $2, completed_at = NOW() WHERE id = $1`, rotationID, status)
+	if err != nil {
+		log.Printf("Error marking credential rotation %s %s: %v", rotationID, status, err)
+	}
+}
+
+// revokeDeviceCredentials revokes the active credentials of a stolen or
+// decommissioned device and abandons any pending rotation. It also removes
+// the device from the provisioning allowlist and revokes its certificates so
+// it can't obtain new credentials either.
+func (s *Service) revokeDeviceCredentials(serialNumber, reason string) error {
+	db := s.timescaleClient.DB
+
+	usernames := []string{}
+	username, err := s.activeUsername(serialNumber)
+	if err != nil {
+		return err
+	}
+	if username != "" {
+		usernames = append(usernames, username)
+	}
+
+	// Unfinished rotations hold a login the device may not use yet, or one it
+	// stopped using that wasn't revoked yet.
+	rows, err := db.QueryContext(s.ctx, `
+		SELECT new_username, old_username FROM credential_rotation
+		WHERE serial_number = $1 AND status IN ('pending', 'revoke_pending')
+	`, serialNumber)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var newUsername, oldUsername string
+		if err := rows.Scan(&newUsername, &oldUsername); err == nil {
+			usernames = append(usernames, newUsername, oldUsername)
+		}
+	}
+	rows.Close()
+
+	revoked := make(map[string]bool)
+	for _, u := range usernames {
+		if u == "" || revoked[u] {
+			continue
+		}
+		if err := s.jayaClient.RevokeCredentials(serialNumber, u); err != nil {
+			return err
+		}
+		revoked[u] = true
+	}
+
+	// Devices provisioned with a certificate only have no username, so the
+	// revocation only fails as not found when there is nothing at all.
+	err = s.inTransaction(10*time.Second, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`UPDATE device_certificate SET revoked_at = NOW() WHERE serial_number = $1 AND revoked_at IS NULL`, serialNumber)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 && len(revoked) == 0 {
+			return sql.ErrNoRows
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE credential_rotation SET status = 'cancelled', completed_at = NOW()
+			WHERE serial_number = $1 AND status IN ('pending', 'revoke_pending')
+		`, serialNumber)
+		if err != nil {
+			return err
+		}
+		// Certificate-only devices get a row too, so they can't rotate into
+		// a new login afterwards.
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO device_credential (serial_number, username, status, issued_at, revoked_at, revoke_reason)
+			VALUES ($1, '', 'revoked', NOW(), NOW(), $2)
+			ON CONFLICT (serial_number) DO UPDATE SET status = 'revoked', revoked_at = NOW(), revoke_reason = EXCLUDED.revoke_reason
+		`, serialNumber, reason)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM provisioning_allowlist WHERE serial_number = $1`, serialNumber)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Revoked credentials of device %s: %s", serialNumber, reason)
+	return nil
+}
+
+// startCredentialRotation periodically finishes and expires rotations and,
+// when a rotation interval is set, starts scheduled ones.
+func (s *Service) startCredentialRotation() {
+	go func() {
+		ticker := time.NewTicker(s.cfg.Credentials.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				locked, err := s.redisClient.Rdb.SetNX(s.ctx, credentialRotationLock, 1, s.cfg.Credentials.CheckInterval/2).Result()
+				if err != nil || !locked {
+					continue
+				}
+				s.runScheduledRotations()
+			}
+		}
+	}()
+}
+
+// runScheduledRotations retries revoking the old credentials of confirmed
+// rotations, expires rotations the device never confirmed and starts
+// rotations for credentials older than the rotation interval.
+func (s *Service) runScheduledRotations() {
+	db := s.timescaleClient.DB
+	now := time.Now()
+
+	rows, err := db.QueryContext(s.ctx, `
+		SELECT id, serial_number, old_username FROM credential_rotation WHERE status = 'revoke_pending'
+	`)
+	if err != nil {
+		log.Printf("Error selecting credential rotations to finish: %v", err)
+		return
+	}
+	var unfinished []CredentialRotation
+	for rows.Next() {
+		var r CredentialRotation
+		if err := rows.Scan(&r.ID, &r.SerialNumber, &r.OldUsername); err == nil {
+			unfinished = append(unfinished, r)
+		}
+	}
+	rows.Close()
+
+	for _, r := range unfinished {
+		if err := s.revokeOldCredentials(r.ID, r.SerialNumber, r.OldUsername); err != nil {
+			log.Printf("Error revoking old credentials of device %s: %v", r.SerialNumber, err)
+		}
+	}
+
+	rows, err = db.QueryContext(s.ctx, `
+		UPDATE credential_rotation SET status = 'expired', completed_at = NOW()
+		WHERE status = 'pending' AND requested_at < $1
+		RETURNING serial_number, new_username
+	`, now.Add(-s.cfg.Credentials.ConfirmTimeout))
+	if err != nil {
+		log.Printf("Error expiring credential rotations: %v", err)
+		return
+	}
+	type expired struct{ serial, username string }
+	var expiredRotations []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.serial, &e.username); err == nil {
+			expiredRotations = append(expiredRotations, e)
+		}
+	}
+	rows.Close()
+
+	for _, e := range expiredRotations {
+		if e.username == "" {
+			continue
+		}
+		log.Printf("Credential rotation for device %s was not confirmed, revoking the unused credentials", e.serial)
+		if err := s.jayaClient.RevokeCredentials(e.serial, e.username); err != nil {
+			log.Printf("Error revoking unused credentials of device %s: %v", e.serial, err)
+		}
+	}
+
+	if s.cfg.Credentials.RotationInterval <= 0 {
+		return
+	}
+	rows, err = db.QueryContext(s.ctx, `
+		SELECT serial_number FROM device_credential
+		WHERE status = 'active' AND issued_at < $1
+		AND serial_number NOT IN (SELECT serial_number FROM credential_rotation WHERE status = 'pending')
+		ORDER BY issued_at
+		LIMIT $2
+	`, now.Add(-s.cfg.Credentials.RotationInterval), s.cfg.Credentials.BatchSize)
+	if err != nil {
+		log.Printf("Error selecting credentials due for rotation: %v", err)
+		return
+	}
+	var due []string
+	for rows.Next() {
+		var sn string
+		if err := rows.Scan(&sn); err == nil {
+			due = append(due, sn)
+		}
+	}
+	rows.Close()
+
+	for _, sn := range due {
+		if _, err := s.rotateCredentials(sn, "scheduled"); err != nil {
+			log.Printf("Error rotating credentials of device %s: %v", sn, err)
+		}
+	}
+}
+
+// handleCredentials serves GET /credentials/<sn> and POST
+// /credentials/<sn>/rotate and /credentials/<sn>/revoke.
+func (s *Service) handleCredentials(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/credentials/"), "/"), "/")
+	serialNumber := parts[0]
+	if serialNumber == "" || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		s.getCredentialStatus(w, r, serialNumber)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	switch parts[1] {
+	case "rotate":
+		if req.Reason == "" {
+			req.Reason = "operator request"
+		}
+		rotation, err := s.rotateCredentials(serialNumber, req.Reason)
+		if err == errRotationPending || err == errCredentialsRevoked {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		} else if err != nil {
+			log.Printf("Error rotating credentials of device %s: %v", serialNumber, err)
+			writeError(w, http.StatusBadGateway, "failed to rotate credentials")
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "success", "data": rotation})
+	case "revoke":
+		if req.Reason == "" {
+			req.Reason = "operator request"
+		}
+		err := s.revokeDeviceCredentials(serialNumber, req.Reason)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "no active credentials or certificates for device")
+			return
+		} else if err != nil {
+			log.Printf("Error revoking credentials of device %s: %v", serialNumber, err)
+			writeError(w, http.StatusBadGateway, "failed to revoke credentials")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success", "serial_number": serialNumber})
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Service) getCredentialStatus(w http.ResponseWriter, r *http.Request, serialNumber string) {
+	rows, err := s.timescaleClient.DB.QueryContext(r.Context(), `
+		SELECT id, serial_number, old_username, new_username, status, reason, requested_at, completed_at
+		FROM credential_rotation WHERE serial_number = $1
+		ORDER BY requested_at DESC LIMIT 20
+	`, serialNumber)
+	if err != nil {
+		log.Printf("Error querying credential rotations: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to query credentials")
+		return
+	}
+	defer rows.Close()
+
+	rotations := []CredentialRotation{}
+	for rows.Next() {
+		var c CredentialRotation
+		if err := rows.Scan(&c.ID, &c.SerialNumber, &c.OldUsername, &c.NewUsername, &c.Status, &c.Reason, &c.RequestedAt, &c.CompletedAt); err != nil {
+			log.Printf("Error scanning credential rotation: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to query credentials")
+			return
+		}
+		rotations = append(rotations, c)
+	}
+
+	var username, status string
+	var issuedAt time.Time
+	err = s.timescaleClient.DB.QueryRowContext(r.Context(),
+		`SELECT username, status, issued_at FROM device_credential WHERE serial_number = $1`, serialNumber,
+	).Scan(&username, &status, &issuedAt)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error querying device credential: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to query credentials")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"serial_number":     serialNumber,
+			"username":          username,
+			"credential_status": status,
+			"issued_at":         issuedAt,
+			"rotations":         rotations,
+		},
+	})
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"medical-gas-transport-service/internal/cache"
+	"medical-gas-transport-service/internal/services"
+)
+
+func (s *Service) setupCaches() {
+	opts := cache.Options{
+		Size:        s.cfg.Cache.Size,
+		LocalTTL:    s.cfg.Cache.LocalTTL,
+		StaleTTL:    s.cfg.Cache.StaleTTL,
+		RedisTTL:    s.cfg.Cache.RedisTTL,
+		NegativeTTL: s.cfg.Cache.NegativeTTL,
+	}
+
+	deviceOpts := opts
+	deviceOpts.Prefix = "device/"
+	s.deviceCache = cache.New(s.redisClient.Rdb, deviceOpts, func(ctx context.Context, serialNumber string) (*services.Device, error) {
+		device, err := s.jayaClient.GetDevice(serialNumber)
+		if err != nil {
+			log.Printf("Error getting device from service for serial %s: %v", serialNumber, err)
+			return nil, err
+		}
+		log.Printf("Device not found in cache, fetched from service: %s", serialNumber)
+		return device, nil
+	}, services.ErrDeviceNotFound)
+
+	conversionOpts := opts
+	conversionOpts.Prefix = "conversion_table/"
+	s.conversionCache = cache.New(s.redisClient.Rdb, conversionOpts, func(ctx context.Context, serialNumber string) ([]services.TankConversion, error) {
+		table, err := s.jayaClient.GetConversionTable(serialNumber)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Conversion table not found in cache, fetched from service: %s", serialNumber)
+		return table, nil
+	}, nil)
+}
+
+func (s *Service) getDeviceFromCacheOrService(serialNumber string) (*services.Device, error) {
+	device, err := s.deviceCache.Get(s.ctx, serialNumber)
+	if err != nil {
+		return nil, fmt.Errorf("error getting device from service: %w", err)
+	}
+	return device, nil
+}
+
+func (s *Service) getConversionTableWithCache(serialNumber string) ([]services.TankConversion, error) {
+	table, err := s.conversionCache.Get(s.ctx, serialNumber)
+	if err != nil {
+		return nil, fmt.Errorf("error getting conversion table from service: %w", err)
+	}
+	return table, nil
+}
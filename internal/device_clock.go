@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"medical-gas-transport-service/internal/clock"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	deviceClockChannel = "device:clock"
+	// clockSkewKey ranks devices by the size of their clock skew in seconds.
+	clockSkewKey = "clock:skew"
+)
+
+type DeviceClockEvent struct {
+	Type         string    `json:"type"`
+	SerialNumber string    `json:"serial_number"`
+	Detail       string    `json:"detail"`
+	SkewSeconds  float64   `json:"skew_seconds"`
+	DeviceTime   time.Time `json:"device_time"`
+	ReceivedAt   time.Time `json:"received_at"`
+}
+
+type DeviceClock struct {
+	SerialNumber   string    `json:"serial_number"`
+	SkewSeconds    float64   `json:"skew_seconds"`
+	Skewed         bool      `json:"skewed"`
+	Broken         bool      `json:"broken"`
+	LastDeviceTime time.Time `json:"last_device_time"`
+	LastReceivedAt time.Time `json:"last_received_at"`
+}
+
+// observeClock compares the timestamp of a live reading with the time the
+// broker delivered it, tracks the device's clock skew and publishes clocks
+// turning skewed, broken or back to normal. It returns the offset to
+// subtract from the reading's timestamps, zero when they are not corrected.
+func (s *Service) observeClock(serialNumber string, payload []byte, receivedAt time.Time) time.Duration {
+	var reading struct {
+		Ts     int64  `json:"ts"`
+		TsUnit string `json:"tsUnit"`
+	}
+	if err := json.Unmarshal(payload, &reading); err != nil || reading.Ts <= 0 {
+		return 0
+	}
+	return s.observeDeviceTime(serialNumber, clock.FromUnix(reading.Ts, reading.TsUnit), receivedAt)
+}
+
+// observeDeviceTime is observeClock for a reading that is already decoded.
+func (s *Service) observeDeviceTime(serialNumber string, deviceTime, receivedAt time.Time) time.Duration {
+	key := "clock/" + serialNumber
+
+	var result clock.Result
+	err := updateState(s, key, 7*24*time.Hour, func(prev *clock.State) clock.State {
+		result = clock.Observe(prev, deviceTime, receivedAt, clock.Thresholds{
+			MaxSkew:          s.cfg.Clock.MaxSkew,
+			BrokenSkew:       s.cfg.Clock.BrokenSkew,
+			CorrectionWindow: s.cfg.Clock.CorrectionWindow,
+		})
+		return result.State
+	})
+	if err != nil {
+		log.Printf("Error tracking clock state for device %s: %v", serialNumber, err)
+		return 0
+	}
+	err = s.redisClient.Rdb.ZAdd(s.ctx, clockSkewKey, redis.Z{Score: result.State.Skew.Abs().Seconds(), Member: serialNumber}).Err()
+	if err != nil {
+		log.Printf("Error ranking clock skew for device %s: %v", serialNumber, err)
+	}
+
+	for _, e := range result.Events {
+		log.Printf("Device clock event %s for device %s: %s", e.Type, serialNumber, e.Detail)
+		if err := s.publishEvent(deviceClockChannel, DeviceClockEvent{
+			Type:         e.Type,
+			SerialNumber: serialNumber,
+			Detail:       e.Detail,
+			SkewSeconds:  result.State.Skew.Seconds(),
+			DeviceTime:   deviceTime,
+			ReceivedAt:   receivedAt,
+		}); err != nil {
+			log.Printf("Error publishing device clock event for device %s: %v", serialNumber, err)
+		}
+	}
+	return result.Offset
+}
+
+// handleClockSkew lists devices by the size of their clock skew, largest
+// first.
+func (s *Service) handleClockSkew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	limit, offset, ok := pagination(w, q.Get("limit"), q.Get("offset"))
+	if !ok {
+		return
+	}
+
+	serialNumbers, err := s.redisClient.Rdb.ZRevRange(r.Context(), clockSkewKey, int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		log.Printf("Error reading clock skew ranking: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to read clock skew")
+		return
+	}
+
+	pipe := s.redisClient.Rdb.Pipeline()
+	cmds := make([]*redis.StringCmd, len(serialNumbers))
+	for i, serialNumber := range serialNumbers {
+		cmds[i] = pipe.Get(r.Context(), "clock/"+serialNumber)
+	}
+	if _, err := pipe.Exec(r.Context()); err != nil && err != redis.Nil {
+		log.Printf("Error reading clock states: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to read clock skew")
+		return
+	}
+
+	clocks := []DeviceClock{}
+	for i, serialNumber := range serialNumbers {
+		var state clock.State
+		if err := json.Unmarshal([]byte(cmds[i].Val()), &state); err != nil {
+			// The state expired; the ranking entry is stale.
+			continue
+		}
+		clocks = append(clocks, DeviceClock{
+			SerialNumber:   serialNumber,
+			SkewSeconds:    state.Skew.Seconds(),
+			Skewed:         state.Skewed,
+			Broken:         state.Broken,
+			LastDeviceTime: state.LastDeviceTime,
+			LastReceivedAt: state.LastReceivedAt,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   clocks,
+	})
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"medical-gas-transport-service/internal/health"
+)
+
+const deviceHealthChannel = "device:health"
+
+type DeviceHealthEvent struct {
+	Type         string    `json:"type"`
+	SerialNumber string    `json:"serial_number"`
+	Detail       string    `json:"detail"`
+	Score        int       `json:"score"`
+	Time         time.Time `json:"time"`
+}
+
+// trackDeviceHealth analyzes the Device block of a stored reading, stores the
+// resulting score and publishes any health events it raised or cleared.
+func (s *Service) trackDeviceHealth(serialNumber string, timestamp time.Time, device Device) {
+	var result health.Result
+	err := updateState(s, "health/"+serialNumber, 7*24*time.Hour, func(prev *health.State) health.State {
+		result = health.Analyze(prev, health.Sample{
+			Time:        timestamp,
+			Uptime:      device.DeviceUptime,
+			Temp:        device.DeviceTemp,
+			Hum:         device.DeviceHum,
+			RSSI:        device.DeviceRSSI,
+			MemUsage:    device.DeviceMemUsage,
+			ResetReason: device.DeviceResetReason,
+		}, health.Thresholds{
+			LowRSSI:        s.cfg.Health.LowRSSI,
+			HighTemp:       s.cfg.Health.HighTemp,
+			HighHumidity:   s.cfg.Health.HighHumidity,
+			MemoryLeakRate: s.cfg.Health.MemoryLeakRate,
+			MemoryWindow:   s.cfg.Health.MemoryWindow,
+		})
+		return result.State
+	})
+	if err != nil {
+		log.Printf("Error tracking health state for device %s: %v", serialNumber, err)
+		return
+	}
+
+	query := `
+		INSERT INTO device_health (
+			serial_number, time, score, reboots, last_reboot, reset_reason, reset_reason_name,
+			rssi, temp, hum, mem_usage, uptime, active_conditions
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (serial_number) DO UPDATE SET
+			time = EXCLUDED.time, score = EXCLUDED.score, reboots = EXCLUDED.reboots,
+			last_reboot = EXCLUDED.last_reboot, reset_reason = EXCLUDED.reset_reason,
+			reset_reason_name = EXCLUDED.reset_reason_name, rssi = EXCLUDED.rssi,
+			temp = EXCLUDED.temp, hum = EXCLUDED.hum, mem_usage = EXCLUDED.mem_usage,
+			uptime = EXCLUDED.uptime, active_conditions = EXCLUDED.active_conditions
+		WHERE device_health.time <= EXCLUDED.time
+	`
+	var lastReboot *time.Time
+	if !result.State.LastReboot.IsZero() {
+		lastReboot = &result.State.LastReboot
+	}
+	active := make([]string, 0, len(result.State.Active))
+	for name := range result.State.Active {
+		active = append(active, name)
+	}
+	activeJSON, _ := json.Marshal(active)
+
+	err = s.writeToTimescaleDBWithRetry(query,
+		serialNumber,
+		timestamp,
+		result.Score,
+		result.State.Reboots,
+		lastReboot,
+		device.DeviceResetReason,
+		health.ResetReasonName(device.DeviceResetReason),
+		device.DeviceRSSI,
+		device.DeviceTemp,
+		device.DeviceHum,
+		device.DeviceMemUsage,
+		device.DeviceUptime,
+		string(activeJSON),
+	)
+	if err != nil {
+		log.Printf("Error writing device health for device %s: %v", serialNumber, err)
+	}
+
+	for _, e := range result.Events {
+		err := s.writeToTimescaleDBWithRetry(
+			`INSERT INTO device_health_event (time, serial_number, type, detail, score) VALUES ($1, $2, $3, $4, $5)`,
+			timestamp, serialNumber, e.Type, e.Detail, result.Score,
+		)
+		if err != nil {
+			log.Printf("Error writing device health event for device %s: %v", serialNumber, err)
+		}
+
+		log.Printf("Device health event %s for device %s: %s", e.Type, serialNumber, e.Detail)
+		if err := s.publishEvent(deviceHealthChannel, DeviceHealthEvent{
+			Type:         e.Type,
+			SerialNumber: serialNumber,
+			Detail:       e.Detail,
+			Score:        result.Score,
+			Time:         timestamp,
+		}); err != nil {
+			log.Printf("Error publishing device health event for device %s: %v", serialNumber, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/eclipse/paho.golang/paho"
+	nanoid "github.com/matoous/go-nanoid/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// eventSchemaVersion is bumped whenever the payload of an existing event
+// channel changes incompatibly.
+const eventSchemaVersion = 1
+
+// publishEvent publishes event as JSON on the Redis channel. It goes through
+// the outbox so it is retried and exported like sensor events; if the outbox
+// can't be written the event is published directly.
+func (s *Service) publishEvent(channel string, event interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshaling %s event: %w", channel, err)
+	}
+	if err := s.enqueueOutbox(s.eventMessages(channel, payload)); err != nil {
+		log.Printf("Error writing %s event to outbox, publishing directly: %v", channel, err)
+		return s.publishEventJSON(channel, payload)
+	}
+	return nil
+}
+
+// publishEventJSON publishes an encoded event that didn't go through the
+// outbox to the channel's Redis stream and pub/sub channel. A failure of one
+// doesn't keep the event from the other.
+func (s *Service) publishEventJSON(channel string, payload []byte) error {
+	var streamErr error
+	if s.cfg.Events.Streams {
+		eventID, err := nanoid.New()
+		if err != nil {
+			streamErr = fmt.Errorf("error generating %s event ID: %w", channel, err)
+		} else {
+			streamErr = s.appendEventStream(channel, eventID, payload)
+		}
+	}
+	return errors.Join(streamErr, s.publishPubSub(channel, payload))
+}
+
+// appendEventStream appends an encoded event to the channel's Redis stream.
+// Entries carry the event ID and schema version next to the payload, so
+// consumer groups can dedupe redelivered events and replay from any entry
+// ID.
+func (s *Service) appendEventStream(channel, eventID string, payload []byte) error {
+	err := s.redisClient.Rdb.XAdd(s.ctx, &redis.XAddArgs{
+		Stream: s.cfg.Events.StreamPrefix + channel,
+		MaxLen: s.cfg.Events.StreamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"event_id":       eventID,
+			"schema_version": eventSchemaVersion,
+			"channel":        channel,
+			"payload":        payload,
+		},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("error appending %s event to stream: %w", channel, err)
+	}
+	return nil
+}
+
+// publishPubSub publishes an encoded event on the Redis pub/sub channel.
+// Payloads are unchanged for existing subscribers.
+func (s *Service) publishPubSub(channel string, payload []byte) error {
+	if err := s.redisClient.Rdb.Publish(s.ctx, channel, payload).Err(); err != nil {
+		return fmt.Errorf("error publishing %s event: %w", channel, err)
+	}
+	return nil
+}
+
+// publishMQTT publishes v as JSON on the MQTT topic.
+func (s *Service) publishMQTT(topic string, qos byte, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error marshaling payload for %s: %w", topic, err)
+	}
+	if _, err := s.mqttClient.Client.Publish(s.ctx, &paho.Publish{
+		Topic:   topic,
+		QoS:     qos,
+		Payload: payload,
+	}); err != nil {
+		return fmt.Errorf("error publishing to %s: %w", topic, err)
+	}
+	return nil
+}
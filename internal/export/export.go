@@ -0,0 +1,91 @@
+// Package export forwards service events to external brokers such as Kafka
+// and NATS, next to the Redis channels the service already publishes on.
+package export
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Event is one event as published on Redis, with the metadata brokers need
+// to partition and deduplicate it.
+type Event struct {
+	ID            string    // stable across delivery retries
+	Channel       string    // Redis channel, e.g. sensor:level
+	SerialNumber  string    // partition key
+	SchemaVersion int       // version of the payload schema
+	Time          time.Time // when the event was recorded
+	Payload       []byte    // JSON payload as published on Redis
+}
+
+// Sink delivers serialized events to a broker. Send must only return once
+// the broker has acknowledged the message.
+type Sink interface {
+	Send(ctx context.Context, e Event, value []byte, contentType string) error
+	Close() error
+}
+
+type Exporter struct {
+	sink       Sink
+	serializer Serializer
+	channels   map[string]bool
+}
+
+// New returns an exporter for the given channels. An empty list exports
+// every channel.
+func New(sink Sink, serializer Serializer, channels []string) *Exporter {
+	x := &Exporter{sink: sink, serializer: serializer, channels: make(map[string]bool)}
+	for _, c := range channels {
+		x.channels[c] = true
+	}
+	return x
+}
+
+// Exports reports whether events on channel are exported.
+func (x *Exporter) Exports(channel string) bool {
+	return len(x.channels) == 0 || x.channels[channel]
+}
+
+func (x *Exporter) Export(ctx context.Context, e Event) error {
+	value, err := x.serializer.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error serializing %s event %s: %w", e.Channel, e.ID, err)
+	}
+	return x.sink.Send(ctx, e, value, x.serializer.ContentType())
+}
+
+func (x *Exporter) Close() error {
+	return x.sink.Close()
+}
+
+// Topic maps a Redis channel to a broker topic, e.g. "sensor:level" with
+// prefix "medgas." becomes "medgas.sensor.level".
+func Topic(prefix, channel string) string {
+	return prefix + strings.ReplaceAll(channel, ":", ".")
+}
+
+// Open builds an exporter for the named sink, kafka or nats.
+func Open(sink string, brokers []string, topicPrefix, format string, channels []string) (*Exporter, error) {
+	serializer, err := NewSerializer(format)
+	if err != nil {
+		return nil, err
+	}
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("no brokers configured for %s export", sink)
+	}
+
+	var s Sink
+	switch sink {
+	case "kafka":
+		s = NewKafkaSink(brokers, topicPrefix)
+	case "nats":
+		if s, err = NewNATSSink(strings.Join(brokers, ","), topicPrefix); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown export sink %q", sink)
+	}
+	return New(s, serializer, channels), nil
+}
@@ -0,0 +1,155 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// The integration tests run against the brokers in docker-compose.yml and
+// are skipped unless EXPORT_TEST_KAFKA_BROKERS or EXPORT_TEST_NATS_URL is
+// set.
+
+func testEvent() Event {
+	return Event{
+		ID:            fmt.Sprint(time.Now().UnixNano()),
+		Channel:       "sensor:level",
+		SerialNumber:  "SN-TEST",
+		SchemaVersion: 1,
+		Time:          time.Now().UTC().Truncate(time.Millisecond),
+		Payload:       []byte(`{"serial_number":"SN-TEST","level":42.5}`),
+	}
+}
+
+func testPrefix() string {
+	return fmt.Sprintf("test%d.", time.Now().UnixNano())
+}
+
+func TestKafkaIntegration(t *testing.T) {
+	brokers := os.Getenv("EXPORT_TEST_KAFKA_BROKERS")
+	if brokers == "" {
+		t.Skip("EXPORT_TEST_KAFKA_BROKERS not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	prefix := testPrefix()
+	x, err := Open("kafka", strings.Split(brokers, ","), prefix, "json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	// The first write can fail while the broker creates the topic.
+	e := testEvent()
+	for attempt := 1; ; attempt++ {
+		if err = x.Export(ctx, e); err == nil || attempt == 10 {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: strings.Split(brokers, ","),
+		Topic:   Topic(prefix, e.Channel),
+	})
+	defer r.Close()
+	msg, err := r.ReadMessage(ctx)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+
+	if string(msg.Key) != e.SerialNumber {
+		t.Errorf("key = %q, want %q", msg.Key, e.SerialNumber)
+	}
+	headers := map[string]string{}
+	for _, h := range msg.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	if headers["event-id"] != e.ID {
+		t.Errorf("event-id header = %q, want %q", headers["event-id"], e.ID)
+	}
+	if headers["content-type"] != "application/json" {
+		t.Errorf("content-type header = %q, want application/json", headers["content-type"])
+	}
+	var got struct {
+		EventID string          `json:"event_id"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(msg.Value, &got); err != nil {
+		t.Fatalf("decoding value: %v", err)
+	}
+	if got.EventID != e.ID || string(got.Payload) != string(e.Payload) {
+		t.Errorf("value = %s, want event %s with payload %s", msg.Value, e.ID, e.Payload)
+	}
+}
+
+func TestNATSIntegration(t *testing.T) {
+	url := os.Getenv("EXPORT_TEST_NATS_URL")
+	if url == "" {
+		t.Skip("EXPORT_TEST_NATS_URL not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := testPrefix()
+	stream := "EXPORT_" + strings.TrimSuffix(prefix, ".")
+	if _, err := js.AddStream(&nats.StreamConfig{
+		Name:       stream,
+		Subjects:   []string{prefix + ">"},
+		Duplicates: time.Minute,
+	}); err != nil {
+		t.Fatalf("AddStream: %v", err)
+	}
+	defer js.DeleteStream(stream)
+
+	x, err := Open("nats", []string{url}, prefix, "json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	// A redelivered event keeps its ID, so JetStream must store it once.
+	e := testEvent()
+	for i := 0; i < 2; i++ {
+		if err := x.Export(ctx, e); err != nil {
+			t.Fatalf("Export: %v", err)
+		}
+	}
+
+	info, err := js.StreamInfo(stream)
+	if err != nil {
+		t.Fatalf("StreamInfo: %v", err)
+	}
+	if info.State.Msgs != 1 {
+		t.Errorf("stream has %d messages, want 1", info.State.Msgs)
+	}
+
+	msg, err := js.GetLastMsg(stream, Topic(prefix, e.Channel)+"."+e.SerialNumber)
+	if err != nil {
+		t.Fatalf("GetLastMsg: %v", err)
+	}
+	if ct := msg.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+}
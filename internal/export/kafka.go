@@ -0,0 +1,45 @@
+package export
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaSink writes events to one topic per channel, keyed by serial number
+// so every device's events stay ordered within a partition.
+type KafkaSink struct {
+	writer *kafka.Writer
+	prefix string
+}
+
+func NewKafkaSink(brokers []string, topicPrefix string) *KafkaSink {
+	return &KafkaSink{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			BatchTimeout:           10 * time.Millisecond,
+			AllowAutoTopicCreation: true,
+		},
+		prefix: topicPrefix,
+	}
+}
+
+func (k *KafkaSink) Send(ctx context.Context, e Event, value []byte, contentType string) error {
+	return k.writer.WriteMessages(ctx, kafka.Message{
+		Topic: Topic(k.prefix, e.Channel),
+		Key:   []byte(e.SerialNumber),
+		Value: value,
+		Time:  e.Time,
+		Headers: []kafka.Header{
+			{Key: "event-id", Value: []byte(e.ID)},
+			{Key: "content-type", Value: []byte(contentType)},
+		},
+	})
+}
+
+func (k *KafkaSink) Close() error {
+	return k.writer.Close()
+}
@@ -0,0 +1,42 @@
+package export
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSSink publishes events to JetStream on "<prefix><channel>.<serial>"
+// subjects. A stream must be configured to capture those subjects; the
+// event ID is sent as Nats-Msg-Id so JetStream drops redelivered events.
+type NATSSink struct {
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+	prefix string
+}
+
+func NewNATSSink(url, subjectPrefix string) (*NATSSink, error) {
+	conn, err := nats.Connect(url)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to NATS: %w", err)
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error opening JetStream context: %w", err)
+	}
+	return &NATSSink{conn: conn, js: js, prefix: subjectPrefix}, nil
+}
+
+func (n *NATSSink) Send(ctx context.Context, e Event, value []byte, contentType string) error {
+	msg := nats.NewMsg(Topic(n.prefix, e.Channel) + "." + e.SerialNumber)
+	msg.Data = value
+	msg.Header.Set("Content-Type", contentType)
+	_, err := n.js.PublishMsg(msg, nats.MsgId(e.ID), nats.Context(ctx))
+	return err
+}
+
+func (n *NATSSink) Close() error {
+	return n.conn.Drain()
+}
@@ -0,0 +1,90 @@
+package export
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Serializer encodes events in the wire format consumers expect.
+type Serializer interface {
+	Marshal(e Event) ([]byte, error)
+	ContentType() string
+}
+
+//go:embed event.avsc
+var AvroSchema string
+
+// NewSerializer returns the serializer for format: json, avro or protobuf.
+func NewSerializer(format string) (Serializer, error) {
+	switch format {
+	case "", "json":
+		return jsonSerializer{}, nil
+	case "avro":
+		codec, err := goavro.NewCodec(AvroSchema)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing Avro schema: %w", err)
+		}
+		return avroSerializer{codec: codec}, nil
+	case "protobuf":
+		return protobufSerializer{}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Marshal(e Event) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"event_id":       e.ID,
+		"channel":        e.Channel,
+		"serial_number":  e.SerialNumber,
+		"schema_version": e.SchemaVersion,
+		"time":           e.Time,
+		"payload":        json.RawMessage(e.Payload),
+	})
+}
+
+func (jsonSerializer) ContentType() string { return "application/json" }
+
+type avroSerializer struct {
+	codec *goavro.Codec
+}
+
+func (a avroSerializer) Marshal(e Event) ([]byte, error) {
+	return a.codec.BinaryFromNative(nil, map[string]interface{}{
+		"event_id":       e.ID,
+		"channel":        e.Channel,
+		"serial_number":  e.SerialNumber,
+		"schema_version": int32(e.SchemaVersion),
+		"time":           e.Time,
+		"payload":        string(e.Payload),
+	})
+}
+
+func (avroSerializer) ContentType() string { return "avro/binary" }
+
+// protobufSerializer encodes the Event message of event.proto.
+type protobufSerializer struct{}
+
+func (protobufSerializer) Marshal(e Event) ([]byte, error) {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, e.ID)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, e.Channel)
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendString(b, e.SerialNumber)
+	b = protowire.AppendTag(b, 4, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(e.SchemaVersion))
+	b = protowire.AppendTag(b, 5, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(e.Time.UnixMicro()))
+	b = protowire.AppendTag(b, 6, protowire.BytesType)
+	b = protowire.AppendBytes(b, e.Payload)
+	return b, nil
+}
+
+func (protobufSerializer) ContentType() string { return "application/x-protobuf" }
@@ -0,0 +1,231 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func sampleEvent() Event {
+	return Event{
+		ID:            "1700000000123-0",
+		Channel:       "sensor:pressure",
+		SerialNumber:  "SN-0042",
+		SchemaVersion: 3,
+		Time:          time.Date(2023, 11, 14, 22, 13, 20, 123456000, time.UTC),
+		Payload:       []byte(`{"serial_number":"SN-0042","data":[{"measurement":"O2","value":4.2}]}`),
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	e := sampleEvent()
+	s, err := NewSerializer("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := s.Marshal(e)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var got struct {
+		EventID       string          `json:"event_id"`
+		Channel       string          `json:"channel"`
+		SerialNumber  string          `json:"serial_number"`
+		SchemaVersion int             `json:"schema_version"`
+		Time          time.Time       `json:"time"`
+		Payload       json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("decoding %s: %v", encoded, err)
+	}
+	if got.EventID != e.ID || got.Channel != e.Channel || got.SerialNumber != e.SerialNumber ||
+		got.SchemaVersion != e.SchemaVersion || !got.Time.Equal(e.Time) || string(got.Payload) != string(e.Payload) {
+		t.Errorf("decoded %+v, want %+v", got, e)
+	}
+}
+
+// TestAvroRoundTrip decodes the serializer's output with a codec built from
+// event.avsc on disk, so the record and the schema can't drift apart.
+func TestAvroRoundTrip(t *testing.T) {
+	schema, err := os.ReadFile("event.avsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, err := goavro.NewCodec(string(schema))
+	if err != nil {
+		t.Fatalf("parsing event.avsc: %v", err)
+	}
+
+	e := sampleEvent()
+	s, err := NewSerializer("avro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := s.Marshal(e)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	native, rest, err := codec.NativeFromBinary(encoded)
+	if err != nil {
+		t.Fatalf("NativeFromBinary: %v", err)
+	}
+	if len(rest) != 0 {
+		t.Errorf("%d bytes left after the record", len(rest))
+	}
+
+	record := native.(map[string]interface{})
+	want := map[string]interface{}{
+		"event_id":       e.ID,
+		"channel":        e.Channel,
+		"serial_number":  e.SerialNumber,
+		"schema_version": int32(e.SchemaVersion),
+		"payload":        string(e.Payload),
+	}
+	for name, value := range want {
+		if record[name] != value {
+			t.Errorf("%s = %#v, want %#v", name, record[name], value)
+		}
+	}
+	if ts, ok := record["time"].(time.Time); !ok || !ts.Equal(e.Time) {
+		t.Errorf("time = %#v, want %v", record["time"], e.Time)
+	}
+}
+
+var (
+	protoPackageRe = regexp.MustCompile(`^package\s+([\w.]+)\s*;$`)
+	protoMessageRe = regexp.MustCompile(`^message\s+(\w+)\s*\{$`)
+	protoFieldRe   = regexp.MustCompile(`^(\w+)\s+(\w+)\s*=\s*(\d+)\s*;$`)
+)
+
+var protoTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bytes":  descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+	"uint32": descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+}
+
+// eventDescriptor builds the descriptor of the Event message from the
+// subset of proto3 event.proto uses: one package, one flat message and
+// scalar fields.
+func eventDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	f, err := os.Open("event.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:   proto.String("event.proto"),
+		Syntax: proto.String("proto3"),
+	}
+	var msg *descriptorpb.DescriptorProto
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "//")
+		line = strings.TrimSpace(line)
+		if m := protoPackageRe.FindStringSubmatch(line); m != nil {
+			file.Package = proto.String(m[1])
+		} else if m := protoMessageRe.FindStringSubmatch(line); m != nil {
+			msg = &descriptorpb.DescriptorProto{Name: proto.String(m[1])}
+			file.MessageType = append(file.MessageType, msg)
+		} else if line == "}" {
+			msg = nil
+		} else if m := protoFieldRe.FindStringSubmatch(line); m != nil && msg != nil {
+			typ, ok := protoTypes[m[1]]
+			if !ok {
+				t.Fatalf("event.proto: unsupported type %s", m[1])
+			}
+			num, _ := strconv.Atoi(m[3])
+			msg.Field = append(msg.Field, &descriptorpb.FieldDescriptorProto{
+				Name:   proto.String(m[2]),
+				Number: proto.Int32(int32(num)),
+				Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:   typ.Enum(),
+			})
+		} else if line != "" && !strings.HasPrefix(line, "syntax") {
+			t.Fatalf("event.proto: unsupported line %q", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatalf("building descriptor of event.proto: %v", err)
+	}
+	desc := fd.Messages().ByName("Event")
+	if desc == nil {
+		t.Fatal("event.proto has no Event message")
+	}
+	return desc
+}
+
+// TestProtobufRoundTrip decodes the hand-written encoding with the protobuf
+// runtime and the descriptor of event.proto.
+func TestProtobufRoundTrip(t *testing.T) {
+	desc := eventDescriptor(t)
+
+	e := sampleEvent()
+	s, err := NewSerializer("protobuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := s.Marshal(e)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	msg := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(encoded, msg); err != nil {
+		t.Fatalf("proto.Unmarshal: %v", err)
+	}
+	if unknown := msg.GetUnknown(); len(unknown) != 0 {
+		t.Errorf("event.proto doesn't declare %d encoded bytes", len(unknown))
+	}
+
+	get := func(name string) protoreflect.Value {
+		fd := desc.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			t.Fatalf("event.proto has no field %s", name)
+		}
+		return msg.Get(fd)
+	}
+	if got := get("event_id").String(); got != e.ID {
+		t.Errorf("event_id = %q, want %q", got, e.ID)
+	}
+	if got := get("channel").String(); got != e.Channel {
+		t.Errorf("channel = %q, want %q", got, e.Channel)
+	}
+	if got := get("serial_number").String(); got != e.SerialNumber {
+		t.Errorf("serial_number = %q, want %q", got, e.SerialNumber)
+	}
+	if got := get("schema_version").Uint(); got != uint64(e.SchemaVersion) {
+		t.Errorf("schema_version = %d, want %d", got, e.SchemaVersion)
+	}
+	if got := get("time_unix_micro").Int(); got != e.Time.UnixMicro() {
+		t.Errorf("time_unix_micro = %d, want %d", got, e.Time.UnixMicro())
+	}
+	if got := get("payload").Bytes(); string(got) != string(e.Payload) {
+		t.Errorf("payload = %s, want %s", got, e.Payload)
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := NewSerializer("xml"); err == nil {
+		t.Error("NewSerializer accepted an unknown format")
+	}
+}
@@ -7,11 +7,15 @@ import (
 	"time"
 	"encoding/json"
 
+	"medical-gas-transport-service/internal/clock"
+
 	"github.com/eclipse/paho.golang/paho"
 	nanoid "github.com/matoous/go-nanoid/v2"
 )
 
-func (s *Service) HandleFilling(topic string, payload []byte) {
+const fillingChannel = "filling:transaction"
+
+func (s *Service) HandleFilling(topic string, payload []byte, receivedAt time.Time) {
 	serialNumber, err := extractSerialNumberFromTopic(topic)
 	if err != nil {
 		log.Printf("Error extracting serial number: %v", err)
@@ -29,6 +33,15 @@ func (s *Service) HandleFilling(topic string, payload []byte) {
 		return
 	}
 
+	// Filling events come from level gauges, so they keep the level
+	// reporting interval.
+	s.touchDevice(serialNumber, "level")
+
+	offset := s.observeClock(serialNumber, payload, receivedAt)
+	if !s.validateReading(serialNumber, topic, "filling", payload, receivedAt.Add(offset)) {
+		return
+	}
+
 	var fillingData FillingPayload
 	if err := json.Unmarshal(payload, &fillingData); err != nil {
 		log.Printf("Error parsing filling payload: %v", err)
@@ -40,41 +53,23 @@ func (s *Service) HandleFilling(topic string, payload []byte) {
 	var flag string
 
 	fillingData.SerialNumber = serialNumber
-	fillingData.Timestamp = time.Unix(fillingData.Ts, 0)
+	fillingData.DeviceTime = clock.FromUnix(fillingData.Ts, fillingData.TsUnit)
+	fillingData.Timestamp = fillingData.DeviceTime.Add(-offset)
+	fillingData.ReceivedAt = receivedAt
 	fillingData.State = fillingData.FillingState == 1
 
-	conversionTable, err := s.getConversionTableWithCache(serialNumber)
-	if err != nil {
+	var LevelInKilograms, LevelInMetersCubics *float64
+	kg, m3, err := s.convertLevel(serialNumber, device, fillingData.Level)
+	switch {
+	case err == nil:
+		LevelInKilograms, LevelInMetersCubics = &kg, &m3
+	case isConversionError(err):
+		log.Printf("Storing filling level for device %s without conversion: %v", serialNumber, err)
+	default:
 		log.Printf("Error getting conversion table: %v", err)
 		return
 	}
 
-	if len(conversionTable) == 0 {
-		log.Printf("Error empty conversion table for device %s", serialNumber)
-		return
-	}
-
-	slope := 42.84814815
-	intercept := -267.5185185
-	kgToMetersCubics := 0.777
-
-	for i := 0; i < len(conversionTable)-1; i++ {
-		if (conversionTable[i].InH2OMin <= fillingData.Level) && (fillingData.Level <= conversionTable[i].InH2OMax) {
-			slope = conversionTable[i].Slope
-			intercept = conversionTable[i].Intercept
-			break
-		}
-	}
-
-	var LevelInKilograms, LevelInMetersCubics float64
-	if fillingData.Level == 0 {
-		LevelInKilograms = 0
-		LevelInMetersCubics = 0
-	} else {
-		LevelInKilograms = (fillingData.Level * slope) + intercept
-		LevelInMetersCubics = LevelInKilograms * kgToMetersCubics
-	}
-
 	var NanoID string
 
 	if fillingData.NanoID != "" {
@@ -248,15 +243,32 @@ func (s *Service) HandleFilling(topic string, payload []byte) {
 	}
 	
 	if !skipWrite {
+		event := map[string]interface{}{
+			"serial_number": serialNumber,
+			"timestamp":     fillingData.Timestamp,
+			"device_time":   fillingData.DeviceTime,
+			"received_at":   fillingData.ReceivedAt,
+			"nano_id":       NanoID,
+			"level":         fillingData.Level,
+			"level_kg":      LevelInKilograms,
+			"level_m3":      LevelInMetersCubics,
+			"state":         fillingData.State,
+		}
 		if fillingData.State {
+			event["flag"] = flag
+		}
+		var eventJSON []byte
+		if eventJSON, err = json.Marshal(event); err != nil {
+			log.Printf("Error marshaling filling event: %v", err)
+		} else if fillingData.State {
 			query := `
 				INSERT INTO filling_transaction (
 					time, serial_number, nano_id, level, level_kg, level_meter_cubic,
-					state, flag
-				) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+					state, flag, device_time, received_at
+				) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 			`
 			
-			err = s.writeToTimescaleDB(query,
+			_, err = s.writeWithOutbox(s.eventMessages(fillingChannel, eventJSON), query,
 				fillingData.Timestamp,
 				fillingData.SerialNumber,
 				NanoID,
@@ -265,16 +277,18 @@ func (s *Service) HandleFilling(topic string, payload []byte) {
 				LevelInMetersCubics,
 				fillingData.State,
 				flag,
+				fillingData.DeviceTime,
+				fillingData.ReceivedAt,
 			)
 		} else {
 			query := `
 				INSERT INTO filling_transaction (
 					time, serial_number, nano_id, level, level_kg, level_meter_cubic,
-					state
-				) VALUES ($1, $2, $3, $4, $5, $6, $7)
+					state, device_time, received_at
+				) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 			`
 			
-			err = s.writeToTimescaleDB(query,
+			_, err = s.writeWithOutbox(s.eventMessages(fillingChannel, eventJSON), query,
 				fillingData.Timestamp,
 				fillingData.SerialNumber,
 				NanoID,
@@ -282,6 +296,8 @@ func (s *Service) HandleFilling(topic string, payload []byte) {
 				LevelInKilograms,
 				LevelInMetersCubics,
 				fillingData.State,
+				fillingData.DeviceTime,
+				fillingData.ReceivedAt,
 			)
 		}
 		
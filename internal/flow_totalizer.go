@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"medical-gas-transport-service/internal/services"
+)
+
+// totalizerState is the totalizer reading of the flow row stored before a
+// new one.
+type totalizerState struct {
+	Raw         float64
+	Cumulative  float64
+	Uptime      int
+	ResetReason int
+}
+
+type totalizerResult struct {
+	Cumulative float64
+	Delta      float64
+	// Event is "reset" or "wrap" when the reading needed special handling,
+	// empty otherwise.
+	Event string
+}
+
+// advanceTotalizer folds a raw counter reading into the running state. The
+// meter counter is a 32 bit register pair, so it either wraps at modulus or
+// restarts from zero when the device reboots; both cases keep the cumulative
+// volume monotonic.
+func advanceTotalizer(prev *totalizerState, raw float64, uptime, resetReason int, modulus float64) totalizerResult {
+	if prev == nil {
+		return totalizerResult{Cumulative: raw}
+	}
+
+	rebooted := uptime < prev.Uptime || resetReason != prev.ResetReason
+
+	var result totalizerResult
+	switch {
+	case raw >= prev.Raw:
+		result.Delta = raw - prev.Raw
+	case !rebooted && prev.Raw > modulus*0.9 && raw < modulus*0.1:
+		result.Delta = raw + modulus - prev.Raw
+		result.Event = "wrap"
+	default:
+		result.Delta = raw
+		result.Event = "reset"
+	}
+
+	result.Cumulative = prev.Cumulative + result.Delta
+	return result
+}
+
+// previousFlowState reads the totalizer state of the last flow row stored
+// for a device before t.
+func previousFlowState(ctx context.Context, tx *sql.Tx, serialNumber string, t time.Time) (*totalizerState, error) {
+	var prev totalizerState
+	var cumulative sql.NullFloat64
+	err := tx.QueryRowContext(ctx, `
+		SELECT total_volume, cumulative_volume, device_uptime, device_reset_reason
+		FROM sensor_flow
+		WHERE serial_number = $1 AND time < $2
+		ORDER BY time DESC
+		LIMIT 1
+	`, serialNumber, t).Scan(&prev.Raw, &cumulative, &prev.Uptime, &prev.ResetReason)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading previous flow reading: %w", err)
+	}
+
+	// Rows stored before the totalizer was tracked have no cumulative volume.
+	prev.Cumulative = prev.Raw
+	if cumulative.Valid {
+		prev.Cumulative = cumulative.Float64
+	}
+	return &prev, nil
+}
+
+// insertFlowReading stores a flow reading with its cumulative and delta
+// volume derived from the row stored before it, so the totalizer only
+// advances with rows that are actually stored, and allocates the delta to
+// the consumption rollups. Readings of one meter are serialized with an
+// advisory lock so concurrent workers and instances derive from the same
+// history. It returns false when the reading was already stored.
+func (s *Service) insertFlowReading(ctx context.Context, tx *sql.Tx, device *services.Device, flowData *SensorFlowData, backfilled bool) (bool, error) {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "sensor_flow/"+flowData.SerialNumber); err != nil {
+		return false, fmt.Errorf("error locking flow totalizer: %w", err)
+	}
+
+	prev, err := previousFlowState(ctx, tx, flowData.SerialNumber, flowData.Timestamp)
+	if err != nil {
+		return false, err
+	}
+	totalizer := advanceTotalizer(prev, flowData.TotalVolume, flowData.Device.DeviceUptime, flowData.Device.DeviceResetReason, s.cfg.Flow.CounterModulus)
+	flowData.CumulativeVolume = totalizer.Cumulative
+	flowData.DeltaVolume = totalizer.Delta
+
+	result, err := tx.ExecContext(ctx, insertStatement("sensor_flow", flowColumns, 1), flowRow(*flowData, backfilled)...)
+	if err != nil {
+		return false, fmt.Errorf("database error: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return false, nil
+	}
+
+	if totalizer.Event != "" {
+		log.Printf("Flow totalizer %s detected for device %s, raw %v, delta %v", totalizer.Event, flowData.SerialNumber, flowData.TotalVolume, totalizer.Delta)
+	}
+	if err := s.allocateConsumption(ctx, tx, device, *flowData); err != nil {
+		return false, err
+	}
+	if err := s.rederiveNextFlowRow(ctx, tx, device, *flowData); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// rederiveNextFlowRow updates the row stored after a reading that arrived
+// late, such as a backfilled one. That row's delta was derived from the row
+// before the new reading and now derives from the reading itself; when the
+// cumulative volume changes with it, the rows after it shift too. The
+// rollups get the difference in the row's bucket.
+func (s *Service) rederiveNextFlowRow(ctx context.Context, tx *sql.Tx, device *services.Device, flowData SensorFlowData) error {
+	var next totalizerState
+	var nextTime time.Time
+	var cumulative, delta sql.NullFloat64
+	err := tx.QueryRowContext(ctx, `
+		SELECT time, total_volume, cumulative_volume, delta_volume, device_uptime, device_reset_reason
+		FROM sensor_flow
+		WHERE serial_number = $1 AND time > $2
+		ORDER BY time
+		LIMIT 1
+	`, flowData.SerialNumber, flowData.Timestamp).Scan(&nextTime, &next.Raw, &cumulative, &delta, &next.Uptime, &next.ResetReason)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading next flow reading: %w", err)
+	}
+
+	totalizer := advanceTotalizer(&totalizerState{
+		Raw:         flowData.TotalVolume,
+		Cumulative:  flowData.CumulativeVolume,
+		Uptime:      flowData.Device.DeviceUptime,
+		ResetReason: flowData.Device.DeviceResetReason,
+	}, next.Raw, next.Uptime, next.ResetReason, s.cfg.Flow.CounterModulus)
+	change := totalizer.Delta - delta.Float64
+	if change != 0 {
+		_, err := tx.ExecContext(ctx, `UPDATE sensor_flow SET delta_volume = $3 WHERE serial_number = $1 AND time = $2`,
+			flowData.SerialNumber, nextTime, totalizer.Delta)
+		if err != nil {
+			return fmt.Errorf("error updating next flow reading: %w", err)
+		}
+		// Rollups only count readings with a delta, see allocateConsumption.
+		readings := 0
+		switch {
+		case delta.Float64 == 0 && totalizer.Delta > 0:
+			readings = 1
+		case delta.Float64 > 0 && totalizer.Delta == 0:
+			readings = -1
+		}
+		if err := s.allocateVolume(ctx, tx, device, flowData.SerialNumber, nextTime, change, readings); err != nil {
+			return err
+		}
+	}
+
+	// Rows stored before the totalizer was tracked have no cumulative volume.
+	if shift := totalizer.Cumulative - cumulative.Float64; cumulative.Valid && shift != 0 {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE sensor_flow SET cumulative_volume = cumulative_volume + $3
+			WHERE serial_number = $1 AND time >= $2 AND cumulative_volume IS NOT NULL
+		`, flowData.SerialNumber, nextTime, shift)
+		if err != nil {
+			return fmt.Errorf("error shifting cumulative flow volume: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Package gas holds the physical properties needed to turn a stored liquid
+// mass into the volume of gas it delivers.
+package gas
+
+import (
+	"strings"
+)
+
+// Universal gas constant in J/(mol·K).
+const gasConstant = 8.314462618
+
+// Reference is the temperature and pressure gas volumes are reported at.
+type Reference struct {
+	TemperatureC float64
+	PressureKPa  float64
+}
+
+type Gas struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	// MolarMass in g/mol.
+	MolarMass float64 `json:"molar_mass"`
+	// LiquidDensity in kg/m³ at typical bulk storage conditions.
+	LiquidDensity float64 `json:"liquid_density"`
+}
+
+// Density returns the gas density in kg/m³ at ref, treating the gas as ideal.
+func (g Gas) Density(ref Reference) float64 {
+	kelvin := ref.TemperatureC + 273.15
+	return (ref.PressureKPa * 1000) * (g.MolarMass / 1000) / (gasConstant * kelvin)
+}
+
+// ExpansionRatio returns how many volumes of gas at ref one volume of
+// liquid expands to.
+func (g Gas) ExpansionRatio(ref Reference) float64 {
+	return g.LiquidDensity / g.Density(ref)
+}
+
+// VolumeFromMass returns the gas volume in m³ at ref for kg of product.
+func (g Gas) VolumeFromMass(kg float64, ref Reference) float64 {
+	return kg / g.Density(ref)
+}
+
+var registry = map[string]Gas{
+	"liquid_oxygen":   {Key: "liquid_oxygen", Name: "Liquid Oxygen", MolarMass: 31.998, LiquidDensity: 1141},
+	"liquid_nitrogen": {Key: "liquid_nitrogen", Name: "Liquid Nitrogen", MolarMass: 28.014, LiquidDensity: 808},
+	"liquid_co2":      {Key: "liquid_co2", Name: "Liquid Carbon Dioxide", MolarMass: 44.01, LiquidDensity: 1032},
+	"argon":           {Key: "argon", Name: "Liquid Argon", MolarMass: 39.948, LiquidDensity: 1395},
+	"nitrous_oxide":   {Key: "nitrous_oxide", Name: "Nitrous Oxide", MolarMass: 44.013, LiquidDensity: 1222},
+}
+
+var aliases = map[string]string{
+	"oxygen":         "liquid_oxygen",
+	"o2":             "liquid_oxygen",
+	"lox":            "liquid_oxygen",
+	"nitrogen":       "liquid_nitrogen",
+	"n2":             "liquid_nitrogen",
+	"lin":            "liquid_nitrogen",
+	"co2":            "liquid_co2",
+	"carbon_dioxide": "liquid_co2",
+	"liquid_argon":   "argon",
+	"ar":             "argon",
+	"lar":            "argon",
+	"n2o":            "nitrous_oxide",
+}
+
+// Lookup finds a gas by key or common alias, ignoring case, spaces and dashes.
+func Lookup(name string) (Gas, bool) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	key = strings.NewReplacer(" ", "_", "-", "_").Replace(key)
+	if alias, ok := aliases[key]; ok {
+		key = alias
+	}
+	g, ok := registry[key]
+	return g, ok
+}
+
+// All returns every registered gas.
+func All() []Gas {
+	gases := make([]Gas, 0, len(registry))
+	for _, g := range registry {
+		gases = append(gases, g)
+	}
+	return gases
+}
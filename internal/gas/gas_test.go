@@ -0,0 +1,81 @@
+package gas
+
+import (
+	"math"
+	"testing"
+)
+
+var standard = Reference{TemperatureC: 15, PressureKPa: 101.325}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-6*math.Max(1, math.Abs(b))
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		ok   bool
+	}{
+		{"liquid_oxygen", "liquid_oxygen", true},
+		{"O2", "liquid_oxygen", true},
+		{" Liquid Oxygen ", "liquid_oxygen", true},
+		{"liquid-nitrogen", "liquid_nitrogen", true},
+		{"LIN", "liquid_nitrogen", true},
+		{"Carbon Dioxide", "liquid_co2", true},
+		{"ar", "argon", true},
+		{"N2O", "nitrous_oxide", true},
+		{"helium", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		g, ok := Lookup(tt.name)
+		if ok != tt.ok || g.Key != tt.key {
+			t.Errorf("Lookup(%q) = %q, %v, want %q, %v", tt.name, g.Key, ok, tt.key, tt.ok)
+		}
+	}
+}
+
+func TestAliasesResolve(t *testing.T) {
+	for alias, key := range aliases {
+		if _, ok := registry[key]; !ok {
+			t.Errorf("alias %q points to unknown gas %q", alias, key)
+		}
+	}
+	if len(All()) != len(registry) {
+		t.Errorf("All() returned %d gases, want %d", len(All()), len(registry))
+	}
+}
+
+func TestDensity(t *testing.T) {
+	oxygen, _ := Lookup("oxygen")
+	nitrogen, _ := Lookup("nitrogen")
+	tests := []struct {
+		name string
+		gas  Gas
+		ref  Reference
+		want float64
+	}{
+		{"oxygen at 15 °C", oxygen, standard, 1.3532768},
+		{"oxygen at 0 °C", oxygen, Reference{TemperatureC: 0, PressureKPa: 101.325}, 1.4275918},
+		{"nitrogen at 20 °C", nitrogen, Reference{TemperatureC: 20, PressureKPa: 101.325}, 1.1645755},
+	}
+	for _, tt := range tests {
+		if got := tt.gas.Density(tt.ref); !approxEqual(got, tt.want) {
+			t.Errorf("%s: Density() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVolume(t *testing.T) {
+	oxygen, _ := Lookup("oxygen")
+	if got := oxygen.ExpansionRatio(standard); !approxEqual(got, 843.1386671) {
+		t.Errorf("ExpansionRatio() = %v, want 843.1386671", got)
+	}
+	if got := oxygen.VolumeFromMass(1000, standard); !approxEqual(got, 738.9471228) {
+		t.Errorf("VolumeFromMass(1000) = %v, want 738.9471228", got)
+	}
+	if got := oxygen.VolumeFromMass(0, standard); got != 0 {
+		t.Errorf("VolumeFromMass(0) = %v, want 0", got)
+	}
+}
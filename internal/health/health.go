@@ -0,0 +1,189 @@
+// Package health derives device health from the Device block every payload
+// carries: reboots, radio quality, enclosure climate and memory growth.
+package health
+
+import (
+	"fmt"
+	"time"
+)
+
+// resetReasons maps the ESP-IDF esp_reset_reason_t codes reported as
+// resetReason by the device firmware.
+var resetReasons = map[int]string{
+	0:  "unknown",
+	1:  "power_on",
+	2:  "external_pin",
+	3:  "software",
+	4:  "panic",
+	5:  "interrupt_watchdog",
+	6:  "task_watchdog",
+	7:  "other_watchdog",
+	8:  "deep_sleep",
+	9:  "brownout",
+	10: "sdio",
+}
+
+// abnormalResets are reset reasons that point at a firmware or power fault.
+var abnormalResets = map[int]bool{4: true, 5: true, 6: true, 7: true, 9: true}
+
+func ResetReasonName(code int) string {
+	if name, ok := resetReasons[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("code_%d", code)
+}
+
+type Thresholds struct {
+	LowRSSI      int
+	HighTemp     float64
+	HighHumidity float64
+	// MemoryLeakRate is the sustained memory growth per hour that counts
+	// as a leak, in the unit the device reports memory in.
+	MemoryLeakRate float64
+	MemoryWindow   int
+}
+
+type Sample struct {
+	Time        time.Time
+	Uptime      int
+	Temp        float64
+	Hum         float64
+	RSSI        int
+	MemUsage    int
+	ResetReason int
+}
+
+type MemoryPoint struct {
+	Time  time.Time `json:"time"`
+	Usage int       `json:"usage"`
+}
+
+// State is what Analyze needs to remember between samples of one device.
+type State struct {
+	LastTime   time.Time       `json:"last_time"`
+	LastUptime int             `json:"last_uptime"`
+	LastReboot time.Time       `json:"last_reboot"`
+	LastReason int             `json:"last_reason"`
+	Reboots    int             `json:"reboots"`
+	Memory     []MemoryPoint   `json:"memory"`
+	Active     map[string]bool `json:"active"`
+}
+
+type Event struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+type condition struct {
+	name   string
+	active bool
+	detail string
+}
+
+type Result struct {
+	State  State
+	Events []Event
+	Score  int
+}
+
+// Analyze folds sample into prev and returns the new state, the events the
+// sample raised or cleared, and a 0-100 health score.
+func Analyze(prev *State, sample Sample, th Thresholds) Result {
+	var state State
+	if prev != nil {
+		state = *prev
+	}
+	if state.Active == nil {
+		state.Active = make(map[string]bool)
+	}
+	if !state.LastTime.IsZero() && !sample.Time.After(state.LastTime) {
+		return Result{State: state, Score: score(state, sample.Time)}
+	}
+
+	var events []Event
+
+	if prev != nil && sample.Uptime < state.LastUptime {
+		state.Reboots++
+		state.LastReboot = sample.Time
+		state.LastReason = sample.ResetReason
+		state.Memory = nil
+		events = append(events, Event{
+			Type:   "reboot",
+			Detail: fmt.Sprintf("uptime went from %d to %d, reset reason %s", state.LastUptime, sample.Uptime, ResetReasonName(sample.ResetReason)),
+		})
+	}
+
+	state.Memory = append(state.Memory, MemoryPoint{Time: sample.Time, Usage: sample.MemUsage})
+	if th.MemoryWindow > 0 && len(state.Memory) > th.MemoryWindow {
+		state.Memory = state.Memory[len(state.Memory)-th.MemoryWindow:]
+	}
+
+	conditions := []condition{
+		{"low_rssi", sample.RSSI != 0 && sample.RSSI < th.LowRSSI, fmt.Sprintf("rssi %d dBm below %d dBm", sample.RSSI, th.LowRSSI)},
+		{"high_temperature", sample.Temp > th.HighTemp, fmt.Sprintf("enclosure temperature %.1f above %.1f", sample.Temp, th.HighTemp)},
+		{"high_humidity", sample.Hum > th.HighHumidity, fmt.Sprintf("enclosure humidity %.1f above %.1f", sample.Hum, th.HighHumidity)},
+		memoryLeak(state.Memory, th),
+	}
+	for _, c := range conditions {
+		switch {
+		case c.active && !state.Active[c.name]:
+			state.Active[c.name] = true
+			events = append(events, Event{Type: c.name, Detail: c.detail})
+		case !c.active && state.Active[c.name]:
+			delete(state.Active, c.name)
+			events = append(events, Event{Type: c.name + "_cleared"})
+		}
+	}
+
+	state.LastTime = sample.Time
+	state.LastUptime = sample.Uptime
+	return Result{State: state, Events: events, Score: score(state, sample.Time)}
+}
+
+// memoryLeak reports a leak when memory usage never dropped across a full
+// window and grew faster than the configured rate.
+func memoryLeak(points []MemoryPoint, th Thresholds) condition {
+	result := condition{name: "memory_leak"}
+
+	if th.MemoryWindow < 2 || len(points) < th.MemoryWindow {
+		return result
+	}
+	for i := 1; i < len(points); i++ {
+		if points[i].Usage < points[i-1].Usage {
+			return result
+		}
+	}
+
+	first, last := points[0], points[len(points)-1]
+	hours := last.Time.Sub(first.Time).Hours()
+	if hours <= 0 {
+		return result
+	}
+	rate := float64(last.Usage-first.Usage) / hours
+	result.active = rate >= th.MemoryLeakRate
+	result.detail = fmt.Sprintf("memory grew from %d to %d over %.1f hours", first.Usage, last.Usage, hours)
+	return result
+}
+
+func score(state State, now time.Time) int {
+	s := 100
+	penalties := map[string]int{
+		"low_rssi":         20,
+		"high_temperature": 20,
+		"high_humidity":    15,
+		"memory_leak":      25,
+	}
+	for name := range state.Active {
+		s -= penalties[name]
+	}
+	if !state.LastReboot.IsZero() && now.Sub(state.LastReboot) < 24*time.Hour {
+		s -= 10
+		if abnormalResets[state.LastReason] {
+			s -= 10
+		}
+	}
+	if s < 0 {
+		s = 0
+	}
+	return s
+}
@@ -0,0 +1,208 @@
+package health
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	start      = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	thresholds = Thresholds{LowRSSI: -100, HighTemp: 60, HighHumidity: 85, MemoryLeakRate: 100, MemoryWindow: 3}
+)
+
+// healthy is a sample that raises no condition, taken hours after start.
+func healthy(hours int) Sample {
+	return Sample{
+		Time:     start.Add(time.Duration(hours) * time.Hour),
+		Uptime:   3600 * (hours + 1),
+		Temp:     25,
+		Hum:      40,
+		RSSI:     -70,
+		MemUsage: 1000,
+	}
+}
+
+func eventTypes(events []Event) []string {
+	types := []string{}
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name      string
+		samples   func() []Sample
+		events    []string
+		score     int
+		reboots   int
+		activeLen int
+	}{
+		{
+			name:    "healthy",
+			samples: func() []Sample { return []Sample{healthy(0), healthy(1)} },
+			events:  []string{},
+			score:   100,
+		},
+		{
+			name: "low rssi",
+			samples: func() []Sample {
+				s := healthy(1)
+				s.RSSI = -110
+				return []Sample{healthy(0), s}
+			},
+			events:    []string{"low_rssi"},
+			score:     80,
+			activeLen: 1,
+		},
+		{
+			name: "missing rssi",
+			samples: func() []Sample {
+				s := healthy(0)
+				s.RSSI = 0
+				return []Sample{s}
+			},
+			events: []string{},
+			score:  100,
+		},
+		{
+			name: "hot and humid",
+			samples: func() []Sample {
+				s := healthy(0)
+				s.Temp, s.Hum = 65, 90
+				return []Sample{s}
+			},
+			events:    []string{"high_temperature", "high_humidity"},
+			score:     65,
+			activeLen: 2,
+		},
+		{
+			name: "cleared",
+			samples: func() []Sample {
+				s := healthy(0)
+				s.Temp = 65
+				return []Sample{s, healthy(1)}
+			},
+			events: []string{"high_temperature_cleared"},
+			score:  100,
+		},
+		{
+			name: "normal reboot",
+			samples: func() []Sample {
+				s := healthy(2)
+				s.Uptime, s.ResetReason = 60, 1
+				return []Sample{healthy(0), healthy(1), s}
+			},
+			events:  []string{"reboot"},
+			score:   90,
+			reboots: 1,
+		},
+		{
+			name: "brownout reboot",
+			samples: func() []Sample {
+				s := healthy(1)
+				s.Uptime, s.ResetReason = 60, 9
+				return []Sample{healthy(0), s}
+			},
+			events:  []string{"reboot"},
+			score:   80,
+			reboots: 1,
+		},
+		{
+			name: "memory leak",
+			samples: func() []Sample {
+				var samples []Sample
+				for i := 0; i < 3; i++ {
+					s := healthy(i)
+					s.MemUsage = 1000 + 200*i
+					samples = append(samples, s)
+				}
+				return samples
+			},
+			events:    []string{"memory_leak"},
+			score:     75,
+			activeLen: 1,
+		},
+		{
+			name: "slow memory growth",
+			samples: func() []Sample {
+				var samples []Sample
+				for i := 0; i < 3; i++ {
+					s := healthy(i)
+					s.MemUsage = 1000 + 50*i
+					samples = append(samples, s)
+				}
+				return samples
+			},
+			events: []string{},
+			score:  100,
+		},
+		{
+			name: "memory dropped",
+			samples: func() []Sample {
+				var samples []Sample
+				for i, usage := range []int{1000, 900, 1500} {
+					s := healthy(i)
+					s.MemUsage = usage
+					samples = append(samples, s)
+				}
+				return samples
+			},
+			events: []string{},
+			score:  100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prev *State
+			var result Result
+			for _, s := range tt.samples() {
+				result = Analyze(prev, s, thresholds)
+				prev = &result.State
+			}
+			if got := eventTypes(result.Events); !reflect.DeepEqual(got, tt.events) {
+				t.Errorf("events = %v, want %v", got, tt.events)
+			}
+			if result.Score != tt.score {
+				t.Errorf("score = %d, want %d", result.Score, tt.score)
+			}
+			if result.State.Reboots != tt.reboots {
+				t.Errorf("reboots = %d, want %d", result.State.Reboots, tt.reboots)
+			}
+			if len(result.State.Active) != tt.activeLen {
+				t.Errorf("active = %v, want %d conditions", result.State.Active, tt.activeLen)
+			}
+		})
+	}
+}
+
+func TestAnalyzeIgnoresOldSamples(t *testing.T) {
+	first := Analyze(nil, healthy(1), thresholds)
+	old := healthy(0)
+	old.Temp = 65
+	result := Analyze(&first.State, old, thresholds)
+	if len(result.Events) != 0 || !reflect.DeepEqual(result.State, first.State) {
+		t.Errorf("an older sample changed the state: %+v", result)
+	}
+}
+
+func TestRebootPenaltyExpires(t *testing.T) {
+	reboot := healthy(1)
+	reboot.Uptime, reboot.ResetReason = 60, 4
+	state := Analyze(nil, healthy(0), thresholds).State
+	state = Analyze(&state, reboot, thresholds).State
+	if got := score(state, reboot.Time.Add(25*time.Hour)); got != 100 {
+		t.Errorf("score a day after the reboot = %d, want 100", got)
+	}
+}
+
+func TestResetReasonName(t *testing.T) {
+	if got := ResetReasonName(9); got != "brownout" {
+		t.Errorf("ResetReasonName(9) = %q, want brownout", got)
+	}
+	if got := ResetReasonName(42); got != "code_42" {
+		t.Errorf("ResetReasonName(42) = %q, want code_42", got)
+	}
+}
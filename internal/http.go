@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (s *Service) registerRoutes() {
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/webhooks/jaya/invalidate", s.handleInvalidateWebhook)
+	s.mux.HandleFunc("/consumption", s.requireAPIKey(s.handleConsumption))
+	s.mux.HandleFunc("/inventory", s.requireAPIKey(s.handleInventory))
+	s.mux.HandleFunc("/inventory/events", s.requireFleetKey(s.handleInventoryEvents))
+	s.mux.HandleFunc("/readings/latest", s.requireAPIKey(s.handleLatestReadings))
+	s.mux.HandleFunc("/readings/", s.requireAPIKey(s.handleReadingHistory))
+	s.mux.HandleFunc("/filling-transactions", s.requireAPIKey(s.handleFillingTransactions))
+	s.mux.HandleFunc("/stream/events", s.requireAPIKey(s.handleEventStream))
+	s.mux.HandleFunc("/stream/ws", s.requireAPIKey(s.handleWebSocketStream))
+	s.mux.HandleFunc("/ota/firmware", s.requireFleetKey(s.handleFirmware))
+	s.mux.HandleFunc("/ota/firmware/download/", s.handleFirmwareDownload)
+	s.mux.HandleFunc("/ota/campaigns", s.requireFleetKey(s.handleCampaigns))
+	s.mux.HandleFunc("/ota/campaigns/", s.requireFleetKey(s.handleCampaignAction))
+	s.mux.HandleFunc("/shadows/", s.requireFleetKey(s.handleShadow))
+	s.mux.HandleFunc("/provisioning/allowlist", s.requireFleetKey(s.handleProvisioningAllowlist))
+	s.mux.HandleFunc("/provisioning/audit", s.requireFleetKey(s.handleProvisioningAudit))
+	s.mux.HandleFunc("/credentials/", s.requireFleetKey(s.handleCredentials))
+	s.mux.HandleFunc("/pki/crl", s.handleCRL)
+	s.mux.HandleFunc("/clock/skew", s.requireFleetKey(s.handleClockSkew))
+	s.mux.HandleFunc("/validation/counters", s.requireFleetKey(s.handleValidationCounters))
+	s.mux.HandleFunc("/validation/quarantine", s.requireFleetKey(s.handleQuarantine))
+}
+
+type hospitalScopeKey struct{}
+
+// requireAPIKey accepts requests carrying one of the configured API keys in
+// the X-API-Key header, as a bearer token or, on /stream/ routes only, for
+// browser EventSource and WebSocket clients that can't set headers, in the
+// api_key query parameter.
+// The hospital the key is scoped to, if any, is stored in the request context.
+func (s *Service) requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if key == "" && strings.HasPrefix(r.URL.Path, "/stream/") {
+			key = r.URL.Query().Get("api_key")
+		}
+		if key == "" {
+			writeError(w, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+
+		for allowed, hospital := range s.cfg.HTTP.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(allowed)) == 1 {
+				next(w, r.WithContext(context.WithValue(r.Context(), hospitalScopeKey{}, hospital)))
+				return
+			}
+		}
+		writeError(w, http.StatusUnauthorized, "invalid API key")
+	}
+}
+
+// requireFleetKey only accepts API keys that are not scoped to a hospital.
+func (s *Service) requireFleetKey(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		if hospitalScope(r) != "" {
+			writeError(w, http.StatusForbidden, "API key is scoped to a hospital")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// hospitalScope returns the hospital the request's API key is scoped to, or
+// an empty string for fleet-wide keys.
+func hospitalScope(r *http.Request) string {
+	hospital, _ := r.Context().Value(hospitalScopeKey{}).(string)
+	return hospital
+}
+
+// scopedHospital resolves the hospital filter of a request. Scoped keys
+// always filter by their own hospital and may not ask for another one.
+func scopedHospital(w http.ResponseWriter, r *http.Request, requested string) (string, bool) {
+	scope := hospitalScope(r)
+	if scope == "" {
+		return requested, true
+	}
+	if requested != "" && requested != scope {
+		writeError(w, http.StatusForbidden, "API key is not allowed to access this hospital")
+		return "", false
+	}
+	return scope, true
+}
+
+func (s *Service) startHTTPServer() {
+	if s.cfg.HTTP.Addr == "" {
+		log.Printf("HTTP_ADDR is empty, HTTP server disabled")
+		return
+	}
+
+	s.registerRoutes()
+	server := &http.Server{
+		Addr:              s.cfg.HTTP.Addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Printf("HTTP server listening on %s", s.cfg.HTTP.Addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP server error: %v", err)
+		}
+	}()
+
+	go func() {
+		<-s.ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down HTTP server: %v", err)
+		}
+	}()
+}
+
+func (s *Service) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// pagination parses limit and offset query values, writing a 400 response
+// when they are invalid.
+func pagination(w http.ResponseWriter, rawLimit, rawOffset string) (int, int, bool) {
+	limit, offset := 100, 0
+	var err error
+	if rawLimit != "" {
+		if limit, err = strconv.Atoi(rawLimit); err != nil || limit <= 0 || limit > 1000 {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return 0, 0, false
+		}
+	}
+	if rawOffset != "" {
+		if offset, err = strconv.Atoi(rawOffset); err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return 0, 0, false
+		}
+	}
+	return limit, offset, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing HTTP response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"status": "error", "message": message})
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	invalidateDeviceChannel          = "invalidate:device"
+	invalidateConversionTableChannel = "invalidate:conversion_table"
+)
+
+type InvalidateRequest struct {
+	Type          string   `json:"type"`
+	SerialNumber  string   `json:"serial_number,omitempty"`
+	SerialNumbers []string `json:"serial_numbers,omitempty"`
+}
+
+// subscribeToInvalidations evicts cache entries announced on the invalidation
+// channels. Messages carry the serial number as the payload, so Jaya core can
+// publish to these channels directly as well as through the webhook.
+func (s *Service) subscribeToInvalidations() {
+	pubsub := s.redisClient.Rdb.Subscribe(s.ctx, invalidateDeviceChannel, invalidateConversionTableChannel)
+
+	go func() {
+		defer pubsub.Close()
+		for msg := range pubsub.Channel() {
+			serialNumber := strings.TrimSpace(msg.Payload)
+			if serialNumber == "" {
+				continue
+			}
+
+			var err error
+			switch msg.Channel {
+			case invalidateDeviceChannel:
+				err = s.deviceCache.Invalidate(s.ctx, serialNumber)
+			case invalidateConversionTableChannel:
+				err = s.conversionCache.Invalidate(s.ctx, serialNumber)
+			}
+			if err != nil {
+				log.Printf("Error invalidating %s for device %s: %v", msg.Channel, serialNumber, err)
+				continue
+			}
+			log.Printf("Invalidated %s cache for device %s", strings.TrimPrefix(msg.Channel, "invalidate:"), serialNumber)
+		}
+	}()
+}
+
+func (s *Service) handleInvalidateWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if s.cfg.HTTP.WebhookToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.HTTP.WebhookToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid webhook token")
+		return
+	}
+
+	var req InvalidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	var channel string
+	switch req.Type {
+	case "device":
+		channel = invalidateDeviceChannel
+	case "conversion_table":
+		channel = invalidateConversionTableChannel
+	default:
+		writeError(w, http.StatusBadRequest, "type must be device or conversion_table")
+		return
+	}
+
+	serialNumbers := req.SerialNumbers
+	if req.SerialNumber != "" {
+		serialNumbers = append(serialNumbers, req.SerialNumber)
+	}
+	if len(serialNumbers) == 0 {
+		writeError(w, http.StatusBadRequest, "serial_number is required")
+		return
+	}
+
+	for _, serialNumber := range serialNumbers {
+		if err := s.redisClient.Rdb.Publish(r.Context(), channel, serialNumber).Err(); err != nil {
+			log.Printf("Error publishing %s for device %s: %v", channel, serialNumber, err)
+			writeError(w, http.StatusBadGateway, "failed to publish invalidation")
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"status":         "accepted",
+		"type":           req.Type,
+		"serial_numbers": serialNumbers,
+	})
+}
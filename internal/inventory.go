@@ -0,0 +1,307 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"medical-gas-transport-service/internal/services"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// setField sets a hash field along with the time of the reading it came
+// from, unless a newer reading already set it.
+var setField = redis.NewScript(`
+local at = tonumber(redis.call('HGET', KEYS[1], ARGV[1] .. '/at') or '0')
+if at >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2], ARGV[1] .. '/at', ARGV[3])
+return 1
+`)
+
+type InventoryItem struct {
+	SerialNumber string    `json:"serial_number"`
+	DeviceType   string    `json:"device_type"`
+	Hospital     string    `json:"hospital"`
+	Model        string    `json:"model"`
+	HWVer        string    `json:"hw_ver"`
+	FWVer        string    `json:"fw_ver"`
+	RDVer        string    `json:"rd_ver"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+type VersionEvent struct {
+	Time         time.Time `json:"time"`
+	SerialNumber string    `json:"serial_number"`
+	Component    string    `json:"component"`
+	OldValue     string    `json:"old_value"`
+	NewValue     string    `json:"new_value"`
+	Change       string    `json:"change"`
+}
+
+// trackInventory keeps device_inventory in line with the versions a device
+// reports and records every change as a version event. Readings older than
+// the last one a version was taken from don't change it, and empty versions
+// are ignored. The database is only touched on changes and, for last_seen,
+// once per touch interval.
+func (s *Service) trackInventory(serialNumber, deviceType string, device *services.Device, d Device, timestamp time.Time) {
+	key := "inventory/" + serialNumber
+	rdb := s.redisClient.Rdb
+
+	components := []struct {
+		name  string
+		value string
+	}{
+		{"model", d.DeviceModel},
+		{"hw_ver", d.DeviceHWVer},
+		{"fw_ver", d.DeviceFWVer},
+		{"rd_ver", d.DeviceRDVer},
+	}
+
+	fields := make([]string, 0, 2*len(components))
+	for _, c := range components {
+		fields = append(fields, c.name, c.name+"/at")
+	}
+	current, err := rdb.HMGet(s.ctx, key, fields...).Result()
+	if err != nil {
+		log.Printf("Error tracking inventory for device %s: %v", serialNumber, err)
+		return
+	}
+
+	changed := false
+	for i, c := range components {
+		if c.value == "" {
+			continue
+		}
+		old, known := current[2*i].(string)
+		at, _ := current[2*i+1].(string)
+		if last, err := strconv.ParseInt(at, 10, 64); err == nil && last >= timestamp.UnixMicro() {
+			continue
+		}
+
+		if known && old != "" && old != c.value {
+			// The event is stored before the version is swapped so a failed
+			// write is retried with the next reading instead of lost.
+			if err := s.recordVersionChange(serialNumber, c.name, old, c.value, timestamp); err != nil {
+				log.Printf("Error writing version event for device %s: %v", serialNumber, err)
+				return
+			}
+		}
+
+		set, err := setField.Run(s.ctx, rdb, []string{key}, c.name, c.value, timestamp.UnixMicro()).Int()
+		if err != nil {
+			log.Printf("Error tracking inventory for device %s: %v", serialNumber, err)
+			return
+		}
+		if set == 1 && old != c.value {
+			changed = true
+		}
+	}
+
+	if !changed {
+		touched, err := rdb.SetNX(s.ctx, key+"/touched", 1, s.cfg.Inventory.TouchInterval).Result()
+		if err != nil || !touched {
+			return
+		}
+	}
+
+	err = s.writeToTimescaleDBWithRetry(`
+		INSERT INTO device_inventory (
+			serial_number, device_type, hospital, model, hw_ver, fw_ver, rd_ver, first_seen, last_seen
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		ON CONFLICT (serial_number) DO UPDATE SET
+			device_type = EXCLUDED.device_type, hospital = EXCLUDED.hospital,
+			model = COALESCE(NULLIF(EXCLUDED.model, ''), device_inventory.model),
+			hw_ver = COALESCE(NULLIF(EXCLUDED.hw_ver, ''), device_inventory.hw_ver),
+			fw_ver = COALESCE(NULLIF(EXCLUDED.fw_ver, ''), device_inventory.fw_ver),
+			rd_ver = COALESCE(NULLIF(EXCLUDED.rd_ver, ''), device_inventory.rd_ver),
+			last_seen = EXCLUDED.last_seen
+		WHERE device_inventory.last_seen <= EXCLUDED.last_seen
+	`, serialNumber, deviceType, device.Hospital.ID, d.DeviceModel, d.DeviceHWVer, d.DeviceFWVer, d.DeviceRDVer, timestamp)
+	if err != nil {
+		log.Printf("Error writing inventory for device %s: %v", serialNumber, err)
+	}
+}
+
+// recordVersionChange stores a version event and publishes it through the
+// outbox. Instances that see the same change write it only once.
+func (s *Service) recordVersionChange(serialNumber, component, old, new string, timestamp time.Time) error {
+	event := VersionEvent{
+		Time:         timestamp,
+		SerialNumber: serialNumber,
+		Component:    component,
+		OldValue:     old,
+		NewValue:     new,
+		Change:       versionChange(old, new),
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshaling version event: %w", err)
+	}
+
+	inserted, err := s.writeWithOutbox(s.eventMessages("device:version", eventJSON), `
+		INSERT INTO device_version_event (time, serial_number, component, old_value, new_value, change)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (serial_number, component, time) DO NOTHING
+	`, event.Time, event.SerialNumber, event.Component, event.OldValue, event.NewValue, event.Change)
+	if err != nil {
+		return err
+	}
+	if inserted {
+		log.Printf("Device %s %s changed from %q to %q (%s)", serialNumber, component, old, new, event.Change)
+	}
+	return nil
+}
+
+func versionChange(old, new string) string {
+	switch compareVersions(new, old) {
+	case 1:
+		return "upgrade"
+	case -1:
+		return "downgrade"
+	}
+	return "change"
+}
+
+// compareVersions compares the numeric parts of two version strings such as
+// "v1.2.10" and "1.3.0-rc1". Non numeric parts are ignored.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		switch {
+		case x > y:
+			return 1
+		case x < y:
+			return -1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	fields := strings.FieldsFunc(v, func(r rune) bool { return r < '0' || r > '9' })
+	parts := make([]int, 0, len(fields))
+	for _, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			continue
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+func (s *Service) handleInventory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	limit, offset, ok := pagination(w, q.Get("limit"), q.Get("offset"))
+	if !ok {
+		return
+	}
+	hospital, ok := scopedHospital(w, r, q.Get("hospital"))
+	if !ok {
+		return
+	}
+
+	rows, err := s.timescaleClient.DB.QueryContext(r.Context(), `
+		SELECT serial_number, device_type, hospital, model, hw_ver, fw_ver, rd_ver, first_seen, last_seen
+		FROM device_inventory
+		WHERE ($1 = '' OR model = $1)
+		AND ($2 = '' OR hw_ver = $2)
+		AND ($3 = '' OR fw_ver = $3)
+		AND ($4 = '' OR hospital = $4)
+		AND ($5 = '' OR device_type = $5)
+		ORDER BY serial_number
+	`, q.Get("model"), q.Get("hw_ver"), q.Get("fw_ver"), hospital, q.Get("device_type"))
+	if err != nil {
+		log.Printf("Error querying inventory: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to query inventory")
+		return
+	}
+	defer rows.Close()
+
+	fwBelow := q.Get("fw_below")
+	items := []InventoryItem{}
+	for rows.Next() {
+		var item InventoryItem
+		if err := rows.Scan(&item.SerialNumber, &item.DeviceType, &item.Hospital, &item.Model, &item.HWVer, &item.FWVer, &item.RDVer, &item.FirstSeen, &item.LastSeen); err != nil {
+			log.Printf("Error scanning inventory: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to query inventory")
+			return
+		}
+		if fwBelow != "" && compareVersions(item.FWVer, fwBelow) >= 0 {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	total := len(items)
+	items = items[min(offset, total):min(offset+limit, total)]
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"total":  total,
+		"data":   items,
+	})
+}
+
+func (s *Service) handleInventoryEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	limit, offset, ok := pagination(w, q.Get("limit"), q.Get("offset"))
+	if !ok {
+		return
+	}
+
+	rows, err := s.timescaleClient.DB.QueryContext(r.Context(), `
+		SELECT time, serial_number, component, old_value, new_value, change
+		FROM device_version_event
+		WHERE ($1 = '' OR serial_number = $1)
+		AND ($2 = '' OR component = $2)
+		ORDER BY time DESC
+		LIMIT $3 OFFSET $4
+	`, q.Get("serial_number"), q.Get("component"), limit, offset)
+	if err != nil {
+		log.Printf("Error querying version events: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to query version events")
+		return
+	}
+	defer rows.Close()
+
+	events := []VersionEvent{}
+	for rows.Next() {
+		var e VersionEvent
+		if err := rows.Scan(&e.Time, &e.SerialNumber, &e.Component, &e.OldValue, &e.NewValue, &e.Change); err != nil {
+			log.Printf("Error scanning version event: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to query version events")
+			return
+		}
+		events = append(events, e)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   events,
+	})
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"errors"
+	"log"
+
+	"medical-gas-transport-service/internal/conversion"
+	"medical-gas-transport-service/internal/gas"
+	"medical-gas-transport-service/internal/services"
+)
+
+// convertLevel converts a level reading to the mass in the tank and the gas
+// volume that mass delivers at the configured reference conditions.
+func (s *Service) convertLevel(serialNumber string, device *services.Device, level float64) (float64, float64, error) {
+	kg, err := s.levelToKilograms(serialNumber, level)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return kg, s.gasForDevice(device).VolumeFromMass(kg, s.gasReference()), nil
+}
+
+// gasForDevice returns the gas held by the device's tank, falling back to the
+// configured default when Jaya has no or an unknown gas type.
+func (s *Service) gasForDevice(device *services.Device) gas.Gas {
+	if gasType := device.InstallationPointTank.GasType; gasType != "" {
+		if g, ok := gas.Lookup(gasType); ok {
+			return g
+		}
+		log.Printf("Unknown gas type %q for device %s, using %s", gasType, device.SerialNumber, s.cfg.Gas.Default)
+	}
+
+	if g, ok := gas.Lookup(s.cfg.Gas.Default); ok {
+		return g
+	}
+	g, _ := gas.Lookup("liquid_oxygen")
+	return g
+}
+
+func (s *Service) gasReference() gas.Reference {
+	return gas.Reference{
+		TemperatureC: s.cfg.Gas.ReferenceTemperature,
+		PressureKPa:  s.cfg.Gas.ReferencePressure,
+	}
+}
+
+// levelToKilograms converts a level reading to kilograms with the device's
+// tank conversion table. An empty tank reads zero regardless of the table.
+func (s *Service) levelToKilograms(serialNumber string, level float64) (float64, error) {
+	if level == 0 {
+		return 0, nil
+	}
+
+	table, err := s.getConversionTableWithCache(serialNumber)
+	if err != nil {
+		return 0, err
+	}
+
+	segments := make([]conversion.Segment, 0, len(table))
+	for _, row := range table {
+		segments = append(segments, conversion.Segment{
+			Min:       row.InH2OMin,
+			Max:       row.InH2OMax,
+			Slope:     row.Slope,
+			Intercept: row.Intercept,
+		})
+	}
+
+	t, err := conversion.New(segments, conversion.Options{
+		Clamp:       s.cfg.Conversion.Clamp,
+		Interpolate: s.cfg.Conversion.Interpolate,
+		Tolerance:   s.cfg.Conversion.Tolerance,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Convert(level)
+}
+
+// isConversionError reports whether err comes from the table itself rather
+// than from fetching it, in which case the raw reading is still worth storing.
+func isConversionError(err error) bool {
+	return errors.Is(err, conversion.ErrInvalidTable) || errors.Is(err, conversion.ErrOutOfRange)
+}
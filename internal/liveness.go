@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	livenessLastSeenKey   = "liveness:last_seen"
+	livenessDeviceTypeKey = "liveness:device_type"
+	livenessOfflineKey    = "liveness:offline"
+	deviceStatusChannel   = "device:status"
+)
+
+type DeviceStatusEvent struct {
+	Type             string    `json:"type"`
+	SerialNumber     string    `json:"serial_number"`
+	DeviceType       string    `json:"device_type"`
+	LastSeen         time.Time `json:"last_seen"`
+	ExpectedInterval string    `json:"expected_interval"`
+	Time             time.Time `json:"time"`
+}
+
+// touchDevice records that a device reported and clears its offline state.
+func (s *Service) touchDevice(serialNumber, deviceType string) {
+	now := time.Now()
+	rdb := s.redisClient.Rdb
+
+	pipe := rdb.TxPipeline()
+	pipe.ZAdd(s.ctx, livenessLastSeenKey, redis.Z{Score: float64(now.Unix()), Member: serialNumber})
+	pipe.HSet(s.ctx, livenessDeviceTypeKey, serialNumber, deviceType)
+	removed := pipe.SRem(s.ctx, livenessOfflineKey, serialNumber)
+	if _, err := pipe.Exec(s.ctx); err != nil {
+		log.Printf("Error recording liveness for device %s: %v", serialNumber, err)
+		return
+	}
+
+	if removed.Val() > 0 {
+		s.publishDeviceStatus(DeviceStatusEvent{
+			Type:             "device_online",
+			SerialNumber:     serialNumber,
+			DeviceType:       deviceType,
+			LastSeen:         now,
+			ExpectedInterval: s.expectedInterval(deviceType).String(),
+			Time:             now,
+		})
+	}
+}
+
+func (s *Service) expectedInterval(deviceType string) time.Duration {
+	if interval, ok := s.cfg.Liveness.Intervals[deviceType]; ok {
+		return interval
+	}
+	return s.cfg.Liveness.DefaultInterval
+}
+
+func (s *Service) startLivenessScanner() {
+	go func() {
+		ticker := time.NewTicker(s.cfg.Liveness.ScanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.scanLiveness(); err != nil {
+					log.Printf("Error scanning device liveness: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// scanLiveness raises device_offline for devices that missed their expected
+// reporting interval. Every instance scans, the offline set makes sure only
+// the first one to notice publishes the event.
+func (s *Service) scanLiveness() error {
+	now := time.Now()
+	shortest := s.cfg.Liveness.DefaultInterval
+	for _, interval := range s.cfg.Liveness.Intervals {
+		if interval < shortest {
+			shortest = interval
+		}
+	}
+	cutoff := now.Add(-shortest * time.Duration(s.cfg.Liveness.MissedIntervals))
+
+	rdb := s.redisClient.Rdb
+	candidates, err := rdb.ZRangeByScoreWithScores(s.ctx, livenessLastSeenKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(cutoff.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("error reading last seen times: %w", err)
+	}
+
+	for _, z := range candidates {
+		serialNumber := z.Member.(string)
+		lastSeen := time.Unix(int64(z.Score), 0)
+
+		deviceType, err := rdb.HGet(s.ctx, livenessDeviceTypeKey, serialNumber).Result()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("error reading device type of %s: %w", serialNumber, err)
+		}
+
+		interval := s.expectedInterval(deviceType)
+		if now.Sub(lastSeen) < interval*time.Duration(s.cfg.Liveness.MissedIntervals) {
+			continue
+		}
+
+		added, err := rdb.SAdd(s.ctx, livenessOfflineKey, serialNumber).Result()
+		if err != nil {
+			return fmt.Errorf("error marking %s offline: %w", serialNumber, err)
+		}
+		if added == 0 {
+			continue
+		}
+
+		log.Printf("Device %s is offline, last seen %v", serialNumber, lastSeen)
+		s.publishDeviceStatus(DeviceStatusEvent{
+			Type:             "device_offline",
+			SerialNumber:     serialNumber,
+			DeviceType:       deviceType,
+			LastSeen:         lastSeen,
+			ExpectedInterval: interval.String(),
+			Time:             now,
+		})
+	}
+	return nil
+}
+
+func (s *Service) publishDeviceStatus(event DeviceStatusEvent) {
+	if err := s.publishEvent(deviceStatusChannel, event); err != nil {
+		log.Printf("Error publishing %s for device %s: %v", event.Type, event.SerialNumber, err)
+	}
+	if err := s.publishMQTT(fmt.Sprintf("JI/v2/%s/status", event.SerialNumber), 1, event); err != nil {
+		log.Printf("Error publishing %s for device %s: %v", event.Type, event.SerialNumber, err)
+	}
+}
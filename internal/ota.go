@@ -0,0 +1,696 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	nanoid "github.com/matoous/go-nanoid/v2"
+)
+
+const otaEvaluatorLock = "ota:evaluator"
+
+type FirmwareArtifact struct {
+	ID        string    `json:"id"`
+	Model     string    `json:"model"`
+	HWVer     string    `json:"hw_ver"`
+	Version   string    `json:"version"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OTACampaign struct {
+	ID               string         `json:"id"`
+	ArtifactID       string         `json:"artifact_id"`
+	Stages           []int          `json:"stages"`
+	CurrentStage     int            `json:"current_stage"`
+	FailureThreshold float64        `json:"failure_threshold"`
+	Status           string         `json:"status"`
+	HaltReason       string         `json:"halt_reason,omitempty"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	Devices          map[string]int `json:"devices,omitempty"`
+}
+
+// OTACommand is published on JI/v2/<sn>/ota.
+type OTACommand struct {
+	CampaignID string `json:"campaign_id"`
+	ArtifactID string `json:"artifact_id"`
+	Version    string `json:"version"`
+	URL        string `json:"url"`
+	SHA256     string `json:"sha256"`
+	Size       int64  `json:"size"`
+}
+
+// OTAAck is published by devices on JI/v2/<sn>/ota-ack.
+type OTAAck struct {
+	CampaignID string `json:"campaign_id"`
+	Status     string `json:"status"`
+	Progress   int    `json:"progress"`
+	Detail     string `json:"detail"`
+}
+
+var otaAckStatuses = map[string]bool{
+	"downloading": true,
+	"installing":  true,
+	"success":     true,
+	"failed":      true,
+}
+
+// assignStages spreads devices over the rollout stages. Devices are ordered
+// by a hash of campaign and serial number so the split is stable but not
+// biased towards any serial number range.
+func assignStages(campaignID string, serialNumbers []string, stages []int) map[string]int {
+	type ranked struct {
+		serial string
+		rank   uint64
+	}
+	devices := make([]ranked, 0, len(serialNumbers))
+	for _, sn := range serialNumbers {
+		h := fnv.New64a()
+		h.Write([]byte(campaignID + "/" + sn))
+		devices = append(devices, ranked{sn, h.Sum64()})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].rank < devices[j].rank })
+
+	assigned := make(map[string]int, len(devices))
+	for i, d := range devices {
+		share := float64(i+1) * 100 / float64(len(devices))
+		stage := len(stages) - 1
+		for idx, pct := range stages {
+			if share <= float64(pct) {
+				stage = idx
+				break
+			}
+		}
+		assigned[d.serial] = stage
+	}
+	return assigned
+}
+
+func (s *Service) handleFirmware(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listFirmware(w, r)
+	case http.MethodPost:
+		s.uploadFirmware(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Service) uploadFirmware(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.OTA.MaxArtifactSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid multipart form")
+		return
+	}
+
+	artifact := FirmwareArtifact{
+		Model:   r.FormValue("model"),
+		HWVer:   r.FormValue("hw_ver"),
+		Version: r.FormValue("version"),
+	}
+	if artifact.Model == "" || artifact.Version == "" {
+		writeError(w, http.StatusBadRequest, "model and version are required")
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "file is required")
+		return
+	}
+	defer file.Close()
+
+	artifact.ID, err = nanoid.New()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to generate artifact id")
+		return
+	}
+	artifact.Filename = filepath.Base(header.Filename)
+
+	if err := os.MkdirAll(s.cfg.OTA.ArtifactDir, 0o755); err != nil {
+		log.Printf("Error creating firmware directory: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to store firmware")
+		return
+	}
+	path := filepath.Join(s.cfg.OTA.ArtifactDir, artifact.ID+".bin")
+	out, err := os.Create(path)
+	if err != nil {
+		log.Printf("Error creating firmware file: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to store firmware")
+		return
+	}
+
+	hash := sha256.New()
+	artifact.Size, err = io.Copy(io.MultiWriter(out, hash), file)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		log.Printf("Error writing firmware file: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to store firmware")
+		return
+	}
+	artifact.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	artifact.CreatedAt = time.Now()
+
+	_, err = s.timescaleClient.DB.ExecContext(r.Context(), `
+		INSERT INTO firmware_artifact (id, model, hw_ver, version, filename, size, sha256, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, artifact.ID, artifact.Model, artifact.HWVer, artifact.Version, artifact.Filename, artifact.Size, artifact.SHA256, artifact.CreatedAt)
+	if err != nil {
+		os.Remove(path)
+		log.Printf("Error registering firmware artifact: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to register firmware")
+		return
+	}
+
+	log.Printf("Registered firmware %s %s for model %s (%d bytes, sha256 %s)", artifact.ID, artifact.Version, artifact.Model, artifact.Size, artifact.SHA256)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"status": "success", "data": artifact})
+}
+
+func (s *Service) listFirmware(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.timescaleClient.DB.QueryContext(r.Context(), `
+		SELECT id, model, hw_ver, version, filename, size, sha256, created_at
+		FROM firmware_artifact
+		WHERE ($1 = '' OR model = $1)
+		ORDER BY created_at DESC
+	`, r.URL.Query().Get("model"))
+	if err != nil {
+		log.Printf("Error querying firmware artifacts: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to query firmware")
+		return
+	}
+	defer rows.Close()
+
+	artifacts := []FirmwareArtifact{}
+	for rows.Next() {
+		var a FirmwareArtifact
+		if err := rows.Scan(&a.ID, &a.Model, &a.HWVer, &a.Version, &a.Filename, &a.Size, &a.SHA256, &a.CreatedAt); err != nil {
+			log.Printf("Error scanning firmware artifact: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to query firmware")
+			return
+		}
+		artifacts = append(artifacts, a)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "success", "data": artifacts})
+}
+
+// handleFirmwareDownload serves artifact files to devices. Artifact ids are
+// random and devices verify the checksum from the OTA command, so the
+// download does not require an API key.
+func (s *Service) handleFirmwareDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/ota/firmware/download/")
+	if id == "" || strings.ContainsAny(id, "/\\.") {
+		writeError(w, http.StatusNotFound, "firmware not found")
+		return
+	}
+
+	path := filepath.Join(s.cfg.OTA.ArtifactDir, id+".bin")
+	if _, err := os.Stat(path); err != nil {
+		writeError(w, http.StatusNotFound, "firmware not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeFile(w, r, path)
+}
+
+func (s *Service) getArtifact(id string) (*FirmwareArtifact, error) {
+	var a FirmwareArtifact
+	err := s.timescaleClient.DB.QueryRowContext(s.ctx, `
+		SELECT id, model, hw_ver, version, filename, size, sha256, created_at
+		FROM firmware_artifact WHERE id = $1
+	`, id).Scan(&a.ID, &a.Model, &a.HWVer, &a.Version, &a.Filename, &a.Size, &a.SHA256, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *Service) handleCampaigns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listCampaigns(w, r)
+	case http.MethodPost:
+		s.createCampaign(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Service) createCampaign(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ArtifactID       string  `json:"artifact_id"`
+		Stages           []int   `json:"stages"`
+		FailureThreshold float64 `json:"failure_threshold"`
+		AllowDowngrade   bool    `json:"allow_downgrade"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if len(req.Stages) == 0 {
+		req.Stages = []int{5, 25, 100}
+	}
+	for i, pct := range req.Stages {
+		if pct <= 0 || pct > 100 || (i > 0 && pct <= req.Stages[i-1]) {
+			writeError(w, http.StatusBadRequest, "stages must be increasing percentages between 1 and 100")
+			return
+		}
+	}
+	if req.Stages[len(req.Stages)-1] != 100 {
+		req.Stages = append(req.Stages, 100)
+	}
+	if req.FailureThreshold <= 0 || req.FailureThreshold > 1 {
+		req.FailureThreshold = s.cfg.OTA.FailureThreshold
+	}
+
+	artifact, err := s.getArtifact(req.ArtifactID)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusBadRequest, "unknown artifact_id")
+		return
+	} else if err != nil {
+		log.Printf("Error getting firmware artifact: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to create campaign")
+		return
+	}
+
+	// Devices already running a newer firmware are only targeted when the
+	// campaign explicitly allows downgrades.
+	rows, err := s.timescaleClient.DB.QueryContext(r.Context(), `
+		SELECT serial_number, fw_ver FROM device_inventory
+		WHERE model = $1 AND ($2 = '' OR hw_ver = $2) AND fw_ver <> $3
+	`, artifact.Model, artifact.HWVer, artifact.Version)
+	if err != nil {
+		log.Printf("Error selecting campaign targets: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to create campaign")
+		return
+	}
+	var targets []string
+	for rows.Next() {
+		var sn, fwVer string
+		if err := rows.Scan(&sn, &fwVer); err != nil {
+			continue
+		}
+		if req.AllowDowngrade || compareVersions(artifact.Version, fwVer) > 0 {
+			targets = append(targets, sn)
+		}
+	}
+	rows.Close()
+
+	if len(targets) == 0 {
+		writeError(w, http.StatusBadRequest, "no devices need this firmware")
+		return
+	}
+
+	campaign := OTACampaign{
+		ArtifactID:       artifact.ID,
+		Stages:           req.Stages,
+		FailureThreshold: req.FailureThreshold,
+		Status:           "draft",
+		CreatedAt:        time.Now(),
+	}
+	campaign.UpdatedAt = campaign.CreatedAt
+	if campaign.ID, err = nanoid.New(); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to generate campaign id")
+		return
+	}
+	stagesJSON, _ := json.Marshal(campaign.Stages)
+
+	tx, err := s.timescaleClient.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting campaign transaction: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to create campaign")
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(r.Context(), `
+		INSERT INTO ota_campaign (id, artifact_id, stages, current_stage, failure_threshold, status, created_at, updated_at)
+		VALUES ($1, $2, $3, 0, $4, $5, $6, $6)
+	`, campaign.ID, campaign.ArtifactID, string(stagesJSON), campaign.FailureThreshold, campaign.Status, campaign.CreatedAt)
+	if err != nil {
+		log.Printf("Error inserting campaign: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to create campaign")
+		return
+	}
+
+	campaign.Devices = make(map[string]int)
+	for sn, stage := range assignStages(campaign.ID, targets, campaign.Stages) {
+		_, err = tx.ExecContext(r.Context(), `
+			INSERT INTO ota_campaign_device (campaign_id, serial_number, stage, status, updated_at)
+			VALUES ($1, $2, $3, 'pending', $4)
+		`, campaign.ID, sn, stage, campaign.CreatedAt)
+		if err != nil {
+			log.Printf("Error inserting campaign device: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to create campaign")
+			return
+		}
+		campaign.Devices[fmt.Sprintf("stage_%d", stage)]++
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing campaign: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to create campaign")
+		return
+	}
+
+	log.Printf("Created OTA campaign %s for firmware %s with %d devices", campaign.ID, artifact.Version, len(targets))
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"status": "success", "data": campaign})
+}
+
+func (s *Service) listCampaigns(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.timescaleClient.DB.QueryContext(r.Context(), `
+		SELECT c.id, c.artifact_id, c.stages, c.current_stage, c.failure_threshold, c.status,
+			COALESCE(c.halt_reason, ''), c.created_at, c.updated_at,
+			COALESCE(json_object_agg(d.status, d.count) FILTER (WHERE d.status IS NOT NULL), '{}')
+		FROM ota_campaign c
+		LEFT JOIN (
+			SELECT campaign_id, status, COUNT(*) AS count FROM ota_campaign_device GROUP BY campaign_id, status
+		) d ON d.campaign_id = c.id
+		WHERE ($1 = '' OR c.id = $1)
+		GROUP BY c.id
+		ORDER BY c.created_at DESC
+	`, r.URL.Query().Get("id"))
+	if err != nil {
+		log.Printf("Error querying campaigns: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to query campaigns")
+		return
+	}
+	defer rows.Close()
+
+	campaigns := []OTACampaign{}
+	for rows.Next() {
+		var c OTACampaign
+		var stages, devices []byte
+		if err := rows.Scan(&c.ID, &c.ArtifactID, &stages, &c.CurrentStage, &c.FailureThreshold, &c.Status, &c.HaltReason, &c.CreatedAt, &c.UpdatedAt, &devices); err != nil {
+			log.Printf("Error scanning campaign: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to query campaigns")
+			return
+		}
+		json.Unmarshal(stages, &c.Stages)
+		json.Unmarshal(devices, &c.Devices)
+		campaigns = append(campaigns, c)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "success", "data": campaigns})
+}
+
+// handleCampaignAction serves POST /ota/campaigns/<id>/start and /halt.
+func (s *Service) handleCampaignAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/ota/campaigns/"), "/"), "/")
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	id, action := parts[0], parts[1]
+
+	var err error
+	switch action {
+	case "start":
+		err = s.setCampaignStatus(id, "running", "", "draft", "halted")
+		if err == nil {
+			err = s.dispatchCampaign(id)
+		}
+	case "halt":
+		err = s.setCampaignStatus(id, "halted", "halted by operator", "draft", "running")
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusConflict, "campaign not found or not in a state that allows "+action)
+		return
+	} else if err != nil {
+		log.Printf("Error running %s on campaign %s: %v", action, id, err)
+		writeError(w, http.StatusInternalServerError, "failed to "+action+" campaign")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success", "id": id, "action": action})
+}
+
+// setCampaignStatus moves a campaign to status if it is in one of from.
+func (s *Service) setCampaignStatus(id, status, reason string, from ...string) error {
+	fromJSON, _ := json.Marshal(from)
+	result, err := s.timescaleClient.DB.ExecContext(s.ctx, `
+		UPDATE ota_campaign SET status = $2, halt_reason = NULLIF($3, ''), updated_at = NOW()
+		WHERE id = $1 AND status IN (SELECT jsonb_array_elements_text($4::jsonb))
+	`, id, status, reason, string(fromJSON))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	log.Printf("OTA campaign %s is now %s %s", id, status, reason)
+	return nil
+}
+
+// dispatchCampaign sends the update command to pending devices of every
+// stage up to the campaign's current one. Devices whose command couldn't be
+// published stay pending and are retried with backoff.
+func (s *Service) dispatchCampaign(id string) error {
+	var artifactID string
+	var currentStage int
+	err := s.timescaleClient.DB.QueryRowContext(s.ctx, `
+		SELECT artifact_id, current_stage FROM ota_campaign WHERE id = $1 AND status = 'running'
+	`, id).Scan(&artifactID, &currentStage)
+	if err != nil {
+		return err
+	}
+
+	artifact, err := s.getArtifact(artifactID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.timescaleClient.DB.QueryContext(s.ctx, `
+		SELECT serial_number, attempts FROM ota_campaign_device
+		WHERE campaign_id = $1 AND stage <= $2 AND status = 'pending'
+		AND (next_attempt_at IS NULL OR next_attempt_at <= NOW())
+	`, id, currentStage)
+	if err != nil {
+		return err
+	}
+	var serialNumbers []string
+	attempts := make(map[string]int)
+	for rows.Next() {
+		var sn string
+		var n int
+		if err := rows.Scan(&sn, &n); err == nil {
+			serialNumbers = append(serialNumbers, sn)
+			attempts[sn] = n
+		}
+	}
+	rows.Close()
+
+	command := OTACommand{
+		CampaignID: id,
+		ArtifactID: artifact.ID,
+		Version:    artifact.Version,
+		URL:        strings.TrimSuffix(s.cfg.OTA.BaseURL, "/") + "/ota/firmware/download/" + artifact.ID,
+		SHA256:     artifact.SHA256,
+		Size:       artifact.Size,
+	}
+	for _, sn := range serialNumbers {
+		if err := s.publishMQTT(fmt.Sprintf("JI/v2/%s/ota", sn), 1, command); err != nil {
+			log.Printf("Error sending OTA command to device %s: %v", sn, err)
+			backoff := retryBackoff(attempts[sn]+1, s.cfg.OTA.MaxRetryBackoff)
+			_, err := s.timescaleClient.DB.ExecContext(s.ctx, `
+				UPDATE ota_campaign_device SET attempts = attempts + 1, next_attempt_at = $3, updated_at = NOW()
+				WHERE campaign_id = $1 AND serial_number = $2
+			`, id, sn, time.Now().Add(backoff))
+			if err != nil {
+				log.Printf("Error scheduling OTA command retry for device %s: %v", sn, err)
+			}
+			continue
+		}
+		_, err := s.timescaleClient.DB.ExecContext(s.ctx, `
+			UPDATE ota_campaign_device SET status = 'sent', sent_at = NOW(), updated_at = NOW()
+			WHERE campaign_id = $1 AND serial_number = $2
+		`, id, sn)
+		if err != nil {
+			log.Printf("Error marking OTA command sent for device %s: %v", sn, err)
+		}
+	}
+
+	log.Printf("Dispatched OTA campaign %s stage %d to %d devices", id, currentStage, len(serialNumbers))
+	return nil
+}
+
+// HandleOTAAck records progress reported by a device for a campaign.
+func (s *Service) HandleOTAAck(topic string, payload []byte) {
+	serialNumber, err := extractSerialNumberFromTopic(topic)
+	if err != nil {
+		log.Printf("Error extracting serial number: %v", err)
+		return
+	}
+
+	var ack OTAAck
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		log.Printf("Error parsing OTA ack from device %s: %v", serialNumber, err)
+		return
+	}
+	if !otaAckStatuses[ack.Status] {
+		log.Printf("Unknown OTA ack status %q from device %s", ack.Status, serialNumber)
+		return
+	}
+
+	_, err = s.timescaleClient.DB.ExecContext(s.ctx, `
+		UPDATE ota_campaign_device SET status = $3, progress = $4, detail = $5, updated_at = NOW()
+		WHERE campaign_id = $1 AND serial_number = $2 AND status NOT IN ('verified', 'failed')
+	`, ack.CampaignID, serialNumber, ack.Status, ack.Progress, ack.Detail)
+	if err != nil {
+		log.Printf("Error recording OTA ack from device %s: %v", serialNumber, err)
+		return
+	}
+	log.Printf("OTA campaign %s device %s reported %s (%d%%)", ack.CampaignID, serialNumber, ack.Status, ack.Progress)
+}
+
+func (s *Service) startOTAEvaluator() {
+	go func() {
+		ticker := time.NewTicker(s.cfg.OTA.EvaluateInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				locked, err := s.redisClient.Rdb.SetNX(s.ctx, otaEvaluatorLock, 1, s.cfg.OTA.EvaluateInterval/2).Result()
+				if err != nil || !locked {
+					continue
+				}
+				if err := s.evaluateCampaigns(); err != nil {
+					log.Printf("Error evaluating OTA campaigns: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// evaluateCampaigns verifies updated devices against their reported fwVer,
+// times out silent devices of running campaigns, halts campaigns above their
+// failure threshold, resends commands that couldn't be published and
+// advances campaigns whose current stage has finished.
+func (s *Service) evaluateCampaigns() error {
+	db := s.timescaleClient.DB
+
+	_, err := db.ExecContext(s.ctx, `
+		UPDATE ota_campaign_device d SET status = 'verified', progress = 100, updated_at = NOW()
+		FROM ota_campaign c, firmware_artifact a, device_inventory i
+		WHERE d.campaign_id = c.id AND c.artifact_id = a.id AND i.serial_number = d.serial_number
+		AND i.fw_ver = a.version AND d.status IN ('sent', 'downloading', 'installing', 'success')
+	`)
+	if err != nil {
+		return fmt.Errorf("error verifying firmware versions: %w", err)
+	}
+
+	_, err = db.ExecContext(s.ctx, `
+		UPDATE ota_campaign_device d SET status = 'failed', detail = 'timed out', updated_at = NOW()
+		FROM ota_campaign c
+		WHERE d.campaign_id = c.id AND c.status = 'running'
+		AND d.status IN ('sent', 'downloading', 'installing', 'success') AND d.sent_at < $1
+	`, time.Now().Add(-s.cfg.OTA.DeviceTimeout))
+	if err != nil {
+		return fmt.Errorf("error timing out OTA devices: %w", err)
+	}
+
+	rows, err := db.QueryContext(s.ctx, `
+		SELECT c.id, c.stages, c.current_stage, c.failure_threshold,
+			COUNT(*) FILTER (WHERE d.stage <= c.current_stage),
+			COUNT(*) FILTER (WHERE d.stage <= c.current_stage AND d.status = 'failed'),
+			COUNT(*) FILTER (WHERE d.stage <= c.current_stage AND d.status IN ('verified', 'failed')),
+			COUNT(*) FILTER (WHERE d.stage <= c.current_stage AND d.status = 'pending'
+				AND (d.next_attempt_at IS NULL OR d.next_attempt_at <= NOW()))
+		FROM ota_campaign c JOIN ota_campaign_device d ON d.campaign_id = c.id
+		WHERE c.status = 'running'
+		GROUP BY c.id
+	`)
+	if err != nil {
+		return fmt.Errorf("error reading running campaigns: %w", err)
+	}
+
+	type progress struct {
+		id                        string
+		stages                    []int
+		stage                     int
+		threshold                 float64
+		dispatched, failed, final int
+		// due counts pending devices whose command is due to be sent again.
+		due int
+	}
+	var campaigns []progress
+	for rows.Next() {
+		var p progress
+		var stages []byte
+		if err := rows.Scan(&p.id, &stages, &p.stage, &p.threshold, &p.dispatched, &p.failed, &p.final, &p.due); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning campaign progress: %w", err)
+		}
+		json.Unmarshal(stages, &p.stages)
+		campaigns = append(campaigns, p)
+	}
+	rows.Close()
+
+	for _, p := range campaigns {
+		if p.dispatched > 0 && float64(p.failed)/float64(p.dispatched) > p.threshold {
+			reason := fmt.Sprintf("failure rate %d/%d above %.0f%%", p.failed, p.dispatched, p.threshold*100)
+			if err := s.setCampaignStatus(p.id, "halted", reason, "running"); err != nil {
+				log.Printf("Error halting campaign %s: %v", p.id, err)
+			}
+			continue
+		}
+		if p.final < p.dispatched {
+			if p.due > 0 {
+				if err := s.dispatchCampaign(p.id); err != nil {
+					log.Printf("Error dispatching campaign %s: %v", p.id, err)
+				}
+			}
+			continue
+		}
+
+		if p.stage >= len(p.stages)-1 {
+			if err := s.setCampaignStatus(p.id, "completed", "", "running"); err != nil {
+				log.Printf("Error completing campaign %s: %v", p.id, err)
+			}
+			continue
+		}
+
+		_, err := db.ExecContext(s.ctx, `UPDATE ota_campaign SET current_stage = current_stage + 1, updated_at = NOW() WHERE id = $1`, p.id)
+		if err != nil {
+			log.Printf("Error advancing campaign %s: %v", p.id, err)
+			continue
+		}
+		log.Printf("OTA campaign %s advancing to stage %d (%d%%)", p.id, p.stage+1, p.stages[p.stage+1])
+		if err := s.dispatchCampaign(p.id); err != nil {
+			log.Printf("Error dispatching campaign %s: %v", p.id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"medical-gas-transport-service/internal/export"
+)
+
+const (
+	outboxTargetRedis  = "redis"
+	outboxTargetStream = "stream"
+	outboxTargetMQTT   = "mqtt"
+	outboxTargetExport = "export"
+)
+
+// outboxMessage is an event to publish once the row it announces is stored.
+// ID and CreatedAt are set when the message is written to the outbox.
+type outboxMessage struct {
+	ID          int64
+	CreatedAt   time.Time
+	Target      string
+	Destination string
+	QoS         byte
+	Payload     []byte
+}
+
+// eventMessages returns the outbox messages announcing an event on a Redis
+// channel: one for pub/sub, one for the Redis stream when streams are
+// enabled and, when the channel is exported, one for the export sink. Each
+// is delivered and retried on its own.
+func (s *Service) eventMessages(channel string, payload []byte) []outboxMessage {
+	msgs := []outboxMessage{{Target: outboxTargetRedis, Destination: channel, Payload: payload}}
+	if s.cfg.Events.Streams {
+		msgs = append(msgs, outboxMessage{Target: outboxTargetStream, Destination: channel, Payload: payload})
+	}
+	if s.exporter != nil && s.exporter.Exports(channel) {
+		msgs = append(msgs, outboxMessage{Target: outboxTargetExport, Destination: channel, Payload: payload})
+	}
+	return msgs
+}
+
+// writeWithOutbox runs query and records msgs in event_outbox in the same
+// transaction, then tries to deliver them right away. Messages that can't be
+// delivered now, and export messages, are left to the outbox relay. It returns false when the query
+// affected no rows, which for ON CONFLICT DO NOTHING inserts means the row
+// already existed; nothing is recorded in that case.
+func (s *Service) writeWithOutbox(msgs []outboxMessage, query string, args ...interface{}) (bool, error) {
+	return s.writeWithOutboxTx(func(ctx context.Context, tx *sql.Tx) ([]outboxMessage, bool, error) {
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return nil, false, fmt.Errorf("database error: %w", err)
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return nil, false, nil
+		}
+		return msgs, true, nil
+	})
+}
+
+// writeWithOutboxTx is writeWithOutbox for writes that need more than one
+// statement: write runs in the transaction and returns the messages
+// announcing what it stored, or false when it stored nothing.
+func (s *Service) writeWithOutboxTx(write func(ctx context.Context, tx *sql.Tx) ([]outboxMessage, bool, error)) (bool, error) {
+	var msgs []outboxMessage
+	err := s.inTransaction(10*time.Second, func(ctx context.Context, tx *sql.Tx) error {
+		written, ok, err := write(ctx, tx)
+		if err != nil || !ok {
+			return err
+		}
+		if err := s.insertOutbox(ctx, tx, written); err != nil {
+			return err
+		}
+		msgs = written
+		return nil
+	})
+	if err != nil || msgs == nil {
+		return false, err
+	}
+
+	s.deliverOutbox(msgs)
+	return true, nil
+}
+
+// enqueueOutbox records msgs on their own and tries to deliver them right
+// away, for events that don't announce a row written by the caller.
+func (s *Service) enqueueOutbox(msgs []outboxMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := s.timescaleClient.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.insertOutbox(ctx, tx, msgs); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	s.deliverOutbox(msgs)
+	return nil
+}
+
+// insertOutbox writes msgs and sets their IDs. The relay leaves fresh
+// entries alone for a moment so it doesn't race the immediate delivery;
+// export entries are never delivered immediately and are due right away.
+func (s *Service) insertOutbox(ctx context.Context, tx *sql.Tx, msgs []outboxMessage) error {
+	for i := range msgs {
+		grace := fmt.Sprintf("%d milliseconds", s.cfg.Outbox.Grace.Milliseconds())
+		if msgs[i].Target == outboxTargetExport {
+			grace = "0 milliseconds"
+		}
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO event_outbox (target, destination, qos, payload, next_attempt_at)
+			VALUES ($1, $2, $3, $4, now() + $5::interval)
+			RETURNING id, created_at
+		`, msgs[i].Target, msgs[i].Destination, msgs[i].QoS, msgs[i].Payload, grace).Scan(&msgs[i].ID, &msgs[i].CreatedAt)
+		if err != nil {
+			return fmt.Errorf("error writing outbox entry: %w", err)
+		}
+	}
+	return nil
+}
+
+// deliverOutbox delivers freshly written outbox messages. Export messages
+// are left to the relay: a slow Kafka or NATS broker would otherwise hold up
+// the MQTT worker that stored the reading.
+func (s *Service) deliverOutbox(msgs []outboxMessage) {
+	for _, msg := range msgs {
+		if msg.Target == outboxTargetExport {
+			continue
+		}
+		if err := s.deliverOutboxMessage(msg); err != nil {
+			log.Printf("Error delivering outbox entry %d to %s, leaving it to the relay: %v", msg.ID, msg.Target, err)
+			continue
+		}
+		if err := s.writeToTimescaleDBWithRetry(`UPDATE event_outbox SET delivered_at = now(), attempts = attempts + 1 WHERE id = $1`, msg.ID); err != nil {
+			log.Printf("Error marking outbox entry %d delivered: %v", msg.ID, err)
+		}
+	}
+}
+
+func (s *Service) deliverOutboxMessage(msg outboxMessage) error {
+	switch msg.Target {
+	case outboxTargetRedis:
+		return s.publishPubSub(msg.Destination, msg.Payload)
+	case outboxTargetStream:
+		// The outbox entry ID is the event ID, so a redelivered entry can be
+		// recognized by stream consumers.
+		return s.appendEventStream(msg.Destination, strconv.FormatInt(msg.ID, 10), msg.Payload)
+	case outboxTargetMQTT:
+		return s.publishMQTT(msg.Destination, msg.QoS, json.RawMessage(msg.Payload))
+	case outboxTargetExport:
+		if s.exporter == nil {
+			return fmt.Errorf("event export is not configured")
+		}
+		var event struct {
+			SerialNumber string `json:"serial_number"`
+		}
+		if err := json.Unmarshal(msg.Payload, &event); err != nil {
+			return fmt.Errorf("error reading serial number of %s event: %w", msg.Destination, err)
+		}
+		ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+		defer cancel()
+		return s.exporter.Export(ctx, export.Event{
+			ID:            strconv.FormatInt(msg.ID, 10),
+			Channel:       msg.Destination,
+			SerialNumber:  event.SerialNumber,
+			SchemaVersion: eventSchemaVersion,
+			Time:          msg.CreatedAt,
+			Payload:       msg.Payload,
+		})
+	}
+	return fmt.Errorf("unknown outbox target %q", msg.Target)
+}
+
+// startOutboxRelay periodically publishes outbox entries that weren't
+// delivered when they were written, retrying with exponential backoff, and
+// removes delivered entries after the retention period.
+func (s *Service) startOutboxRelay() {
+	go func() {
+		ticker := time.NewTicker(s.cfg.Outbox.RelayInterval)
+		defer ticker.Stop()
+		cleanup := time.NewTicker(time.Hour)
+		defer cleanup.Stop()
+
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				for {
+					n, err := s.relayOutbox()
+					if err != nil {
+						log.Printf("Error relaying outbox: %v", err)
+					}
+					if err != nil || n < s.cfg.Outbox.BatchSize {
+						break
+					}
+				}
+			case <-cleanup.C:
+				err := s.writeToTimescaleDBWithRetry(
+					`DELETE FROM event_outbox WHERE delivered_at < now() - $1::interval`,
+					fmt.Sprintf("%d seconds", int64(s.cfg.Outbox.Retention.Seconds())),
+				)
+				if err != nil {
+					log.Printf("Error cleaning up outbox: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// outboxLease is how long the relay holds claimed entries. Entries it
+// couldn't get to in time are claimed again once the lease runs out.
+const outboxLease = time.Minute
+
+// relayOutbox delivers one batch of due outbox entries. The batch is claimed
+// by pushing next_attempt_at past the lease in one statement, with SKIP
+// LOCKED so several instances can relay concurrently, and delivered outside
+// any transaction. Each result is recorded on its own, so a slow or failing
+// target neither loses the backoff of failed entries nor redelivers the
+// ones that went out.
+func (s *Service) relayOutbox() (int, error) {
+	entries, err := s.claimOutbox()
+	if err != nil {
+		return 0, err
+	}
+
+	deadline := time.Now().Add(outboxLease - 15*time.Second)
+	relayed := 0
+	for _, e := range entries {
+		if time.Now().After(deadline) {
+			log.Printf("Outbox lease running out, leaving %d entries for the next relay", len(entries)-relayed)
+			break
+		}
+		relayed++
+		if err := s.deliverOutboxMessage(e.msg); err != nil {
+			backoff := retryBackoff(e.attempts+1, s.cfg.Outbox.MaxBackoff)
+			log.Printf("Error relaying outbox entry %d to %s (attempt %d), retrying in %v: %v", e.msg.ID, e.msg.Target, e.attempts+1, backoff, err)
+			err = s.writeToTimescaleDBWithRetry(`
+				UPDATE event_outbox SET attempts = attempts + 1, last_error = $2, next_attempt_at = now() + $3::interval
+				WHERE id = $1
+			`, e.msg.ID, err.Error(), fmt.Sprintf("%d milliseconds", backoff.Milliseconds()))
+		} else {
+			err = s.writeToTimescaleDBWithRetry(`UPDATE event_outbox SET attempts = attempts + 1, delivered_at = now() WHERE id = $1`, e.msg.ID)
+		}
+		if err != nil {
+			log.Printf("Error updating outbox entry %d: %v", e.msg.ID, err)
+		}
+	}
+
+	if relayed > 0 {
+		log.Printf("Relayed %d outbox entries", relayed)
+	}
+	return relayed, nil
+}
+
+type outboxEntry struct {
+	msg      outboxMessage
+	attempts int
+}
+
+// claimOutbox leases a batch of due outbox entries, oldest first.
+func (s *Service) claimOutbox() ([]outboxEntry, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+
+	rows, err := s.timescaleClient.DB.QueryContext(ctx, `
+		UPDATE event_outbox SET next_attempt_at = now() + $2::interval
+		WHERE id IN (
+			SELECT id FROM event_outbox
+			WHERE delivered_at IS NULL AND next_attempt_at <= now()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, created_at, target, destination, qos, payload, attempts
+	`, s.cfg.Outbox.BatchSize, fmt.Sprintf("%d milliseconds", outboxLease.Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("error claiming outbox entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []outboxEntry
+	for rows.Next() {
+		var e outboxEntry
+		if err := rows.Scan(&e.msg.ID, &e.msg.CreatedAt, &e.msg.Target, &e.msg.Destination, &e.msg.QoS, &e.msg.Payload, &e.attempts); err != nil {
+			return nil, fmt.Errorf("error scanning outbox entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error claiming outbox entries: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].msg.ID < entries[j].msg.ID })
+	return entries, nil
+}
@@ -0,0 +1,144 @@
+// Package payload detects how a device message is encoded and transcodes
+// compact CBOR and Protobuf payloads to the JSON the handlers decode.
+package payload
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+type Format int
+
+const (
+	JSON Format = iota
+	CBOR
+	Protobuf
+)
+
+func (f Format) String() string {
+	switch f {
+	case CBOR:
+		return "cbor"
+	case Protobuf:
+		return "protobuf"
+	}
+	return "json"
+}
+
+var ErrUnknownSchema = errors.New("no protobuf schema for payload")
+
+var topicSuffixes = map[string]Format{
+	"/cbor": CBOR,
+	"/pb":   Protobuf,
+}
+
+var contentTypes = map[string]Format{
+	"application/json":                JSON,
+	"application/cbor":                CBOR,
+	"application/protobuf":            Protobuf,
+	"application/x-protobuf":          Protobuf,
+	"application/vnd.google.protobuf": Protobuf,
+}
+
+var cborDecoder, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}{}),
+}.DecMode()
+
+var cborEncoder, _ = cbor.EncOptions{
+	ShortestFloat: cbor.ShortestFloat16,
+}.EncMode()
+
+// Detect returns the encoding of a message from its MQTT v5 content type or,
+// for clients without properties, from a /cbor or /pb topic suffix. The
+// topic is returned without the suffix.
+func Detect(topic, contentType string) (Format, string) {
+	for suffix, format := range topicSuffixes {
+		if strings.HasSuffix(topic, suffix) {
+			return format, strings.TrimSuffix(topic, suffix)
+		}
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return contentTypes[strings.TrimSpace(strings.ToLower(mediaType))], topic
+}
+
+// ToJSON transcodes a payload to JSON. kind is the last topic segment, such
+// as "level", and selects the protobuf schema.
+func ToJSON(format Format, kind string, payload []byte) ([]byte, error) {
+	switch format {
+	case CBOR:
+		var v interface{}
+		if err := cborDecoder.Unmarshal(payload, &v); err != nil {
+			return nil, fmt.Errorf("error decoding CBOR payload: %w", err)
+		}
+		return json.Marshal(v)
+	case Protobuf:
+		schema, ok := schemas[kind]
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownSchema, kind)
+		}
+		m, err := decodeMessage(schema, payload)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding protobuf payload: %w", err)
+		}
+		return json.Marshal(m)
+	}
+	return payload, nil
+}
+
+// FromJSON encodes a JSON payload in format. It is the inverse of ToJSON and
+// is meant for device simulators and firmware tooling.
+func FromJSON(format Format, kind string, payload []byte) ([]byte, error) {
+	switch format {
+	case CBOR:
+		var v interface{}
+		if err := decodeJSON(payload, &v); err != nil {
+			return nil, err
+		}
+		return cborEncoder.Marshal(compactNumbers(v))
+	case Protobuf:
+		schema, ok := schemas[kind]
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownSchema, kind)
+		}
+		var m map[string]interface{}
+		if err := decodeJSON(payload, &m); err != nil {
+			return nil, err
+		}
+		return encodeMessage(schema, m)
+	}
+	return payload, nil
+}
+
+func decodeJSON(payload []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// compactNumbers turns JSON numbers into integers where possible so CBOR
+// encodes them in their shortest form.
+func compactNumbers(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i
+		}
+		f, _ := x.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range x {
+			x[k] = compactNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range x {
+			x[i] = compactNumbers(item)
+		}
+	}
+	return v
+}
@@ -0,0 +1,183 @@
+package payload
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Readings with every field set to a non-zero value, so none of them is
+// left out by proto3 encoders.
+var readings = []struct {
+	kind string
+	json string
+}{
+	{"level", `{
+		"ts": 1700000000, "tsUnit": "s", "level": 42.5,
+		"device": {
+			"uptime": 3600, "temp": 21.5, "hum": 40.25, "long": 106.8, "lat": -6.2, "rssi": -71,
+			"hwVer": "2.1", "fwVer": "1.4.0", "rdVer": "1.0", "model": "JI-L1", "memory": 20480, "resetReason": 3
+		},
+		"power": {
+			"battStat": ["CHARGING"], "solarStat": ["OK", "MPPT"], "loadStat": ["ON"],
+			"battTemp": -4, "battLevel": 87, "battVolt": 3700, "eGen": [120, 130], "eCom": [80, 90]
+		}
+	}`},
+	{"flow", `{
+		"ts": 1700000000123, "tsUnit": "ms",
+		"device": {
+			"uptime": 7200, "temp": 25, "hum": 55.5, "long": 106.8, "lat": -6.2, "rssi": -80,
+			"hwVer": "2.0", "fwVer": "1.4.0", "rdVer": "1.0", "model": "JI-F1", "memory": 18000, "resetReason": 1
+		},
+		"vHi": 12, "vLo": 345.5, "vDec": 0.25, "fRateHi": 1.5, "fRateLo": 0.75
+	}`},
+	{"pressure", `{
+		"ts": 1700000000, "tsUnit": "s",
+		"device": {
+			"uptime": 60, "temp": 30.5, "hum": 35, "long": 106.8, "lat": -6.2, "rssi": -65,
+			"hwVer": "1.2", "fwVer": "1.3.2", "rdVer": "1.0", "model": "JI-P1", "memory": 12000, "resetReason": 2
+		},
+		"data": [
+			{"measurement": "O2", "value": 4.2, "connection": 1, "enable": true, "high_limit": 5.5, "low_limit": 3.5},
+			{"measurement": "N2O", "value": 3.9, "connection": 2, "enable": true, "high_limit": 5, "low_limit": 3}
+		]
+	}`},
+	{"filling", `{
+		"ts": 1700000000, "tsUnit": "s", "filling-state": 2, "level": 55.5, "nano_id": "V1StGXR8_Z5jdHi6B-myT"
+	}`},
+}
+
+func assertJSONEqual(t *testing.T, got, want []byte) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("decoding %s: %v", got, err)
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		t.Fatalf("decoding %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []Format{CBOR, Protobuf} {
+		for _, r := range readings {
+			t.Run(format.String()+"/"+r.kind, func(t *testing.T) {
+				encoded, err := FromJSON(format, r.kind, []byte(r.json))
+				if err != nil {
+					t.Fatalf("FromJSON: %v", err)
+				}
+				decoded, err := ToJSON(format, r.kind, encoded)
+				if err != nil {
+					t.Fatalf("ToJSON: %v", err)
+				}
+				assertJSONEqual(t, decoded, []byte(r.json))
+			})
+		}
+	}
+}
+
+func TestProtobufDefaults(t *testing.T) {
+	// A proto3 encoder sends neither zero scalars nor unset messages.
+	encoded, err := FromJSON(Protobuf, "pressure", []byte(`{
+		"ts": 1700000000, "data": [{"measurement": "O2", "enable": true}]
+	}`))
+	if err != nil {
+		t.Fatalf("FromJSON: %v", err)
+	}
+	decoded, err := ToJSON(Protobuf, "pressure", encoded)
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+	assertJSONEqual(t, decoded, []byte(`{
+		"ts": 1700000000, "tsUnit": "",
+		"data": [{"measurement": "O2", "value": 0, "connection": 0, "enable": true, "high_limit": 0, "low_limit": 0}]
+	}`))
+
+	decoded, err = ToJSON(Protobuf, "filling", nil)
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+	assertJSONEqual(t, decoded, []byte(`{"ts": 0, "tsUnit": "", "filling-state": 0, "level": 0, "nano_id": ""}`))
+}
+
+func TestUnknownSchema(t *testing.T) {
+	if _, err := ToJSON(Protobuf, "status", nil); err == nil {
+		t.Error("ToJSON accepted a kind without schema")
+	}
+	if _, err := FromJSON(Protobuf, "status", []byte(`{}`)); err == nil {
+		t.Error("FromJSON accepted a kind without schema")
+	}
+}
+
+func protoMessage(t *testing.T, file protoreflect.FileDescriptor, kind string) *dynamicpb.Message {
+	t.Helper()
+	desc := file.Messages().ByName(protoreflect.Name(messageName(kind)))
+	if desc == nil {
+		t.Fatalf("readings.proto has no message for %s", kind)
+	}
+	return dynamicpb.NewMessage(desc)
+}
+
+// TestProtobufRuntime checks the hand-written codec against messages encoded
+// and decoded by the protobuf runtime from the descriptor of readings.proto.
+func TestProtobufRuntime(t *testing.T) {
+	file := protoFile(t)
+	for _, r := range readings {
+		t.Run(r.kind, func(t *testing.T) {
+			want := protoMessage(t, file, r.kind)
+			if err := protojson.Unmarshal([]byte(r.json), want); err != nil {
+				t.Fatalf("protojson.Unmarshal: %v", err)
+			}
+
+			encoded, err := proto.Marshal(want)
+			if err != nil {
+				t.Fatalf("proto.Marshal: %v", err)
+			}
+			decoded, err := ToJSON(Protobuf, r.kind, encoded)
+			if err != nil {
+				t.Fatalf("ToJSON: %v", err)
+			}
+			assertJSONEqual(t, decoded, []byte(r.json))
+
+			encoded, err = FromJSON(Protobuf, r.kind, []byte(r.json))
+			if err != nil {
+				t.Fatalf("FromJSON: %v", err)
+			}
+			got := protoMessage(t, file, r.kind)
+			if err := proto.Unmarshal(encoded, got); err != nil {
+				t.Fatalf("proto.Unmarshal: %v", err)
+			}
+			if !proto.Equal(got, want) {
+				t.Errorf("FromJSON encoded %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		topic, contentType string
+		format             Format
+		stripped           string
+	}{
+		{"JI/v2/SN1/level", "", JSON, "JI/v2/SN1/level"},
+		{"JI/v2/SN1/level/cbor", "", CBOR, "JI/v2/SN1/level"},
+		{"JI/v2/SN1/level/pb", "", Protobuf, "JI/v2/SN1/level"},
+		{"JI/v2/SN1/level", "application/cbor", CBOR, "JI/v2/SN1/level"},
+		{"JI/v2/SN1/level", "Application/X-Protobuf; charset=binary", Protobuf, "JI/v2/SN1/level"},
+	}
+	for _, tt := range tests {
+		format, stripped := Detect(tt.topic, tt.contentType)
+		if format != tt.format || stripped != tt.stripped {
+			t.Errorf("Detect(%q, %q) = %v, %q, want %v, %q", tt.topic, tt.contentType, format, stripped, tt.format, tt.stripped)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package payload
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func lookupField(schema []field, num protowire.Number) (field, bool) {
+	for _, f := range schema {
+		if protowire.Number(f.num) == num {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+func wireType(k kind) protowire.Type {
+	switch k {
+	case kindDouble:
+		return protowire.Fixed64Type
+	case kindString, kindMessage:
+		return protowire.BytesType
+	}
+	return protowire.VarintType
+}
+
+// zeroValue is the proto3 default of a scalar field.
+func zeroValue(k kind) interface{} {
+	switch k {
+	case kindDouble:
+		return float64(0)
+	case kindBool:
+		return false
+	case kindString:
+		return ""
+	}
+	return int64(0)
+}
+
+// decodeMessage decodes a protobuf message into a map keyed by JSON names.
+// Unknown fields are skipped so devices can be upgraded before the service.
+// Proto3 encoders leave out scalars set to their default, so absent singular
+// scalars are filled in with it; absent messages and repeated fields stay
+// absent.
+func decodeMessage(schema []field, b []byte) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f, ok := lookupField(schema, num)
+		if !ok {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		// Repeated scalars are usually packed into a single bytes field.
+		if f.repeated && typ == protowire.BytesType && wireType(f.kind) != protowire.BytesType {
+			packed, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			values, _ := m[f.name].([]interface{})
+			for len(packed) > 0 {
+				v, n, err := decodeValue(f, wireType(f.kind), packed)
+				if err != nil {
+					return nil, err
+				}
+				packed = packed[n:]
+				values = append(values, v)
+			}
+			m[f.name] = values
+			continue
+		}
+
+		v, n, err := decodeValue(f, typ, b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[n:]
+		if f.repeated {
+			values, _ := m[f.name].([]interface{})
+			m[f.name] = append(values, v)
+		} else {
+			m[f.name] = v
+		}
+	}
+
+	for _, f := range schema {
+		if _, ok := m[f.name]; !ok && !f.repeated && f.kind != kindMessage {
+			m[f.name] = zeroValue(f.kind)
+		}
+	}
+	return m, nil
+}
+
+func decodeValue(f field, typ protowire.Type, b []byte) (interface{}, int, error) {
+	if typ != wireType(f.kind) {
+		return nil, 0, fmt.Errorf("field %s has wire type %d, expected %d", f.name, typ, wireType(f.kind))
+	}
+
+	switch f.kind {
+	case kindDouble:
+		v, n := protowire.ConsumeFixed64(b)
+		if n < 0 {
+			return nil, 0, protowire.ParseError(n)
+		}
+		return math.Float64frombits(v), n, nil
+	case kindString:
+		v, n := protowire.ConsumeString(b)
+		if n < 0 {
+			return nil, 0, protowire.ParseError(n)
+		}
+		return v, n, nil
+	case kindMessage:
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, 0, protowire.ParseError(n)
+		}
+		m, err := decodeMessage(f.message, v)
+		if err != nil {
+			return nil, 0, fmt.Errorf("field %s: %w", f.name, err)
+		}
+		return m, n, nil
+	}
+
+	v, n := protowire.ConsumeVarint(b)
+	if n < 0 {
+		return nil, 0, protowire.ParseError(n)
+	}
+	switch f.kind {
+	case kindSint:
+		return protowire.DecodeZigZag(v), n, nil
+	case kindBool:
+		return protowire.DecodeBool(v), n, nil
+	}
+	return int64(v), n, nil
+}
+
+// encodeMessage encodes a map decoded from JSON with UseNumber. Fields that
+// are missing or null are left out, as proto3 does for zero values.
+func encodeMessage(schema []field, m map[string]interface{}) ([]byte, error) {
+	var b []byte
+	for _, f := range schema {
+		v, ok := m[f.name]
+		if !ok || v == nil {
+			continue
+		}
+
+		if !f.repeated {
+			var err error
+			if b, err = appendValue(b, f, v); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		values, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("field %s must be an array", f.name)
+		}
+		if wireType(f.kind) == protowire.BytesType {
+			for _, item := range values {
+				var err error
+				if b, err = appendValue(b, f, item); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		var packed []byte
+		for _, item := range values {
+			var err error
+			if packed, err = appendScalar(packed, f, item); err != nil {
+				return nil, err
+			}
+		}
+		b = protowire.AppendTag(b, protowire.Number(f.num), protowire.BytesType)
+		b = protowire.AppendBytes(b, packed)
+	}
+	return b, nil
+}
+
+func appendValue(b []byte, f field, v interface{}) ([]byte, error) {
+	b = protowire.AppendTag(b, protowire.Number(f.num), wireType(f.kind))
+	switch f.kind {
+	case kindString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("field %s must be a string", f.name)
+		}
+		return protowire.AppendString(b, s), nil
+	case kindMessage:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("field %s must be an object", f.name)
+		}
+		encoded, err := encodeMessage(f.message, m)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.name, err)
+		}
+		return protowire.AppendBytes(b, encoded), nil
+	}
+	return appendScalar(b, f, v)
+}
+
+func appendScalar(b []byte, f field, v interface{}) ([]byte, error) {
+	switch f.kind {
+	case kindBool:
+		x, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("field %s must be a boolean", f.name)
+		}
+		return protowire.AppendVarint(b, protowire.EncodeBool(x)), nil
+	case kindDouble:
+		x, err := toNumber(v).Float64()
+		if err != nil {
+			return nil, fmt.Errorf("field %s must be a number", f.name)
+		}
+		return protowire.AppendFixed64(b, math.Float64bits(x)), nil
+	}
+
+	x, err := toNumber(v).Int64()
+	if err != nil {
+		return nil, fmt.Errorf("field %s must be an integer", f.name)
+	}
+	if f.kind == kindSint {
+		return protowire.AppendVarint(b, protowire.EncodeZigZag(x)), nil
+	}
+	return protowire.AppendVarint(b, uint64(x)), nil
+}
+
+func toNumber(v interface{}) json.Number {
+	n, _ := v.(json.Number)
+	return n
+}
@@ -0,0 +1,90 @@
+package payload
+
+type kind int
+
+const (
+	kindInt kind = iota
+	kindSint
+	kindDouble
+	kindBool
+	kindString
+	kindMessage
+)
+
+type field struct {
+	num      int32
+	name     string // JSON name
+	kind     kind
+	repeated bool
+	message  []field
+}
+
+// Protobuf schemas of readings.proto, keyed by the last topic segment.
+
+var deviceSchema = []field{
+	{num: 1, name: "uptime", kind: kindInt},
+	{num: 2, name: "temp", kind: kindDouble},
+	{num: 3, name: "hum", kind: kindDouble},
+	{num: 4, name: "long", kind: kindDouble},
+	{num: 5, name: "lat", kind: kindDouble},
+	{num: 6, name: "rssi", kind: kindSint},
+	{num: 7, name: "hwVer", kind: kindString},
+	{num: 8, name: "fwVer", kind: kindString},
+	{num: 9, name: "rdVer", kind: kindString},
+	{num: 10, name: "model", kind: kindString},
+	{num: 11, name: "memory", kind: kindInt},
+	{num: 12, name: "resetReason", kind: kindInt},
+}
+
+var powerSchema = []field{
+	{num: 1, name: "battStat", kind: kindString, repeated: true},
+	{num: 2, name: "solarStat", kind: kindString, repeated: true},
+	{num: 3, name: "loadStat", kind: kindString, repeated: true},
+	{num: 4, name: "battTemp", kind: kindSint},
+	{num: 5, name: "battLevel", kind: kindInt},
+	{num: 6, name: "battVolt", kind: kindInt},
+	{num: 7, name: "eGen", kind: kindInt, repeated: true},
+	{num: 8, name: "eCom", kind: kindInt, repeated: true},
+}
+
+var pressureChannelSchema = []field{
+	{num: 1, name: "measurement", kind: kindString},
+	{num: 2, name: "value", kind: kindDouble},
+	{num: 3, name: "connection", kind: kindInt},
+	{num: 4, name: "enable", kind: kindBool},
+	{num: 5, name: "high_limit", kind: kindDouble},
+	{num: 6, name: "low_limit", kind: kindDouble},
+}
+
+var schemas = map[string][]field{
+	"level": {
+		{num: 1, name: "ts", kind: kindInt},
+		{num: 2, name: "device", kind: kindMessage, message: deviceSchema},
+		{num: 3, name: "level", kind: kindDouble},
+		{num: 4, name: "power", kind: kindMessage, message: powerSchema},
+		{num: 5, name: "tsUnit", kind: kindString},
+	},
+	"flow": {
+		{num: 1, name: "ts", kind: kindInt},
+		{num: 2, name: "device", kind: kindMessage, message: deviceSchema},
+		{num: 3, name: "vHi", kind: kindDouble},
+		{num: 4, name: "vLo", kind: kindDouble},
+		{num: 5, name: "vDec", kind: kindDouble},
+		{num: 6, name: "fRateHi", kind: kindDouble},
+		{num: 7, name: "fRateLo", kind: kindDouble},
+		{num: 8, name: "tsUnit", kind: kindString},
+	},
+	"pressure": {
+		{num: 1, name: "ts", kind: kindInt},
+		{num: 2, name: "device", kind: kindMessage, message: deviceSchema},
+		{num: 3, name: "data", kind: kindMessage, repeated: true, message: pressureChannelSchema},
+		{num: 4, name: "tsUnit", kind: kindString},
+	},
+	"filling": {
+		{num: 1, name: "ts", kind: kindInt},
+		{num: 2, name: "filling-state", kind: kindInt},
+		{num: 3, name: "level", kind: kindDouble},
+		{num: 4, name: "nano_id", kind: kindString},
+		{num: 5, name: "tsUnit", kind: kindString},
+	},
+}
@@ -0,0 +1,194 @@
+package payload
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// protoField is a field as declared in readings.proto.
+type protoField struct {
+	name     string
+	jsonName string
+	typ      string
+	num      int32
+	repeated bool
+}
+
+var (
+	packageRe = regexp.MustCompile(`^package\s+([\w.]+)\s*;$`)
+	messageRe = regexp.MustCompile(`^message\s+(\w+)\s*\{$`)
+	fieldRe   = regexp.MustCompile(`^(repeated\s+)?(\w+)\s+(\w+)\s*=\s*(\d+)\s*(?:\[json_name\s*=\s*"([^"]+)"\])?\s*;$`)
+)
+
+// parseProto reads the subset of proto3 readings.proto uses: one package,
+// flat messages and scalar, message and repeated fields.
+func parseProto(t *testing.T) (string, map[string][]protoField) {
+	t.Helper()
+	f, err := os.Open("readings.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var pkg, message string
+	messages := make(map[string][]protoField)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "//")
+		line = strings.TrimSpace(line)
+		if m := packageRe.FindStringSubmatch(line); m != nil {
+			pkg = m[1]
+		} else if m := messageRe.FindStringSubmatch(line); m != nil {
+			message = m[1]
+			messages[message] = nil
+		} else if line == "}" {
+			message = ""
+		} else if m := fieldRe.FindStringSubmatch(line); m != nil && message != "" {
+			num, _ := strconv.Atoi(m[4])
+			jsonName := m[5]
+			if jsonName == "" {
+				jsonName = m[3]
+			}
+			messages[message] = append(messages[message], protoField{
+				name:     m[3],
+				jsonName: jsonName,
+				typ:      m[2],
+				num:      int32(num),
+				repeated: m[1] != "",
+			})
+		} else if line != "" && !strings.HasPrefix(line, "syntax") {
+			t.Fatalf("readings.proto: unsupported line %q", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return pkg, messages
+}
+
+var scalarKinds = map[string]kind{
+	"int64":  kindInt,
+	"sint64": kindSint,
+	"double": kindDouble,
+	"bool":   kindBool,
+	"string": kindString,
+}
+
+// messageName maps a topic kind to its message, e.g. "level" to "Level".
+func messageName(topicKind string) string {
+	return strings.ToUpper(topicKind[:1]) + topicKind[1:]
+}
+
+func TestSchemaMatchesProto(t *testing.T) {
+	_, messages := parseProto(t)
+	for topicKind, schema := range schemas {
+		compareSchema(t, messageName(topicKind), schema, messages)
+	}
+
+	// Messages that aren't embedded in another one are readings and need a
+	// schema.
+	embedded := make(map[string]bool)
+	for _, fields := range messages {
+		for _, pf := range fields {
+			embedded[pf.typ] = true
+		}
+	}
+	for message := range messages {
+		if _, ok := schemas[strings.ToLower(message)]; !ok && !embedded[message] {
+			t.Errorf("readings.proto message %s has no schema in schema.go", message)
+		}
+	}
+}
+
+func compareSchema(t *testing.T, message string, schema []field, messages map[string][]protoField) {
+	t.Helper()
+	fields, ok := messages[message]
+	if !ok {
+		t.Errorf("message %s is not in readings.proto", message)
+		return
+	}
+	if len(fields) != len(schema) {
+		t.Errorf("%s: schema.go has %d fields, readings.proto has %d", message, len(schema), len(fields))
+	}
+	for _, pf := range fields {
+		f, ok := lookupField(schema, protowire.Number(pf.num))
+		if !ok {
+			t.Errorf("%s.%s = %d is missing from schema.go", message, pf.name, pf.num)
+			continue
+		}
+		if f.name != pf.jsonName {
+			t.Errorf("%s field %d: schema.go name %q, readings.proto JSON name %q", message, pf.num, f.name, pf.jsonName)
+		}
+		if f.repeated != pf.repeated {
+			t.Errorf("%s.%s: schema.go repeated = %v, readings.proto repeated = %v", message, pf.name, f.repeated, pf.repeated)
+		}
+		if k, ok := scalarKinds[pf.typ]; ok {
+			if f.kind != k {
+				t.Errorf("%s.%s: schema.go kind %d, readings.proto type %s", message, pf.name, f.kind, pf.typ)
+			}
+			continue
+		}
+		if f.kind != kindMessage {
+			t.Errorf("%s.%s: schema.go kind %d, readings.proto type %s", message, pf.name, f.kind, pf.typ)
+			continue
+		}
+		compareSchema(t, pf.typ, f.message, messages)
+	}
+}
+
+var descriptorTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"sint64": descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+	"double": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+}
+
+// protoFile builds the descriptor of readings.proto, so tests can encode
+// messages with the protobuf runtime instead of this package.
+func protoFile(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	pkg, messages := parseProto(t)
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("readings.proto"),
+		Package: proto.String(pkg),
+		Syntax:  proto.String("proto3"),
+	}
+	for name, fields := range messages {
+		msg := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+		for _, pf := range fields {
+			fd := &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String(pf.name),
+				JsonName: proto.String(pf.jsonName),
+				Number:   proto.Int32(pf.num),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}
+			if pf.repeated {
+				fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			}
+			if typ, ok := descriptorTypes[pf.typ]; ok {
+				fd.Type = typ.Enum()
+			} else {
+				fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+				fd.TypeName = proto.String("." + pkg + "." + pf.typ)
+			}
+			msg.Field = append(msg.Field, fd)
+		}
+		file.MessageType = append(file.MessageType, msg)
+	}
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatalf("building descriptor of readings.proto: %v", err)
+	}
+	return fd
+}
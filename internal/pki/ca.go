@@ -0,0 +1,207 @@
+// Package pki signs device client certificates with a locally configured
+// certificate authority.
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+var ErrInvalidCSR = errors.New("invalid certificate signing request")
+
+type CA struct {
+	cert     *x509.Certificate
+	key      crypto.Signer
+	chainPEM []byte
+	org      string
+}
+
+// LoadCA reads a PEM certificate file, whose first certificate is the
+// signing CA and any following ones its issuers, and the CA's private key.
+func LoadCA(certFile, keyFile, organization string) (*CA, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA certificate: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("CA certificate file has no PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, errors.New("CA certificate is not a CA")
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA key: %w", err)
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{cert: cert, key: key, chainPEM: bytes.TrimSpace(certPEM), org: organization}, nil
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("CA key file has no PEM block")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported CA key type %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("CA key cannot sign")
+	}
+	return signer, nil
+}
+
+// ParseCSR decodes a PEM CSR, checks its signature and that the public key is
+// strong enough for a device identity.
+func ParseCSR(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("%w: no PEM certificate request", ErrInvalidCSR)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: bad signature: %v", ErrInvalidCSR, err)
+	}
+
+	switch pub := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%w: RSA key shorter than 2048 bits", ErrInvalidCSR)
+		}
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() && pub.Curve != elliptic.P384() {
+			return nil, fmt.Errorf("%w: unsupported elliptic curve", ErrInvalidCSR)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported public key type", ErrInvalidCSR)
+	}
+	return csr, nil
+}
+
+type Issued struct {
+	CertificatePEM []byte
+	ChainPEM       []byte
+	SerialNumber   string
+	Fingerprint    string
+	NotAfter       time.Time
+}
+
+// SignClientCertificate issues a TLS client certificate for commonName with
+// the CSR's public key. Any subject or extensions requested in the CSR are
+// ignored.
+func (ca *CA) SignClientCertificate(csr *x509.CertificateRequest, commonName string, validity time.Duration) (*Issued, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("error generating certificate serial: %w", err)
+	}
+
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+
+	subject := pkix.Name{CommonName: commonName}
+	if ca.org != "" {
+		subject.Organization = []string{ca.org}
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("error signing certificate: %w", err)
+	}
+
+	fingerprint := sha256.Sum256(der)
+	return &Issued{
+		CertificatePEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		ChainPEM:       ca.chainPEM,
+		SerialNumber:   serial.Text(16),
+		Fingerprint:    hex.EncodeToString(fingerprint[:]),
+		NotAfter:       notAfter,
+	}, nil
+}
+
+// Revoked is a certificate listed on the CRL.
+type Revoked struct {
+	SerialNumber string // hex, as in Issued
+	RevokedAt    time.Time
+}
+
+// CreateCRL returns a DER certificate revocation list signed by the CA. The
+// CA certificate must allow CRL signing. number must grow with every list so
+// relying parties can tell which one is newer.
+func (ca *CA) CreateCRL(revoked []Revoked, number int64, validity time.Duration) ([]byte, error) {
+	now := time.Now()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(number),
+		ThisUpdate: now,
+		NextUpdate: now.Add(validity),
+	}
+	for _, r := range revoked {
+		serial, ok := new(big.Int).SetString(r.SerialNumber, 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid certificate serial %q", r.SerialNumber)
+		}
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: r.RevokedAt,
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("error signing CRL: %w", err)
+	}
+	return der, nil
+}
@@ -0,0 +1,105 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA writes a self-signed CA and its key to a temporary directory and
+// loads it.
+func testCA(t *testing.T) *CA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := LoadCA(certFile, keyFile, "Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func TestCreateCRL(t *testing.T) {
+	ca := testCA(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "SN1"}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := ParseCSR(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := ca.SignClientCertificate(csr, "SN1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revokedAt := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	der, err := ca.CreateCRL([]Revoked{{SerialNumber: issued.SerialNumber, RevokedAt: revokedAt}}, 7, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(ca.cert); err != nil {
+		t.Errorf("CRL signature: %v", err)
+	}
+	if crl.Number.Int64() != 7 {
+		t.Errorf("CRL number = %v, want 7", crl.Number)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 {
+		t.Fatalf("CRL has %d entries, want 1", len(crl.RevokedCertificateEntries))
+	}
+	entry := crl.RevokedCertificateEntries[0]
+	if entry.SerialNumber.Text(16) != issued.SerialNumber || !entry.RevocationTime.Equal(revokedAt) {
+		t.Errorf("CRL entry = %s at %v, want %s at %v", entry.SerialNumber.Text(16), entry.RevocationTime, issued.SerialNumber, revokedAt)
+	}
+
+	if _, err := ca.CreateCRL([]Revoked{{SerialNumber: "not hex"}}, 8, time.Hour); err == nil {
+		t.Error("CreateCRL accepted an invalid serial")
+	}
+}
@@ -0,0 +1,120 @@
+package pki
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// SealAlgorithm names the scheme used by Seal: an ephemeral X25519 key
+// agreement with the recipient's key, HKDF-SHA256 and AES-256-GCM.
+const SealAlgorithm = "X25519-HKDF-SHA256-AES256GCM"
+
+var ErrInvalidPublicKey = errors.New("invalid X25519 public key")
+
+// Sealed is a message only the holder of the recipient's private key can
+// open. All fields are standard base64.
+type Sealed struct {
+	Algorithm    string `json:"alg"`
+	EphemeralKey string `json:"epk"`
+	Nonce        string `json:"nonce"`
+	Ciphertext   string `json:"ciphertext"`
+}
+
+// ParsePublicKey decodes a base64 raw X25519 public key.
+func ParsePublicKey(encoded string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext to recipient. aad is authenticated but not
+// encrypted; the recipient must pass the same value to open the message.
+func Seal(recipient *ecdh.PublicKey, plaintext, aad []byte) (*Sealed, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating ephemeral key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving shared secret: %w", err)
+	}
+	gcm, err := sealCipher(shared, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	return &Sealed{
+		Algorithm:    SealAlgorithm,
+		EphemeralKey: base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+		Nonce:        base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext:   base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, aad)),
+	}, nil
+}
+
+// Open decrypts a message sealed to the public key of private.
+func Open(private *ecdh.PrivateKey, sealed *Sealed, aad []byte) ([]byte, error) {
+	if sealed.Algorithm != SealAlgorithm {
+		return nil, fmt.Errorf("unsupported algorithm %q", sealed.Algorithm)
+	}
+	ephemeral, err := ParsePublicKey(sealed.EphemeralKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(sealed.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding nonce: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding ciphertext: %w", err)
+	}
+
+	shared, err := private.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving shared secret: %w", err)
+	}
+	gcm, err := sealCipher(shared, ephemeral, private.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+// sealCipher derives the AES-GCM key from the shared secret, bound to the
+// ephemeral and recipient public keys.
+func sealCipher(shared []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	info := append([]byte(SealAlgorithm), ephemeral.Bytes()...)
+	info = append(info, recipient.Bytes()...)
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, nil, info), key); err != nil {
+		return nil, fmt.Errorf("error deriving key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
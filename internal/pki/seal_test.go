@@ -0,0 +1,47 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := ParsePublicKey(base64.StdEncoding.EncodeToString(private.PublicKey().Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte(`{"username":"device","password":"secret","status":"success"}`)
+	aad := []byte("provisioning/SN1/nonce/response")
+	sealed, err := Seal(recipient, plaintext, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := Open(private, sealed, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Fatalf("opened %q, want %q", opened, plaintext)
+	}
+
+	if _, err := Open(private, sealed, []byte("provisioning/SN1/other/response")); err == nil {
+		t.Fatal("opened a message with different associated data")
+	}
+}
+
+func TestParsePublicKeyInvalid(t *testing.T) {
+	for _, key := range []string{"", "not base64", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := ParsePublicKey(key); err != ErrInvalidPublicKey {
+			t.Errorf("ParsePublicKey(%q) = %v, want ErrInvalidPublicKey", key, err)
+		}
+	}
+}
@@ -1,11 +1,29 @@
 package internal
+
 import (
+	"crypto/ecdh"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"medical-gas-transport-service/internal/pki"
 
 	"github.com/eclipse/paho.golang/paho"
 )
 
+var nonceRe = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+
+// HandleProvisioning issues broker credentials to an allowlisted device.
+// The credentials are encrypted to the X25519 public key in the request;
+// see ProvisioningConfig.RequireEncryption for requests without one.
 func (s *Service) HandleProvisioning(payload []byte) {
 	var provisionRequest ProvisionRequest
 	if err := json.Unmarshal(payload, &provisionRequest); err != nil {
@@ -13,28 +31,310 @@ func (s *Service) HandleProvisioning(payload []byte) {
 		return
 	}
 
-	result, err := s.jayaClient.Provision(provisionRequest.SerialNumber)
+	serialNumber := provisionRequest.SerialNumber
+	log.Printf("Received provisioning request from %s", serialNumber)
+
+	reject := func(responseTopic, reason string) {
+		s.auditProvisioning(provisionRequest, "password", "rejected", reason)
+		log.Printf("Rejected provisioning request from %s: %s", serialNumber, reason)
+		if responseTopic != "" && reason != "rate limited" {
+			s.publishProvisioningResponse(responseTopic, ProvisionResponseData{Status: "rejected"}, nil)
+		}
+	}
+
+	responseTopic, reason := s.authorizeProvisioning(provisionRequest)
+	if reason != "" {
+		reject(responseTopic, reason)
+		return
+	}
+
+	recipient, reason := s.provisioningRecipient(provisionRequest.PublicKey)
+	if reason != "" {
+		s.releaseClaim(serialNumber)
+		reject(responseTopic, reason)
+		return
+	}
+
+	result, err := s.jayaClient.Provision(serialNumber)
+	if err != nil {
+		s.releaseClaim(serialNumber)
+		s.auditProvisioning(provisionRequest, "password", "error", err.Error())
+		log.Printf("error provisioning device %s: %v", serialNumber, err)
+		return
+	}
+
+	s.publishProvisioningResponse(responseTopic, ProvisionResponseData{
+		Username: result.Username,
+		Password: result.Password,
+		Status:   result.Status,
+	}, recipient)
+	s.auditProvisioning(provisionRequest, "password", "provisioned", "")
+	s.recordCredentials(serialNumber, result.Username)
+}
+
+// provisioningRecipient parses the public key of a request. It returns the
+// reason to refuse the request when the key is invalid or required but
+// missing.
+func (s *Service) provisioningRecipient(publicKey string) (*ecdh.PublicKey, string) {
+	if publicKey == "" {
+		if s.cfg.Provisioning.RequireEncryption {
+			return nil, "missing public key"
+		}
+		return nil, ""
+	}
+	recipient, err := pki.ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err.Error()
+	}
+	return recipient, ""
+}
+
+// publishProvisioningResponse publishes data, sealed to recipient unless it
+// is nil. The response topic is authenticated with the ciphertext so a
+// response can't be replayed to another request.
+func (s *Service) publishProvisioningResponse(topic string, data ProvisionResponseData, recipient *ecdh.PublicKey) {
+	response := ProvisionResponse{Pattern: topic, Data: data}
+	if recipient != nil {
+		plaintext, err := json.Marshal(data)
+		if err != nil {
+			log.Printf("error building JSON: %v", err)
+			return
+		}
+		sealed, err := pki.Seal(recipient, plaintext, []byte(topic))
+		if err != nil {
+			log.Printf("error encrypting provisioning response: %v", err)
+			return
+		}
+		response = ProvisionResponse{Pattern: topic, Data: ProvisionResponseData{Status: data.Status}, Encrypted: sealed}
+	}
+
+	p, err := json.Marshal(response)
 	if err != nil {
-		log.Printf("error provisioning device %s: %v", provisionRequest.SerialNumber, err)
-		return
-	}
-
-	response := ProvisionResponse{
-		Pattern: "provisioning/" + provisionRequest.SerialNumber + "/response",
-		Data: ProvisionResponseData{
-			Username: result.Username,
-			Password: result.Password,
-			Status:   result.Status,
-		},
-	}
-	log.Printf("Received provisioning request from %s", provisionRequest.SerialNumber)
-	if p, err := json.Marshal(response); err == nil {
-		s.mqttClient.Client.Publish(s.ctx, &paho.Publish{
-			Topic:   response.Pattern,
-			QoS:     2,
-			Payload: p,
-		})
-	} else {
 		log.Printf("error building JSON: %v", err)
+		return
+	}
+	s.mqttClient.Client.Publish(s.ctx, &paho.Publish{
+		Topic:   topic,
+		QoS:     2,
+		Payload: p,
+	})
+}
+
+// authorizeProvisioning applies rate limits and the allowlist to a request.
+// It returns the topic the response goes to and, when the request is
+// refused, the reason. The response topic embeds the requester's nonce so
+// only the requester knows where its credentials are published. An
+// allowlist entry is claimed by the first authorized request; later ones are
+// refused until an operator re-arms the entry. Callers release the claim
+// with releaseClaim when provisioning fails afterwards.
+func (s *Service) authorizeProvisioning(req ProvisionRequest) (string, string) {
+	cfg := s.cfg.Provisioning
+	if req.SerialNumber == "" {
+		return "", "missing serial number"
+	}
+
+	var responseTopic string
+	switch {
+	case nonceRe.MatchString(req.Nonce):
+		responseTopic = fmt.Sprintf("provisioning/%s/%s/response", req.SerialNumber, req.Nonce)
+	case cfg.AllowLegacyTopic:
+		responseTopic = "provisioning/" + req.SerialNumber + "/response"
+	default:
+		return "", "missing or invalid nonce"
+	}
+
+	if limited, err := s.provisioningRateLimited(req.SerialNumber); err != nil {
+		return responseTopic, "rate limiter unavailable"
+	} else if limited {
+		return responseTopic, "rate limited"
+	}
+
+	if cfg.Mode == "open" {
+		return responseTopic, ""
+	}
+
+	var tokenHash sql.NullString
+	err := s.timescaleClient.DB.QueryRowContext(s.ctx,
+		`SELECT claim_token_hash FROM provisioning_allowlist WHERE serial_number = $1`, req.SerialNumber,
+	).Scan(&tokenHash)
+	if err == sql.ErrNoRows {
+		return responseTopic, "serial number not in allowlist"
+	} else if err != nil {
+		log.Printf("Error reading provisioning allowlist for %s: %v", req.SerialNumber, err)
+		return responseTopic, "allowlist unavailable"
+	}
+
+	if tokenHash.Valid && tokenHash.String != "" {
+		sum := sha256.Sum256([]byte(req.ClaimToken))
+		if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(tokenHash.String)) != 1 {
+			return responseTopic, "invalid claim token"
+		}
+	}
+
+	result, err := s.timescaleClient.DB.ExecContext(s.ctx,
+		`UPDATE provisioning_allowlist SET claimed_at = NOW() WHERE serial_number = $1 AND claimed_at IS NULL`, req.SerialNumber)
+	if err != nil {
+		log.Printf("Error claiming provisioning allowlist entry for %s: %v", req.SerialNumber, err)
+		return responseTopic, "allowlist unavailable"
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return responseTopic, "already claimed"
+	}
+	return responseTopic, ""
+}
+
+// provisioningRateLimited counts the request against the per serial and
+// global fixed windows.
+func (s *Service) provisioningRateLimited(serialNumber string) (bool, error) {
+	cfg := s.cfg.Provisioning
+	rdb := s.redisClient.Rdb
+	now := time.Now()
+
+	windows := []struct {
+		key    string
+		limit  int
+		window time.Duration
+	}{
+		{"provisioning:rate:" + serialNumber, cfg.SerialLimit, cfg.SerialWindow},
+		{"provisioning:rate:global", cfg.GlobalLimit, time.Minute},
+	}
+	for _, w := range windows {
+		if w.limit <= 0 || w.window <= 0 {
+			continue
+		}
+		key := w.key + ":" + strconv.FormatInt(now.UnixNano()/int64(w.window), 10)
+		pipe := rdb.TxPipeline()
+		count := pipe.Incr(s.ctx, key)
+		pipe.Expire(s.ctx, key, w.window)
+		if _, err := pipe.Exec(s.ctx); err != nil {
+			log.Printf("Error applying provisioning rate limit: %v", err)
+			return false, err
+		}
+		if count.Val() > int64(w.limit) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Service) auditProvisioning(req ProvisionRequest, method, outcome, reason string) {
+	var nonceHash string
+	if req.Nonce != "" {
+		sum := sha256.Sum256([]byte(req.Nonce))
+		nonceHash = hex.EncodeToString(sum[:])
+	}
+	err := s.writeToTimescaleDBWithRetry(`
+		INSERT INTO provisioning_audit (time, serial_number, method, outcome, reason, nonce_hash, claim_token_present)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, time.Now(), req.SerialNumber, method, outcome, reason, nonceHash, req.ClaimToken != "")
+	if err != nil {
+		log.Printf("Error writing provisioning audit for %s: %v", req.SerialNumber, err)
+	}
+}
+
+// releaseClaim re-arms the allowlist entry claimed by a request that then
+// failed, so the device can retry.
+func (s *Service) releaseClaim(serialNumber string) {
+	if s.cfg.Provisioning.Mode == "open" {
+		return
+	}
+	_, err := s.timescaleClient.DB.ExecContext(s.ctx,
+		`UPDATE provisioning_allowlist SET claimed_at = NULL WHERE serial_number = $1`, serialNumber)
+	if err != nil {
+		log.Printf("Error releasing provisioning claim for %s: %v", serialNumber, err)
+	}
+}
+
+// handleProvisioningAllowlist lets operators pre-register serial numbers,
+// optionally with a claim token the device must present. Posting a serial
+// number again re-arms an entry that was already claimed.
+func (s *Service) handleProvisioningAllowlist(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SerialNumbers []string `json:"serial_numbers"`
+		ClaimToken    string   `json:"claim_token"`
+	}
+
+	switch r.Method {
+	case http.MethodPost, http.MethodDelete:
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.SerialNumbers) == 0 {
+		writeError(w, http.StatusBadRequest, "serial_numbers is required")
+		return
+	}
+
+	var tokenHash *string
+	if req.ClaimToken != "" {
+		sum := sha256.Sum256([]byte(req.ClaimToken))
+		h := hex.EncodeToString(sum[:])
+		tokenHash = &h
+	}
+
+	for _, sn := range req.SerialNumbers {
+		var err error
+		if r.Method == http.MethodDelete {
+			_, err = s.timescaleClient.DB.ExecContext(r.Context(), `DELETE FROM provisioning_allowlist WHERE serial_number = $1`, sn)
+		} else {
+			_, err = s.timescaleClient.DB.ExecContext(r.Context(), `
+				INSERT INTO provisioning_allowlist (serial_number, claim_token_hash, created_at)
+				VALUES ($1, $2, NOW())
+				ON CONFLICT (serial_number) DO UPDATE SET claim_token_hash = EXCLUDED.claim_token_hash, claimed_at = NULL
+			`, sn, tokenHash)
+		}
+		if err != nil {
+			log.Printf("Error updating provisioning allowlist for %s: %v", sn, err)
+			writeError(w, http.StatusInternalServerError, "failed to update allowlist")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "success", "serial_numbers": req.SerialNumbers})
+}
+
+func (s *Service) handleProvisioningAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	limit, offset, ok := pagination(w, q.Get("limit"), q.Get("offset"))
+	if !ok {
+		return
+	}
+
+	rows, err := s.timescaleClient.DB.QueryContext(r.Context(), `
+		SELECT time, serial_number, method, outcome, reason, claim_token_present
+		FROM provisioning_audit
+		WHERE ($1 = '' OR serial_number = $1) AND ($2 = '' OR outcome = $2)
+		ORDER BY time DESC
+		LIMIT $3 OFFSET $4
+	`, q.Get("serial_number"), q.Get("outcome"), limit, offset)
+	if err != nil {
+		log.Printf("Error querying provisioning audit: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to query audit")
+		return
+	}
+	defer rows.Close()
+
+	type auditEntry struct {
+		Time              time.Time `json:"time"`
+		SerialNumber      string    `json:"serial_number"`
+		Method            string    `json:"method"`
+		Outcome           string    `json:"outcome"`
+		Reason            string    `json:"reason"`
+		ClaimTokenPresent bool      `json:"claim_token_present"`
+	}
+	entries := []auditEntry{}
+	for rows.Next() {
+		var e auditEntry
+		if err := rows.Scan(&e.Time, &e.SerialNumber, &e.Method, &e.Outcome, &e.Reason, &e.ClaimTokenPresent); err != nil {
+			log.Printf("Error scanning provisioning audit: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to query audit")
+			return
+		}
+		entries = append(entries, e)
 	}
-}
\ No newline at end of file
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "success", "data": entries})
+}
@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"medical-gas-transport-service/internal/validation"
+
+	"github.com/lib/pq"
+)
+
+// validationCountersKey is a hash of rejected readings per payload type and
+// rule, shared by all instances.
+const validationCountersKey = "validation:rejections"
+
+type QuarantinedReading struct {
+	ID           int64                  `json:"id"`
+	ReceivedAt   time.Time              `json:"received_at"`
+	SerialNumber string                 `json:"serial_number"`
+	PayloadType  string                 `json:"payload_type"`
+	Topic        string                 `json:"topic"`
+	FWVersion    *string                `json:"fw_version"`
+	Violations   []validation.Violation `json:"violations"`
+	Payload      json.RawMessage        `json:"payload"`
+}
+
+// validateReading checks a reading against the rules for its payload type,
+// judging its timestamp against now: the receive time, shifted by the clock
+// offset of devices whose timestamps are corrected. Readings that break any
+// rule are quarantined and counted per rule rather than stored.
+func (s *Service) validateReading(serialNumber, topic, kind string, payload []byte, now time.Time) bool {
+	if !s.cfg.Validation.Enabled {
+		return true
+	}
+
+	violations := validation.Validate(kind, payload, validation.Env{
+		Now: now,
+		Limits: validation.Limits{
+			MaxFuture: s.cfg.Validation.MaxFuture,
+			MaxPast:   s.cfg.Validation.MaxPast,
+		},
+	})
+	if len(violations) == 0 {
+		return true
+	}
+
+	reasons := make([]string, len(violations))
+	details := make([]string, len(violations))
+	for i, v := range violations {
+		reasons[i] = v.Reason()
+		details[i] = v.String()
+	}
+	log.Printf("Quarantining %s reading from device %s: %s", kind, serialNumber, strings.Join(details, ", "))
+
+	pipe := s.redisClient.Rdb.Pipeline()
+	for _, reason := range uniqueStrings(reasons) {
+		pipe.HIncrBy(s.ctx, validationCountersKey, kind+":"+reason, 1)
+	}
+	if _, err := pipe.Exec(s.ctx); err != nil {
+		log.Printf("Error counting validation failures for device %s: %v", serialNumber, err)
+	}
+
+	var reading struct {
+		Device struct {
+			FWVer string `json:"fwVer"`
+		} `json:"device"`
+	}
+	var fwVersion *string
+	if json.Unmarshal(payload, &reading) == nil && reading.Device.FWVer != "" {
+		fwVersion = &reading.Device.FWVer
+	}
+
+	violationsJSON, err := json.Marshal(violations)
+	if err != nil {
+		log.Printf("Error marshaling validation failures: %v", err)
+		return false
+	}
+	err = s.writeToTimescaleDBWithRetry(`
+		INSERT INTO sensor_quarantine (serial_number, payload_type, topic, fw_version, reasons, violations, payload)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, serialNumber, kind, topic, fwVersion, pq.Array(uniqueStrings(reasons)), violationsJSON, payload)
+	if err != nil {
+		log.Printf("Error quarantining %s reading from device %s: %v", kind, serialNumber, err)
+	}
+	return false
+}
+
+// decodeValid validates the readings of a batch one by one and decodes the
+// valid ones, returning how many were quarantined.
+func decodeValid[T any](s *Service, serialNumber, topic, kind string, raws []json.RawMessage, receivedAt time.Time) ([]T, int) {
+	readings := make([]T, 0, len(raws))
+	invalid := 0
+	for _, raw := range raws {
+		if !s.validateReading(serialNumber, topic, kind, raw, receivedAt) {
+			invalid++
+			continue
+		}
+		var reading T
+		if err := json.Unmarshal(raw, &reading); err != nil {
+			log.Printf("Error unmarshaling sensor %s reading: %v", kind, err)
+			invalid++
+			continue
+		}
+		readings = append(readings, reading)
+	}
+	return readings, invalid
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := values[:0:0]
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// handleValidationCounters serves the number of rejected readings per
+// payload type and rule.
+func (s *Service) handleValidationCounters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	counts, err := s.redisClient.Rdb.HGetAll(r.Context(), validationCountersKey).Result()
+	if err != nil {
+		log.Printf("Error reading validation counters: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to read validation counters")
+		return
+	}
+
+	counters := map[string]map[string]int64{}
+	for key, raw := range counts {
+		kind, reason, ok := strings.Cut(key, ":")
+		if !ok {
+			continue
+		}
+		count, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			continue
+		}
+		if counters[kind] == nil {
+			counters[kind] = map[string]int64{}
+		}
+		counters[kind][reason] = count
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   counters,
+	})
+}
+
+func (s *Service) handleQuarantine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	limit, offset, ok := pagination(w, q.Get("limit"), q.Get("offset"))
+	if !ok {
+		return
+	}
+
+	rows, err := s.timescaleClient.DB.QueryContext(r.Context(), `
+		SELECT id, received_at, serial_number, payload_type, topic, fw_version, violations, payload
+		FROM sensor_quarantine
+		WHERE ($1 = '' OR serial_number = $1)
+		AND ($2 = '' OR payload_type = $2)
+		AND ($3 = '' OR $3 = ANY(reasons))
+		AND ($4 = '' OR fw_version = $4)
+		ORDER BY received_at DESC
+		LIMIT $5 OFFSET $6
+	`, q.Get("serial_number"), q.Get("type"), q.Get("reason"), q.Get("fw_version"), limit, offset)
+	if err != nil {
+		log.Printf("Error querying quarantined readings: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to query quarantined readings")
+		return
+	}
+	defer rows.Close()
+
+	readings := []QuarantinedReading{}
+	for rows.Next() {
+		var reading QuarantinedReading
+		var violations, payload []byte
+		if err := rows.Scan(&reading.ID, &reading.ReceivedAt, &reading.SerialNumber, &reading.PayloadType,
+			&reading.Topic, &reading.FWVersion, &violations, &payload); err != nil {
+			log.Printf("Error scanning quarantined reading: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to query quarantined readings")
+			return
+		}
+		json.Unmarshal(violations, &reading.Violations)
+		// Malformed payloads are returned as a JSON string.
+		if json.Valid(payload) {
+			reading.Payload = payload
+		} else {
+			reading.Payload, _ = json.Marshal(string(payload))
+		}
+		readings = append(readings, reading)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   readings,
+	})
+}
@@ -0,0 +1,333 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"medical-gas-transport-service/internal/services"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var readingTypes = []string{"level", "flow", "pressure"}
+
+// storeIfNewer keeps the latest event of one reading type in a hash, ignoring
+// readings older than the one already stored.
+var storeIfNewer = redis.NewScript(`
+local ts = redis.call('HGET', KEYS[1], ARGV[1] .. ':ts')
+if ts and tonumber(ts) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3], ARGV[1] .. ':ts', ARGV[2], 'hospital', ARGV[4])
+return 1
+`)
+
+// readingSeries describes how one reading type is downsampled.
+type readingSeries struct {
+	table   string
+	columns []string
+	exprs   []string
+}
+
+var readingSeriesByType = map[string]readingSeries{
+	"level": {
+		table:   "sensor_level",
+		columns: []string{"level", "level_min", "level_max", "level_kg", "level_m3"},
+		exprs:   []string{"avg(level)", "min(level)", "max(level)", "avg(level_kg)", "avg(level_meter_cubic)"},
+	},
+	"flow": {
+		table:   "sensor_flow",
+		columns: []string{"flow_rate", "flow_rate_max", "cumulative_volume", "delta_volume"},
+		exprs:   []string{"avg(flow_rate)", "max(flow_rate)", "max(cumulative_volume)", "sum(delta_volume)"},
+	},
+	"pressure": {
+		table:   "sensor_pressure",
+		columns: []string{"nitrous_oxide", "oxygen", "medical_air", "vacuum"},
+		exprs:   []string{"avg(nitrous_oxide_value)", "avg(oxygen_value)", "avg(medical_air_value)", "avg(vacuum_value)"},
+	},
+}
+
+type FillingTransaction struct {
+	Time         time.Time `json:"time"`
+	SerialNumber string    `json:"serial_number"`
+	Hospital     *string   `json:"hospital"`
+	NanoID       string    `json:"nano_id"`
+	Level        float64   `json:"level"`
+	LevelKg      *float64  `json:"level_kg"`
+	LevelM3      *float64  `json:"level_m3"`
+	State        bool      `json:"state"`
+	Flag         *string   `json:"flag"`
+}
+
+// deviceHospital returns the hospital a device belongs to, falling back to
+// its installation points when the device itself has none.
+func deviceHospital(device *services.Device) string {
+	for _, hospital := range []string{
+		device.Hospital.ID,
+		device.InstallationPointTank.Hospital,
+		device.InstallationPointFlow.Hospital,
+		device.InstallationPointPressure.Hospital,
+	} {
+		if hospital != "" {
+			return hospital
+		}
+	}
+	return ""
+}
+
+// storeLatestReading keeps the published event of a reading in Redis so the
+// HTTP API can serve the latest value per device without hitting the database.
+func (s *Service) storeLatestReading(serialNumber, hospital, readingType string, timestamp time.Time, event []byte) {
+	err := storeIfNewer.Run(s.ctx, s.redisClient.Rdb, []string{"latest/" + serialNumber},
+		readingType, timestamp.UnixMicro(), event, hospital).Err()
+	if err != nil {
+		log.Printf("Error storing latest %s reading for device %s: %v", readingType, serialNumber, err)
+	}
+}
+
+// authorizeDevice looks up a device and checks the request's API key may
+// access the hospital it belongs to.
+func (s *Service) authorizeDevice(w http.ResponseWriter, r *http.Request, serialNumber string) bool {
+	scope := hospitalScope(r)
+	if scope == "" {
+		return true
+	}
+
+	device, err := s.getDeviceFromCacheOrService(serialNumber)
+	if err != nil || device == nil {
+		writeError(w, http.StatusNotFound, "device not found")
+		return false
+	}
+	if deviceHospital(device) != scope {
+		writeError(w, http.StatusForbidden, "API key is not allowed to access this device")
+		return false
+	}
+	return true
+}
+
+func (s *Service) handleLatestReadings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	serialNumbers := strings.Split(r.URL.Query().Get("serial_number"), ",")
+	if serialNumbers[0] == "" {
+		writeError(w, http.StatusBadRequest, "serial_number is required")
+		return
+	}
+	if len(serialNumbers) > 100 {
+		writeError(w, http.StatusBadRequest, "at most 100 serial numbers per request")
+		return
+	}
+
+	pipe := s.redisClient.Rdb.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(serialNumbers))
+	for i, serialNumber := range serialNumbers {
+		cmds[i] = pipe.HMGet(r.Context(), "latest/"+serialNumber, "hospital", "level", "flow", "pressure")
+	}
+	if _, err := pipe.Exec(r.Context()); err != nil && err != redis.Nil {
+		log.Printf("Error reading latest readings: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to read latest readings")
+		return
+	}
+
+	scope := hospitalScope(r)
+	latest := map[string]map[string]json.RawMessage{}
+	for i, serialNumber := range serialNumbers {
+		values := cmds[i].Val()
+		if hospital, _ := values[0].(string); scope != "" && hospital != scope {
+			continue
+		}
+
+		readings := map[string]json.RawMessage{}
+		for j, readingType := range readingTypes {
+			if v, ok := values[j+1].(string); ok {
+				readings[readingType] = json.RawMessage(v)
+			}
+		}
+		if len(readings) > 0 {
+			latest[serialNumber] = readings
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   latest,
+	})
+}
+
+// handleReadingHistory serves /readings/{level|flow|pressure} downsampled
+// into time buckets of the requested interval.
+func (s *Service) handleReadingHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	series, ok := readingSeriesByType[strings.TrimPrefix(r.URL.Path, "/readings/")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "reading type must be one of "+strings.Join(readingTypes, ", "))
+		return
+	}
+
+	q := r.URL.Query()
+	serialNumber := q.Get("serial_number")
+	if serialNumber == "" {
+		writeError(w, http.StatusBadRequest, "serial_number is required")
+		return
+	}
+
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, "from must be RFC3339")
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, "to must be RFC3339")
+			return
+		}
+	}
+
+	interval := 5 * time.Minute
+	if v := q.Get("interval"); v != "" {
+		if interval, err = time.ParseDuration(v); err != nil || interval < time.Second {
+			writeError(w, http.StatusBadRequest, "interval must be a duration of at least 1s")
+			return
+		}
+	}
+
+	limit, offset, ok := pagination(w, q.Get("limit"), q.Get("offset"))
+	if !ok {
+		return
+	}
+	if !s.authorizeDevice(w, r, serialNumber) {
+		return
+	}
+
+	query := fmt.Sprintf(`
+		SELECT time_bucket($1::interval, time) AS bucket, count(*), %s
+		FROM %s
+		WHERE serial_number = $2 AND time >= $3 AND time < $4
+		GROUP BY bucket
+		ORDER BY bucket
+		LIMIT $5 OFFSET $6
+	`, strings.Join(series.exprs, ", "), series.table)
+	rows, err := s.timescaleClient.DB.QueryContext(r.Context(), query,
+		fmt.Sprintf("%d seconds", int64(interval.Seconds())), serialNumber, from, to, limit, offset)
+	if err != nil {
+		log.Printf("Error querying %s history: %v", series.table, err)
+		writeError(w, http.StatusInternalServerError, "failed to query readings")
+		return
+	}
+	defer rows.Close()
+
+	points := []map[string]interface{}{}
+	for rows.Next() {
+		var bucket time.Time
+		var count int
+		values := make([]sql.NullFloat64, len(series.columns))
+		dest := []interface{}{&bucket, &count}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			log.Printf("Error scanning %s history: %v", series.table, err)
+			writeError(w, http.StatusInternalServerError, "failed to query readings")
+			return
+		}
+
+		point := map[string]interface{}{"bucket": bucket, "readings": count}
+		for i, column := range series.columns {
+			if values[i].Valid {
+				point[column] = values[i].Float64
+			} else {
+				point[column] = nil
+			}
+		}
+		points = append(points, point)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":        "success",
+		"serial_number": serialNumber,
+		"interval":      interval.String(),
+		"data":          points,
+	})
+}
+
+func (s *Service) handleFillingTransactions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	limit, offset, ok := pagination(w, q.Get("limit"), q.Get("offset"))
+	if !ok {
+		return
+	}
+	hospital, ok := scopedHospital(w, r, q.Get("hospital"))
+	if !ok {
+		return
+	}
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, "from must be RFC3339")
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, "to must be RFC3339")
+			return
+		}
+	}
+
+	rows, err := s.timescaleClient.DB.QueryContext(r.Context(), `
+		SELECT f.time, f.serial_number, i.hospital, f.nano_id, f.level, f.level_kg, f.level_meter_cubic, f.state, f.flag
+		FROM filling_transaction f
+		LEFT JOIN device_inventory i ON i.serial_number = f.serial_number
+		WHERE f.time >= $1 AND f.time < $2
+		AND ($3 = '' OR f.serial_number = $3)
+		AND ($4 = '' OR i.hospital = $4)
+		ORDER BY f.time DESC
+		LIMIT $5 OFFSET $6
+	`, from, to, q.Get("serial_number"), hospital, limit, offset)
+	if err != nil {
+		log.Printf("Error querying filling transactions: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to query filling transactions")
+		return
+	}
+	defer rows.Close()
+
+	transactions := []FillingTransaction{}
+	for rows.Next() {
+		var t FillingTransaction
+		if err := rows.Scan(&t.Time, &t.SerialNumber, &t.Hospital, &t.NanoID, &t.Level, &t.LevelKg, &t.LevelM3, &t.State, &t.Flag); err != nil {
+			log.Printf("Error scanning filling transaction: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to query filling transactions")
+			return
+		}
+		transactions = append(transactions, t)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   transactions,
+	})
+}
@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// updateState runs a read-modify-write of the JSON state stored at key. It
+// runs in a WATCH transaction because readings for the same device can be
+// processed by several workers or instances at once, so update may be
+// called more than once and should only keep what its last call computed.
+// update gets nil when no state is stored or it can't be parsed.
+func updateState[T any](s *Service, key string, ttl time.Duration, update func(prev *T) T) error {
+	txf := func(tx *redis.Tx) error {
+		var prev *T
+		raw, err := tx.Get(s.ctx, key).Result()
+		switch {
+		case err == nil:
+			prev = new(T)
+			if err := json.Unmarshal([]byte(raw), prev); err != nil {
+				log.Printf("Error parsing state %s, starting over: %v", key, err)
+				prev = nil
+			}
+		case err != redis.Nil:
+			return err
+		}
+
+		state, err := json.Marshal(update(prev))
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(s.ctx, key, state, ttl)
+			return nil
+		})
+		return err
+	}
+
+	for retry := 0; retry < 3; retry++ {
+		err := s.redisClient.Rdb.Watch(s.ctx, txf, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error updating %s: %w", key, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("error updating %s: too many concurrent updates", key)
+}
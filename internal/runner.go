@@ -2,14 +2,21 @@ package internal
 
 import (
 	"context"
+	"database/sql"
 	"log"
 	"strings"
 	"time"
 	"fmt"
+	"net/http"
 	"sync/atomic"
 	"github.com/lib/pq"
 
 	"medical-gas-transport-service/config"
+	"medical-gas-transport-service/internal/cache"
+	"medical-gas-transport-service/internal/export"
+	"medical-gas-transport-service/internal/payload"
+	"medical-gas-transport-service/internal/pki"
+	"medical-gas-transport-service/internal/stream"
 	"medical-gas-transport-service/internal/services"
 
 	"github.com/eclipse/paho.golang/autopaho"
@@ -25,24 +32,42 @@ type Service struct {
 	cfg             *config.Config
 	messageChan     chan MqttMessage
 	messageCount    atomic.Int64
+	deviceCache     *cache.Cache[*services.Device]
+	conversionCache *cache.Cache[[]services.TankConversion]
+	mux             *http.ServeMux
+	ca              *pki.CA
+	streamHub       *stream.Hub
+	exporter        *export.Exporter
 }
 
-func NewService(ctx context.Context, mqttClient *services.MqttClient, redisClient *services.Redis, jayaClient *services.Jaya, timescaleClient *services.TimescaleClient, cfg *config.Config) *Service {
-	return &Service{
+func NewService(ctx context.Context, mqttClient *services.MqttClient, redisClient *services.Redis, jayaClient *services.Jaya, timescaleClient *services.TimescaleClient, ca *pki.CA, exporter *export.Exporter, cfg *config.Config) *Service {
+	s := &Service{
 		ctx:             ctx,
 		mqttClient:      mqttClient,
 		redisClient:     redisClient,
 		jayaClient:      jayaClient,
 		timescaleClient: timescaleClient,
+		ca:              ca,
+		exporter:        exporter,
 		cfg:             cfg,
 		messageChan: make(chan MqttMessage, 1000),
+		mux:         http.NewServeMux(),
 	}
+	s.setupCaches()
+	return s
 }
 
 func (s *Service) Start() {
 	s.subscribeToMQTT()
 	s.addPublishHandler()
 	s.startWorkerPool(10)
+	s.subscribeToInvalidations()
+	s.startLivenessScanner()
+	s.startOTAEvaluator()
+	s.startCredentialRotation()
+	s.startOutboxRelay()
+	s.startStreamGateway()
+	s.startHTTPServer()
 
 	go func() {
 		ticker := time.NewTicker(time.Second * 15)
@@ -58,20 +83,35 @@ func (s *Service) subscribeToMQTT() {
 		Subscriptions: []paho.SubscribeOptions{
 			// {Topic: "$share/g1/JI/v2/#", QoS: 0},
 			{Topic: "$share/g1/provisioning", QoS: 0},
+			{Topic: "$share/g1/provisioning/csr", QoS: 0},
 			{Topic: "$share/g1/JI/v2/+/level", QoS: 0},
 			{Topic: "$share/g1/JI/v2/+/flow", QoS: 0},
 			{Topic: "$share/g1/JI/v2/+/pressure", QoS: 0},
 			{Topic: "$share/g1/JI/v2/+/filling", QoS: 0},
+			{Topic: "$share/g1/JI/v2/+/level/+", QoS: 0},
+			{Topic: "$share/g1/JI/v2/+/flow/+", QoS: 0},
+			{Topic: "$share/g1/JI/v2/+/pressure/+", QoS: 0},
+			{Topic: "$share/g1/JI/v2/+/filling/+", QoS: 0},
+			{Topic: "$share/g1/JI/v2/+/backfill", QoS: 1},
+			{Topic: "$share/g1/JI/v2/+/backfill/+", QoS: 1},
+			{Topic: "$share/g1/JI/v2/+/ota-ack", QoS: 1},
+			{Topic: "$share/g1/JI/v2/+/config-ack", QoS: 1},
+			{Topic: "$share/g1/JI/v2/+/credentials-ack/+", QoS: 1},
 		},
 	})
 }
 
 func (s *Service) addPublishHandler() {
 	s.mqttClient.Client.AddOnPublishReceived(func(pr autopaho.PublishReceived) (bool, error) {
-		s.messageChan <- MqttMessage{
-			Topic:   pr.Packet.Topic,
-			Payload: pr.Packet.Payload,
+		msg := MqttMessage{
+			Topic:      pr.Packet.Topic,
+			Payload:    pr.Packet.Payload,
+			ReceivedAt: time.Now(),
 		}
+		if pr.Packet.Properties != nil {
+			msg.ContentType = pr.Packet.Properties.ContentType
+		}
+		s.messageChan <- msg
 		return true, nil
 	})
 }
@@ -87,16 +127,32 @@ func (s *Service) processMessages() {
 	for msg := range s.messageChan {
 		s.messageCount.Add(1)
 
-		topic := msg.Topic
-		payload := msg.Payload
+		// CBOR and Protobuf payloads are transcoded to JSON up front so the
+		// handlers only decode one format.
+		format, topic := payload.Detect(msg.Topic, msg.ContentType)
+		data, err := payload.ToJSON(format, topic[strings.LastIndex(topic, "/")+1:], msg.Payload)
+		if err != nil {
+			log.Printf("Error decoding %s payload on %s: %v", format, msg.Topic, err)
+			continue
+		}
 
 		switch {
 			case topic == "provisioning":
-				s.HandleProvisioning(payload)
+				s.HandleProvisioning(data)
+			case topic == "provisioning/csr":
+				s.HandleCertificateProvisioning(data)
 			case strings.HasPrefix(topic, "JI/v2/") && strings.HasSuffix(topic, "/filling"):
-				s.HandleFilling(topic, payload)
+				s.HandleFilling(topic, data, msg.ReceivedAt)
+			case strings.HasPrefix(topic, "JI/v2/") && strings.HasSuffix(topic, "/backfill"):
+				s.HandleBackfill(topic, data, msg.ReceivedAt)
+			case strings.HasPrefix(topic, "JI/v2/") && strings.HasSuffix(topic, "/ota-ack"):
+				s.HandleOTAAck(topic, data)
+			case strings.HasPrefix(topic, "JI/v2/") && strings.HasSuffix(topic, "/config-ack"):
+				s.HandleConfigAck(topic, data)
+			case strings.HasPrefix(topic, "JI/v2/") && strings.Contains(topic, "/credentials-ack/"):
+				s.HandleCredentialsAck(topic, data)
 			case strings.HasPrefix(topic, "JI/v2/"):
-				s.HandleSensorData(topic, payload)
+				s.HandleSensorData(topic, data, msg.ReceivedAt)
 			default:
 				log.Printf("Unknown topic: %s", topic)
 		}
@@ -153,6 +209,37 @@ func (s *Service) writeToTimescaleDBWithRetry(query string, args ...interface{})
 	return nil
 }
 
+// inTransaction runs fn in a transaction that is committed when fn succeeds
+// and rolled back otherwise.
+func (s *Service) inTransaction(timeout time.Duration, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	tx, err := s.timescaleClient.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// retryBackoff returns the delay before the next attempt, doubling from one
+// second for every failed attempt up to max.
+func retryBackoff(attempts int, max time.Duration) time.Duration {
+	backoff := time.Second
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	return min(backoff, max)
+}
+
 func (s *Service) isDuplicateRecord(tableName, serialNumber string, timestamp time.Time) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
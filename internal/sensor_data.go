@@ -1,32 +1,37 @@
 package internal
 
 import (
-	"log"
+	"context"
+	"database/sql"
 	"fmt"
+	"log"
 	"time"
 	"strings"
 	"encoding/json"
-	
-	"medical-gas-transport-service/internal/services"
 
-	"github.com/lib/pq"
-	"github.com/redis/go-redis/v9"
+	"medical-gas-transport-service/internal/clock"
+	"medical-gas-transport-service/internal/services"
 )
 
-func (s *Service) HandleSensorData(topic string, payload []byte) {
+func (s *Service) HandleSensorData(topic string, payload []byte, receivedAt time.Time) {
+	if isBatchPayload(payload) {
+		s.handleSensorBatch(topic, payload, receivedAt)
+		return
+	}
+
 	switch {
 		case strings.HasSuffix(topic, "/level"):
-			s.handleSensorLevel(topic, payload)
+			s.handleSensorLevel(topic, payload, receivedAt)
 		case strings.HasSuffix(topic, "/flow"):
-			s.handleSensorFlow(topic, payload)
+			s.handleSensorFlow(topic, payload, receivedAt)
 		case strings.HasSuffix(topic, "/pressure"):
-			s.handleSensorPressure(topic, payload)
+			s.handleSensorPressure(topic, payload, receivedAt)
 		default:
 			log.Printf("Unknown topic: %s", topic)
 	}	
 }
 
-func (s *Service) handleSensorLevel(topic string, payload []byte) {
+func (s *Service) handleSensorLevel(topic string, payload []byte, receivedAt time.Time) {
 	serialNumber, err := extractSerialNumberFromTopic(topic)
 	if err != nil {
 		log.Printf("Error extracting serial number: %v", err)
@@ -42,7 +47,14 @@ func (s *Service) handleSensorLevel(topic string, payload []byte) {
 	if device == nil {
 		log.Printf("Device not found for serial number: %s", serialNumber)
 		return
-	}		
+	}
+
+	s.touchDevice(serialNumber, "level")
+
+	offset := s.observeClock(serialNumber, payload, receivedAt)
+	if !s.validateReading(serialNumber, topic, "level", payload, receivedAt.Add(offset)) {
+		return
+	}
 
 	var levelData SensorLevelData
 	if err := json.Unmarshal(payload, &levelData); err != nil {
@@ -50,13 +62,23 @@ func (s *Service) handleSensorLevel(topic string, payload []byte) {
 		return
 	}
 
+	levelData.ReceivedAt = receivedAt
+	levelData.ClockOffset = offset
+	s.processSensorLevel(serialNumber, device, levelData)
+}
+
+// processSensorLevel stores a live level reading and publishes it.
+func (s *Service) processSensorLevel(serialNumber string, device *services.Device, levelData SensorLevelData) {
 	if levelData.Level < 0 {
 		log.Printf("Invalid level data: %v", levelData.Level)
 		return
 	}
 
 	levelData.SerialNumber = serialNumber
-	levelData.Timestamp = time.Unix(levelData.Ts, 0)
+	levelData.DeviceTime = clock.FromUnix(levelData.Ts, levelData.TsUnit)
+	levelData.Timestamp = levelData.DeviceTime.Add(-levelData.ClockOffset)
+
+	s.trackInventory(serialNumber, "level", device, levelData.Device, levelData.Timestamp)
 
 	if s.isDuplicateRecord("sensor_level", serialNumber, levelData.Timestamp) {
 		log.Printf("Duplicate record detected for device %s at %v, skipping", serialNumber, levelData.Timestamp)
@@ -68,74 +90,27 @@ func (s *Service) handleSensorLevel(topic string, payload []byte) {
 		return
 	}
 
-	conversionTable, err := s.getConversionTableWithCache(serialNumber)
-	if err != nil {
+	var LevelInKilograms, LevelInMetersCubics *float64
+	var conversionError string
+	kg, m3, err := s.convertLevel(serialNumber, device, levelData.Level)
+	switch {
+	case err == nil:
+		LevelInKilograms, LevelInMetersCubics = &kg, &m3
+	case isConversionError(err):
+		conversionError = err.Error()
+		log.Printf("Storing level for device %s without conversion: %v", serialNumber, err)
+	default:
 		log.Printf("Error getting conversion table: %v", err)
 		return
 	}
 
-	slope := 42.84814815
-	intercept := -267.5185185
-	kgToMetersCubics := 1.29
-
-	for i := 0; i < len(conversionTable)-1; i++ {
-		if (conversionTable[i].InH2OMin <= levelData.Level) && (levelData.Level <= conversionTable[i].InH2OMax) {
-			slope = conversionTable[i].Slope
-			intercept = conversionTable[i].Intercept
-			break
-		}
-	}
-
-	var LevelInKilograms, LevelInMetersCubics float64
-	if levelData.Level == 0 {
-		LevelInKilograms = 0
-		LevelInMetersCubics = 0
-	} else {
-		LevelInKilograms = (levelData.Level * slope) + intercept
-		LevelInMetersCubics = LevelInKilograms / kgToMetersCubics
-	}
-
-	query := `
-		INSERT INTO sensor_level (
-			time, serial_number, level, level_kg, level_meter_cubic, device_uptime, device_temp, 
-			device_hum, device_long, device_lat, device_rssi, device_hw_ver, device_fw_ver, 
-			device_rd_ver, device_model, device_mem_usage, device_reset_reason, solar_batt_temp, 
-			solar_batt_level, solar_batt_volt, solar_batt_status, solar_device_status, 
-			solar_load_status, solar_e_gen, solar_e_com
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25)
-		ON CONFLICT (time, serial_number) DO NOTHING
-	`
-
-	err = s.writeToTimescaleDBWithRetry(query,
-		levelData.Timestamp,
-		levelData.SerialNumber,
-		levelData.Level,
-		LevelInKilograms,
-		LevelInMetersCubics,
-		levelData.Device.DeviceUptime,
-		levelData.Device.DeviceTemp,
-		levelData.Device.DeviceHum,
-		levelData.Device.DeviceLong,
-		levelData.Device.DeviceLat,
-		levelData.Device.DeviceRSSI,
-		levelData.Device.DeviceHWVer,
-		levelData.Device.DeviceFWVer,
-		levelData.Device.DeviceRDVer,
-		levelData.Device.DeviceModel,
-		levelData.Device.DeviceMemUsage,
-		levelData.Device.DeviceResetReason,
-		levelData.Solar.SolarBattTemp,
-		levelData.Solar.SolarBattLevel,
-		levelData.Solar.SolarBattVolt,
-		pq.Array(levelData.Solar.SolarBattStatus),
-		pq.Array(levelData.Solar.SolarDeviceStatus),
-		pq.Array(levelData.Solar.SolarLoadStatus),
-		pq.Array(levelData.Solar.SolarEGen),
-		pq.Array(levelData.Solar.SolarECom),
-	)
+	query := insertStatement("sensor_level", levelColumns, 1)
 
+	power := levelData.power()
 	redisData := map[string]interface{}{
 		"timestamp":        levelData.Timestamp,
+		"device_time":      levelData.DeviceTime,
+		"received_at":      levelData.ReceivedAt,
 		"serial_number":    levelData.SerialNumber,
 		"level":           levelData.Level,
 		"level_kg":        LevelInKilograms,
@@ -152,33 +127,55 @@ func (s *Service) handleSensorLevel(topic string, payload []byte) {
 		"device_model":    levelData.Device.DeviceModel,
 		"device_mem_usage": levelData.Device.DeviceMemUsage,
 		"device_reset_reason": levelData.Device.DeviceResetReason,
-		"solar_batt_temp": levelData.Solar.SolarBattTemp,
-		"solar_batt_level": levelData.Solar.SolarBattLevel,
-		"solar_batt_volt": levelData.Solar.SolarBattVolt,
-		"solar_batt_status": levelData.Solar.SolarBattStatus,
-		"solar_device_status": levelData.Solar.SolarDeviceStatus,
-		"solar_load_status": levelData.Solar.SolarLoadStatus,
-		"solar_e_gen":     levelData.Solar.SolarEGen,
-		"solar_e_com":     levelData.Solar.SolarECom,
+		"solar_batt_temp": power.SolarBattTemp,
+		"solar_batt_level": power.SolarBattLevel,
+		"solar_batt_volt": power.SolarBattVolt,
+		"solar_batt_status": power.SolarBattStatus,
+		"solar_device_status": power.SolarDeviceStatus,
+		"solar_load_status": power.SolarLoadStatus,
+		"solar_e_gen":     power.SolarEGen,
+		"solar_e_com":     power.SolarECom,
+	}
+	if conversionError != "" {
+		redisData["conversion_error"] = conversionError
 	}
 
-	if err != nil {
-		log.Printf("Error writing sensor level data to TimescaleDB: %v", err)
-		return
+	var summary SolarSummary
+	if levelData.Solar != nil {
+		summary = s.solarSummary(levelData)
+		redisData["solar"] = summary
 	}
 
-	// Only publish if insert was successful
 	event := map[string]interface{}{
 		"serial_number"	: serialNumber,
+		"hospital"			: deviceHospital(device),
 		"data"					: redisData,
 	}
-	if eventJSON, err := json.Marshal(event); err == nil {
-		s.redisClient.Rdb.Publish(s.ctx, "sensor:level", eventJSON)
-		log.Printf("Successfully stored and published sensor level data for device %s", serialNumber)
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling sensor level event: %v", err)
+		return
+	}
+
+	inserted, err := s.writeWithOutbox(s.eventMessages("sensor:level", eventJSON), query,
+		levelRow(levelData, LevelInKilograms, LevelInMetersCubics, false)...)
+
+	if err != nil {
+		log.Printf("Error writing sensor level data to TimescaleDB: %v", err)
+		return
+	}
+	if !inserted {
+		log.Printf("Duplicate record detected for device %s at %v, skipping", serialNumber, levelData.Timestamp)
+		return
 	}
+
+	s.trackDeviceHealth(serialNumber, levelData.Timestamp, levelData.Device)
+	s.trackSolar(levelData, summary)
+	s.storeLatestReading(serialNumber, deviceHospital(device), "level", levelData.Timestamp, eventJSON)
+	log.Printf("Successfully stored and published sensor level data for device %s", serialNumber)
 }
 
-func (s *Service) handleSensorFlow(topic string, payload []byte) {
+func (s *Service) handleSensorFlow(topic string, payload []byte, receivedAt time.Time) {
 	serialNumber, err := extractSerialNumberFromTopic(topic)
 	if err != nil {
 		log.Printf("Error extracting serial number: %v", err)
@@ -196,14 +193,32 @@ func (s *Service) handleSensorFlow(topic string, payload []byte) {
 		return
 	}
 
+	s.touchDevice(serialNumber, "flow")
+
+	offset := s.observeClock(serialNumber, payload, receivedAt)
+	if !s.validateReading(serialNumber, topic, "flow", payload, receivedAt.Add(offset)) {
+		return
+	}
+
 	var flowData SensorFlowData
 	if err := json.Unmarshal(payload, &flowData); err != nil {
 		log.Printf("Error unmarshaling sensor flow data: %v", err)
 		return
 	}
 
+	flowData.ReceivedAt = receivedAt
+	flowData.ClockOffset = offset
+	s.processSensorFlow(serialNumber, device, flowData)
+}
+
+// processSensorFlow stores a live flow reading, advances the totalizer and
+// publishes it.
+func (s *Service) processSensorFlow(serialNumber string, device *services.Device, flowData SensorFlowData) {
 	flowData.SerialNumber = serialNumber
-	flowData.Timestamp = time.Unix(flowData.Ts, 0)
+	flowData.DeviceTime = clock.FromUnix(flowData.Ts, flowData.TsUnit)
+	flowData.Timestamp = flowData.DeviceTime.Add(-flowData.ClockOffset)
+
+	s.trackInventory(serialNumber, "flow", device, flowData.Device, flowData.Timestamp)
 
 	// Check for duplicate before processing
 	if s.isDuplicateRecord("sensor_flow", serialNumber, flowData.Timestamp) {
@@ -213,57 +228,48 @@ func (s *Service) handleSensorFlow(topic string, payload []byte) {
 
 	totalVolume := (flowData.VHi * 65536) + (flowData.VLo) + (flowData.VDec / 1000)
 	flowRate := ((flowData.FRateHi * 65536) + flowData.FRateLo) / 1000
+	flowData.TotalVolume = totalVolume
+	flowData.FlowRate = flowRate
+
+	var eventJSON []byte
+	inserted, err := s.writeWithOutboxTx(func(ctx context.Context, tx *sql.Tx) ([]outboxMessage, bool, error) {
+		inserted, err := s.insertFlowReading(ctx, tx, device, &flowData, false)
+		if err != nil || !inserted {
+			return nil, false, err
+		}
 
-	query := `
-		INSERT INTO sensor_flow (
-			time, serial_number, total_volume, volume_high, volume_low, volume_decimal,
-			flow_rate, flow_rate_high, flow_rate_low, device_uptime, device_temp, 
-			device_hum, device_long, device_lat, device_rssi, device_hw_ver, device_fw_ver, 
-			device_rd_ver, device_model, device_reset_reason
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
-		ON CONFLICT (time, serial_number) DO NOTHING
-	`
-
-	err = s.writeToTimescaleDBWithRetry(query,
-		flowData.Timestamp,
-		flowData.SerialNumber,
-		totalVolume,
-		flowData.VHi,
-		flowData.VLo,
-		flowData.VDec,
-		flowRate,
-		flowData.FRateHi,
-		flowData.FRateLo,
-		flowData.Device.DeviceUptime,
-		flowData.Device.DeviceTemp,
-		flowData.Device.DeviceHum,
-		flowData.Device.DeviceLong,
-		flowData.Device.DeviceLat,
-		flowData.Device.DeviceRSSI,
-		flowData.Device.DeviceHWVer,
-		flowData.Device.DeviceFWVer,
-		flowData.Device.DeviceRDVer,
-		flowData.Device.DeviceModel,
-		flowData.Device.DeviceResetReason,
-	)
+		event := map[string]interface{}{
+			"serial_number"	: serialNumber,
+			"hospital"			: deviceHospital(device),
+			"data"					: flowData,
+			"total_volume"		: flowData.TotalVolume,
+			"flow_rate"				: flowData.FlowRate,
+			"cumulative_volume": flowData.CumulativeVolume,
+			"delta_volume"		: flowData.DeltaVolume,
+			"device_time"			: flowData.DeviceTime,
+			"received_at"			: flowData.ReceivedAt,
+		}
+		if eventJSON, err = json.Marshal(event); err != nil {
+			return nil, false, fmt.Errorf("error marshaling sensor flow event: %w", err)
+		}
+		return s.eventMessages("sensor:flow", eventJSON), true, nil
+	})
 
 	if err != nil {
 		log.Printf("Error writing sensor flow data to TimescaleDB: %v", err)
 		return
 	}
-
-	// Only publish if insert was successful
-	event := map[string]interface{}{
-		"serial_number"	: serialNumber,
-		"data"					: flowData,
-	}
-	if eventJSON, err := json.Marshal(event); err == nil {
-		s.redisClient.Rdb.Publish(s.ctx, "sensor:flow", eventJSON)
-		log.Printf("Successfully stored and published sensor flow data for device %s", serialNumber)
+	if !inserted {
+		log.Printf("Duplicate record detected for device %s at %v, skipping", serialNumber, flowData.Timestamp)
+		return
 	}
+
+	s.trackDeviceHealth(serialNumber, flowData.Timestamp, flowData.Device)
+	s.storeLatestReading(serialNumber, deviceHospital(device), "flow", flowData.Timestamp, eventJSON)
+	log.Printf("Successfully stored and published sensor flow data for device %s", serialNumber)
 }
 
-func (s *Service) handleSensorPressure(topic string, payload []byte) {
+func (s *Service) handleSensorPressure(topic string, payload []byte, receivedAt time.Time) {
 	serialNumber, err := extractSerialNumberFromTopic(topic)
 	if err != nil {
 		log.Printf("Error extracting serial number: %v", err)
@@ -281,14 +287,31 @@ func (s *Service) handleSensorPressure(topic string, payload []byte) {
 		return
 	}
 
+	s.touchDevice(serialNumber, "pressure")
+
+	offset := s.observeClock(serialNumber, payload, receivedAt)
+	if !s.validateReading(serialNumber, topic, "pressure", payload, receivedAt.Add(offset)) {
+		return
+	}
+
 	var pressureData SensorPressureData
 	if err := json.Unmarshal(payload, &pressureData); err != nil {
 		log.Printf("Error unmarshaling sensor pressure data: %v", err)
 		return
 	}
 
+	pressureData.ReceivedAt = receivedAt
+	pressureData.ClockOffset = offset
+	s.processSensorPressure(serialNumber, device, pressureData)
+}
+
+// processSensorPressure stores a live pressure reading and publishes it.
+func (s *Service) processSensorPressure(serialNumber string, device *services.Device, pressureData SensorPressureData) {
 	pressureData.SerialNumber = serialNumber
-	pressureData.Timestamp = time.Unix(pressureData.Ts, 0)
+	pressureData.DeviceTime = clock.FromUnix(pressureData.Ts, pressureData.TsUnit)
+	pressureData.Timestamp = pressureData.DeviceTime.Add(-pressureData.ClockOffset)
+
+	s.trackInventory(serialNumber, "pressure", device, pressureData.Device, pressureData.Timestamp)
 
 	// Check for duplicate before processing
 	if s.isDuplicateRecord("sensor_pressure", serialNumber, pressureData.Timestamp) {
@@ -296,161 +319,36 @@ func (s *Service) handleSensorPressure(topic string, payload []byte) {
 		return
 	}
 
-	var (
-		nitrousOxidePressure, nitrousOxideHighLimit, nitrousOxideLowLimit                float64
-		oxygenPressure, oxygenHighLimit, oxygenLowLimit                                  float64
-		medicalAirPressure, medicalAirHighLimit, medicalAirLowLimit                      float64
-		vacuumPressure, vacuumHighLimit, vacuumLowLimit                                  float64
-		nitrousOxideConnection, oxygenConnection, medicalAirConnection, vacuumConnection int
-		nitrousOxideEnable, oxygenEnable, medicalAirEnable, vacuumEnable                 bool
-	)
-
-	for _, data := range pressureData.Data {
-		switch data.Measurement {
-		case "nitrous oxide":
-			nitrousOxidePressure = data.Value
-			nitrousOxideConnection = data.Connection
-			nitrousOxideEnable = data.Enable
-			nitrousOxideHighLimit = data.HighLimit
-			nitrousOxideLowLimit = data.LowLimit
-		case "oxygen":
-			oxygenPressure = data.Value
-			oxygenConnection = data.Connection
-			oxygenEnable = data.Enable
-			oxygenHighLimit = data.HighLimit
-			oxygenLowLimit = data.LowLimit
-		case "medical air":
-			medicalAirPressure = data.Value
-			medicalAirConnection = data.Connection
-			medicalAirEnable = data.Enable
-			medicalAirHighLimit = data.HighLimit
-			medicalAirLowLimit = data.LowLimit
-		case "vacuum":
-			vacuumPressure = data.Value
-			vacuumConnection = data.Connection
-			vacuumEnable = data.Enable
-			vacuumHighLimit = data.HighLimit
-			vacuumLowLimit = data.LowLimit
-		}
-	}
+	s.reportPressureLimits(serialNumber, pressureData.Data)
 
-	query := `
-		INSERT INTO sensor_pressure (
-			time, serial_number, 
-			nitrous_oxide_value, nitrous_oxide_connection, nitrous_oxide_enable, 
-			nitrous_oxide_high_limit, nitrous_oxide_low_limit,
-			oxygen_value, oxygen_connection, oxygen_enable, 
-			oxygen_high_limit, oxygen_low_limit,
-			medical_air_value, medical_air_connection, medical_air_enable, 
-			medical_air_high_limit, medical_air_low_limit,
-			vacuum_value, vacuum_connection, vacuum_enable, 
-			vacuum_high_limit, vacuum_low_limit,
-			device_uptime, device_temp, device_hum, device_long, device_lat, 
-			device_rssi, device_hw_ver, device_fw_ver, device_rd_ver, device_model, device_reset_reason
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, $33)
-		ON CONFLICT (time, serial_number) DO NOTHING
-	`
-
-	err = s.writeToTimescaleDBWithRetry(query,
-		pressureData.Timestamp,
-		pressureData.SerialNumber,
-		nitrousOxidePressure,
-		nitrousOxideConnection,
-		nitrousOxideEnable,
-		nitrousOxideHighLimit,
-		nitrousOxideLowLimit,
-		oxygenPressure,
-		oxygenConnection,
-		oxygenEnable,
-		oxygenHighLimit,
-		oxygenLowLimit,
-		medicalAirPressure,
-		medicalAirConnection,
-		medicalAirEnable,
-		medicalAirHighLimit,
-		medicalAirLowLimit,
-		vacuumPressure,
-		vacuumConnection,
-		vacuumEnable,
-		vacuumHighLimit,
-		vacuumLowLimit,
-		pressureData.Device.DeviceUptime,
-		pressureData.Device.DeviceTemp,
-		pressureData.Device.DeviceHum,
-		pressureData.Device.DeviceLong,
-		pressureData.Device.DeviceLat,
-		pressureData.Device.DeviceRSSI,
-		pressureData.Device.DeviceHWVer,
-		pressureData.Device.DeviceFWVer,
-		pressureData.Device.DeviceRDVer,
-		pressureData.Device.DeviceModel,
-		pressureData.Device.DeviceResetReason,
-	)
-
-	if err != nil {
-		log.Printf("Error writing sensor pressure data to TimescaleDB: %v", err)
-		return
-	}
+	query := insertStatement("sensor_pressure", pressureColumns, 1)
 
-	// Only publish if insert was successful
 	event := map[string]interface{}{
 		"serial_number"	: serialNumber,
+		"hospital"			: deviceHospital(device),
 		"data"					: pressureData,
+		"device_time"		: pressureData.DeviceTime,
+		"received_at"		: pressureData.ReceivedAt,
 	}
-	if eventJSON, err := json.Marshal(event); err == nil {
-		s.redisClient.Rdb.Publish(s.ctx, "sensor:pressure", eventJSON)
-		log.Printf("Successfully stored and published sensor pressure data for device %s", serialNumber)
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling sensor pressure event: %v", err)
+		return
 	}
-}
 
-func (s *Service) getDeviceFromCacheOrService(serialNumber string) (*services.Device, error) {
-	result, err := s.redisClient.Rdb.Get(s.ctx, "device/"+serialNumber).Result()
-	if err == redis.Nil {
-		device, err := s.jayaClient.GetDevice(serialNumber)
-		if err != nil {
-			log.Printf("Error getting device from service for serial %s: %v", serialNumber, err)
-			return nil, fmt.Errorf("error getting device from service: %w", err)
-		}
-		log.Printf("Device not found in cache, fetched from service: %s", serialNumber)
+	inserted, err := s.writeWithOutbox(s.eventMessages("sensor:pressure", eventJSON), query,
+		pressureRow(pressureData, false)...)
 
-		if jsonDevice, err := json.Marshal(device); err == nil {
-			s.redisClient.Rdb.Set(s.ctx, "device/"+serialNumber, jsonDevice, 3*time.Hour)
-		}
-		return device, nil
-	} else if err != nil {
-		return nil, fmt.Errorf("error getting device from Redis: %w", err)
+	if err != nil {
+		log.Printf("Error writing sensor pressure data to TimescaleDB: %v", err)
+		return
 	}
-
-	var device services.Device
-	if err := json.Unmarshal([]byte(result), &device); err != nil {
-		return nil, fmt.Errorf("error parsing device JSON: %w", err)
+	if !inserted {
+		log.Printf("Duplicate record detected for device %s at %v, skipping", serialNumber, pressureData.Timestamp)
+		return
 	}
 
-	return &device, nil
+	s.trackDeviceHealth(serialNumber, pressureData.Timestamp, pressureData.Device)
+	s.storeLatestReading(serialNumber, deviceHospital(device), "pressure", pressureData.Timestamp, eventJSON)
+	log.Printf("Successfully stored and published sensor pressure data for device %s", serialNumber)
 }
-
-func (s *Service) getConversionTableWithCache(serialNumber string) ([]services.TankConversion, error) {
-	cacheKey := "conversion_table/" + serialNumber
-	result, err := s.redisClient.Rdb.Get(s.ctx, cacheKey).Result()
-	if err == redis.Nil {
-		table, err := s.jayaClient.GetConversionTable(serialNumber)
-		if err != nil {
-			return nil, fmt.Errorf("error getting conversion table from service: %w", err)
-		}
-		log.Printf("Conversion table not found in cache, fetched from service: %s", serialNumber)
-
-		if jsonTable, err := json.Marshal(table); err == nil {
-			s.redisClient.Rdb.Set(s.ctx, cacheKey, jsonTable, 3*time.Hour)
-		}
-		return table, nil
-	} else if err != nil {
-		return nil, fmt.Errorf("error getting conversion table from Redis: %w", err)
-	}
-
-	var table []services.TankConversion
-	if err := json.Unmarshal([]byte(result), &table); err != nil {
-		return nil, fmt.Errorf("error parsing conversion table JSON: %w", err)
-	}
-
-	return table, nil
-}
\ No newline at end of file
@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var levelColumns = []string{
+	"time", "serial_number", "level", "level_kg", "level_meter_cubic", "device_uptime", "device_temp",
+	"device_hum", "device_long", "device_lat", "device_rssi", "device_hw_ver", "device_fw_ver",
+	"device_rd_ver", "device_model", "device_mem_usage", "device_reset_reason", "solar_batt_temp",
+	"solar_batt_level", "solar_batt_volt", "solar_batt_status", "solar_device_status",
+	"solar_load_status", "solar_e_gen", "solar_e_com", "backfilled", "device_time", "received_at",
+}
+
+var flowColumns = []string{
+	"time", "serial_number", "total_volume", "volume_high", "volume_low", "volume_decimal",
+	"flow_rate", "flow_rate_high", "flow_rate_low", "device_uptime", "device_temp",
+	"device_hum", "device_long", "device_lat", "device_rssi", "device_hw_ver", "device_fw_ver",
+	"device_rd_ver", "device_model", "device_reset_reason", "cumulative_volume", "delta_volume", "backfilled",
+	"device_time", "received_at",
+}
+
+var pressureColumns = []string{
+	"time", "serial_number",
+	"nitrous_oxide_value", "nitrous_oxide_connection", "nitrous_oxide_enable",
+	"nitrous_oxide_high_limit", "nitrous_oxide_low_limit",
+	"oxygen_value", "oxygen_connection", "oxygen_enable",
+	"oxygen_high_limit", "oxygen_low_limit",
+	"medical_air_value", "medical_air_connection", "medical_air_enable",
+	"medical_air_high_limit", "medical_air_low_limit",
+	"vacuum_value", "vacuum_connection", "vacuum_enable",
+	"vacuum_high_limit", "vacuum_low_limit",
+	"device_uptime", "device_temp", "device_hum", "device_long", "device_lat",
+	"device_rssi", "device_hw_ver", "device_fw_ver", "device_rd_ver", "device_model", "device_reset_reason",
+	"backfilled", "device_time", "received_at",
+}
+
+// insertStatement builds an insert of rows readings into a sensor table that
+// skips readings already stored for the same time and device.
+func insertStatement(table string, columns []string, rows int) string {
+	values := make([]string, rows)
+	for r := range values {
+		params := make([]string, len(columns))
+		for c := range params {
+			params[c] = fmt.Sprintf("$%d", r*len(columns)+c+1)
+		}
+		values[r] = "(" + strings.Join(params, ", ") + ")"
+	}
+	return fmt.Sprintf(`
+		INSERT INTO %s (%s)
+		VALUES %s
+		ON CONFLICT (time, serial_number) DO NOTHING
+	`, table, strings.Join(columns, ", "), strings.Join(values, ", "))
+}
+
+func levelRow(levelData SensorLevelData, kg, m3 *float64, backfilled bool) []interface{} {
+	power := levelData.power()
+	return []interface{}{
+		levelData.Timestamp,
+		levelData.SerialNumber,
+		levelData.Level,
+		kg,
+		m3,
+		levelData.Device.DeviceUptime,
+		levelData.Device.DeviceTemp,
+		levelData.Device.DeviceHum,
+		levelData.Device.DeviceLong,
+		levelData.Device.DeviceLat,
+		levelData.Device.DeviceRSSI,
+		levelData.Device.DeviceHWVer,
+		levelData.Device.DeviceFWVer,
+		levelData.Device.DeviceRDVer,
+		levelData.Device.DeviceModel,
+		levelData.Device.DeviceMemUsage,
+		levelData.Device.DeviceResetReason,
+		power.SolarBattTemp,
+		power.SolarBattLevel,
+		power.SolarBattVolt,
+		pq.Array(power.SolarBattStatus),
+		pq.Array(power.SolarDeviceStatus),
+		pq.Array(power.SolarLoadStatus),
+		pq.Array(power.SolarEGen),
+		pq.Array(power.SolarECom),
+		backfilled,
+		levelData.DeviceTime,
+		levelData.ReceivedAt,
+	}
+}
+
+func flowRow(flowData SensorFlowData, backfilled bool) []interface{} {
+	return []interface{}{
+		flowData.Timestamp,
+		flowData.SerialNumber,
+		flowData.TotalVolume,
+		flowData.VHi,
+		flowData.VLo,
+		flowData.VDec,
+		flowData.FlowRate,
+		flowData.FRateHi,
+		flowData.FRateLo,
+		flowData.Device.DeviceUptime,
+		flowData.Device.DeviceTemp,
+		flowData.Device.DeviceHum,
+		flowData.Device.DeviceLong,
+		flowData.Device.DeviceLat,
+		flowData.Device.DeviceRSSI,
+		flowData.Device.DeviceHWVer,
+		flowData.Device.DeviceFWVer,
+		flowData.Device.DeviceRDVer,
+		flowData.Device.DeviceModel,
+		flowData.Device.DeviceResetReason,
+		flowData.CumulativeVolume,
+		flowData.DeltaVolume,
+		backfilled,
+		flowData.DeviceTime,
+		flowData.ReceivedAt,
+	}
+}
+
+func pressureRow(pressureData SensorPressureData, backfilled bool) []interface{} {
+	var (
+		nitrousOxidePressure, nitrousOxideHighLimit, nitrousOxideLowLimit                float64
+		oxygenPressure, oxygenHighLimit, oxygenLowLimit                                  float64
+		medicalAirPressure, medicalAirHighLimit, medicalAirLowLimit                      float64
+		vacuumPressure, vacuumHighLimit, vacuumLowLimit                                  float64
+		nitrousOxideConnection, oxygenConnection, medicalAirConnection, vacuumConnection int
+		nitrousOxideEnable, oxygenEnable, medicalAirEnable, vacuumEnable                 bool
+	)
+
+	for _, data := range pressureData.Data {
+		switch data.Measurement {
+		case "nitrous oxide":
+			nitrousOxidePressure = data.Value
+			nitrousOxideConnection = data.Connection
+			nitrousOxideEnable = data.Enable
+			nitrousOxideHighLimit = data.HighLimit
+			nitrousOxideLowLimit = data.LowLimit
+		case "oxygen":
+			oxygenPressure = data.Value
+			oxygenConnection = data.Connection
+			oxygenEnable = data.Enable
+			oxygenHighLimit = data.HighLimit
+			oxygenLowLimit = data.LowLimit
+		case "medical air":
+			medicalAirPressure = data.Value
+			medicalAirConnection = data.Connection
+			medicalAirEnable = data.Enable
+			medicalAirHighLimit = data.HighLimit
+			medicalAirLowLimit = data.LowLimit
+		case "vacuum":
+			vacuumPressure = data.Value
+			vacuumConnection = data.Connection
+			vacuumEnable = data.Enable
+			vacuumHighLimit = data.HighLimit
+			vacuumLowLimit = data.LowLimit
+		}
+	}
+
+	return []interface{}{
+		pressureData.Timestamp,
+		pressureData.SerialNumber,
+		nitrousOxidePressure,
+		nitrousOxideConnection,
+		nitrousOxideEnable,
+		nitrousOxideHighLimit,
+		nitrousOxideLowLimit,
+		oxygenPressure,
+		oxygenConnection,
+		oxygenEnable,
+		oxygenHighLimit,
+		oxygenLowLimit,
+		medicalAirPressure,
+		medicalAirConnection,
+		medicalAirEnable,
+		medicalAirHighLimit,
+		medicalAirLowLimit,
+		vacuumPressure,
+		vacuumConnection,
+		vacuumEnable,
+		vacuumHighLimit,
+		vacuumLowLimit,
+		pressureData.Device.DeviceUptime,
+		pressureData.Device.DeviceTemp,
+		pressureData.Device.DeviceHum,
+		pressureData.Device.DeviceLong,
+		pressureData.Device.DeviceLat,
+		pressureData.Device.DeviceRSSI,
+		pressureData.Device.DeviceHWVer,
+		pressureData.Device.DeviceFWVer,
+		pressureData.Device.DeviceRDVer,
+		pressureData.Device.DeviceModel,
+		pressureData.Device.DeviceResetReason,
+		backfilled,
+		pressureData.DeviceTime,
+		pressureData.ReceivedAt,
+	}
+}
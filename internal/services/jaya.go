@@ -77,6 +77,7 @@ type InstallationPointTank struct {
     InstalledAt           string   `json:"installed_at"`
     Device                string   `json:"device"`
     DeviceThreshold       string   `json:"device_threshold"`
+    GasType               string   `json:"gas_type"`
 }
 
 type InstallationPointPressure struct {
@@ -207,6 +208,7 @@ func (j *Jaya) GetDevice(serialNumber string) (*Device, error) {
             InstalledAt:           getString(iptData, "installed_at"),
             Device:                getString(iptData, "device"),
             DeviceThreshold:       getString(iptData, "device_threshold"),
+            GasType:               getString(iptData, "gas_type"),
         }
     }
 
@@ -289,6 +291,58 @@ func (j *Jaya) Provision(id string) (*JayaProvisionResponse, error) {
 	return result, nil
 }
 
+// RotateCredentials issues a new username and password for a device while
+// its current credentials stay valid until they are revoked.
+func (j *Jaya) RotateCredentials(id string) (*JayaProvisionResponse, error) {
+	resp, err := j.client.R().SetResult(JayaProvisionResponse{}).Post("/provisioning/" + id + "/rotate")
+	if err != nil {
+		return nil, fmt.Errorf("error when request credential rotation %s from jaya core. error: %w", id, err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, errors.New(string(resp.Body()))
+	}
+
+	result := resp.Result().(*JayaProvisionResponse)
+	return result, nil
+}
+
+// GetCredentials returns the username a device currently holds, for devices
+// provisioned before the service kept track of it. The password is not
+// returned.
+func (j *Jaya) GetCredentials(id string) (*JayaProvisionResponse, error) {
+	resp, err := j.client.R().SetResult(JayaProvisionResponse{}).Get("/provisioning/" + id)
+	if err != nil {
+		return nil, fmt.Errorf("error when request credentials %s from jaya core. error: %w", id, err)
+	}
+
+	if resp.StatusCode() == 404 {
+		return nil, ErrDeviceNotFound
+	}
+	if resp.StatusCode() != 200 {
+		return nil, errors.New(string(resp.Body()))
+	}
+
+	result := resp.Result().(*JayaProvisionResponse)
+	return result, nil
+}
+
+// RevokeCredentials revokes one username issued to a device.
+func (j *Jaya) RevokeCredentials(id string, username string) error {
+	var body interface{} = map[string]interface{}{"username": username}
+
+	resp, err := j.client.R().SetBody(body).Post("/provisioning/" + id + "/revoke")
+	if err != nil {
+		return fmt.Errorf("error when request credential revocation %s from jaya core. error: %w", id, err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return errors.New(string(resp.Body()))
+	}
+
+	return nil
+}
+
 func getInt(data map[string]interface{}, key string) int {
     if val, ok := data[key]; ok {
         switch v := val.(type) {
@@ -0,0 +1,326 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// DeviceShadow holds the configuration operators want a device to run with
+// (desired) next to the configuration the device last confirmed (reported).
+type DeviceShadow struct {
+	SerialNumber    string                 `json:"serial_number"`
+	Desired         map[string]interface{} `json:"desired"`
+	Reported        map[string]interface{} `json:"reported"`
+	Delta           map[string]interface{} `json:"delta"`
+	Version         int                    `json:"version"`
+	ReportedVersion int                    `json:"reported_version"`
+	UpdatedAt       *time.Time             `json:"updated_at"`
+	ReportedAt      *time.Time             `json:"reported_at"`
+}
+
+// ConfigCommand is published on JI/v2/<sn>/config.
+type ConfigCommand struct {
+	Version int                    `json:"version"`
+	State   map[string]interface{} `json:"state"`
+}
+
+// ConfigAck is published by devices on JI/v2/<sn>/config-ack after applying
+// a configuration.
+type ConfigAck struct {
+	Version  int                    `json:"version"`
+	Reported map[string]interface{} `json:"reported"`
+}
+
+// mergePatch applies an RFC 7386 JSON merge patch: nested objects merge,
+// null values delete keys, anything else replaces.
+func mergePatch(dst, patch map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = make(map[string]interface{})
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		if pv, ok := v.(map[string]interface{}); ok {
+			dv, _ := dst[k].(map[string]interface{})
+			dst[k] = mergePatch(dv, pv)
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// shadowDelta returns the desired settings the device has not reported yet.
+func shadowDelta(desired, reported map[string]interface{}) map[string]interface{} {
+	delta := make(map[string]interface{})
+	for k, dv := range desired {
+		rv, ok := reported[k]
+		dm, dIsMap := dv.(map[string]interface{})
+		rm, rIsMap := rv.(map[string]interface{})
+		switch {
+		case dIsMap && rIsMap:
+			if sub := shadowDelta(dm, rm); len(sub) > 0 {
+				delta[k] = sub
+			}
+		case !ok || !reflect.DeepEqual(dv, rv):
+			delta[k] = dv
+		}
+	}
+	return delta
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanShadow(row rowScanner, serialNumber string) (*DeviceShadow, error) {
+	shadow := &DeviceShadow{SerialNumber: serialNumber}
+	var desired, reported []byte
+	err := row.Scan(&desired, &reported, &shadow.Version, &shadow.ReportedVersion, &shadow.UpdatedAt, &shadow.ReportedAt)
+	if err == sql.ErrNoRows {
+		shadow.Desired = map[string]interface{}{}
+		shadow.Reported = map[string]interface{}{}
+		shadow.Delta = map[string]interface{}{}
+		return shadow, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(desired, &shadow.Desired); err != nil {
+		return nil, fmt.Errorf("error parsing desired state: %w", err)
+	}
+	if err := json.Unmarshal(reported, &shadow.Reported); err != nil {
+		return nil, fmt.Errorf("error parsing reported state: %w", err)
+	}
+	if shadow.Desired == nil {
+		shadow.Desired = map[string]interface{}{}
+	}
+	if shadow.Reported == nil {
+		shadow.Reported = map[string]interface{}{}
+	}
+	shadow.Delta = shadowDelta(shadow.Desired, shadow.Reported)
+	return shadow, nil
+}
+
+const shadowColumns = `desired, reported, version, reported_version, updated_at, reported_at`
+
+func (s *Service) getShadow(serialNumber string) (*DeviceShadow, error) {
+	row := s.timescaleClient.DB.QueryRowContext(s.ctx,
+		`SELECT `+shadowColumns+` FROM device_shadow WHERE serial_number = $1`, serialNumber)
+	return scanShadow(row, serialNumber)
+}
+
+// handleShadow serves GET and PATCH /shadows/<serial_number>.
+func (s *Service) handleShadow(w http.ResponseWriter, r *http.Request) {
+	serialNumber := strings.Trim(strings.TrimPrefix(r.URL.Path, "/shadows/"), "/")
+	if serialNumber == "" || strings.Contains(serialNumber, "/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		shadow, err := s.getShadow(serialNumber)
+		if err != nil {
+			log.Printf("Error getting shadow for device %s: %v", serialNumber, err)
+			writeError(w, http.StatusInternalServerError, "failed to get shadow")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "success", "data": shadow})
+	case http.MethodPatch:
+		s.patchShadow(w, r, serialNumber)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Service) patchShadow(w http.ResponseWriter, r *http.Request, serialNumber string) {
+	var req struct {
+		Desired map[string]interface{} `json:"desired"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Desired) == 0 {
+		writeError(w, http.StatusBadRequest, "body must contain a non-empty desired object")
+		return
+	}
+
+	if _, err := s.getDeviceFromCacheOrService(serialNumber); err != nil {
+		writeError(w, http.StatusNotFound, "unknown device")
+		return
+	}
+
+	tx, err := s.timescaleClient.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting shadow transaction: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to update shadow")
+		return
+	}
+	defer tx.Rollback()
+
+	// Like updateReportedState, make sure there is a row to lock so
+	// concurrent patches of a new shadow merge instead of overwriting each
+	// other.
+	_, err = tx.ExecContext(r.Context(), `
+		INSERT INTO device_shadow (serial_number, desired, reported, version, reported_version)
+		VALUES ($1, '{}', '{}', 0, 0)
+		ON CONFLICT (serial_number) DO NOTHING
+	`, serialNumber)
+	if err != nil {
+		log.Printf("Error creating shadow for device %s: %v", serialNumber, err)
+		writeError(w, http.StatusInternalServerError, "failed to update shadow")
+		return
+	}
+
+	row := tx.QueryRowContext(r.Context(),
+		`SELECT `+shadowColumns+` FROM device_shadow WHERE serial_number = $1 FOR UPDATE`, serialNumber)
+	shadow, err := scanShadow(row, serialNumber)
+	if err != nil {
+		log.Printf("Error getting shadow for device %s: %v", serialNumber, err)
+		writeError(w, http.StatusInternalServerError, "failed to update shadow")
+		return
+	}
+
+	shadow.Desired = mergePatch(shadow.Desired, req.Desired)
+	shadow.Version++
+	desired, _ := json.Marshal(shadow.Desired)
+
+	_, err = tx.ExecContext(r.Context(), `
+		UPDATE device_shadow SET desired = $2, version = $3, updated_at = NOW()
+		WHERE serial_number = $1
+	`, serialNumber, string(desired), shadow.Version)
+	if err != nil {
+		log.Printf("Error writing shadow for device %s: %v", serialNumber, err)
+		writeError(w, http.StatusInternalServerError, "failed to update shadow")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing shadow for device %s: %v", serialNumber, err)
+		writeError(w, http.StatusInternalServerError, "failed to update shadow")
+		return
+	}
+
+	shadow.Delta = shadowDelta(shadow.Desired, shadow.Reported)
+	if len(shadow.Delta) > 0 {
+		s.publishShadowDelta(shadow)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "success", "data": shadow})
+}
+
+func (s *Service) publishShadowDelta(shadow *DeviceShadow) {
+	topic := fmt.Sprintf("JI/v2/%s/config", shadow.SerialNumber)
+	if err := s.publishMQTT(topic, 1, ConfigCommand{Version: shadow.Version, State: shadow.Delta}); err != nil {
+		log.Printf("Error publishing config delta for device %s: %v", shadow.SerialNumber, err)
+		return
+	}
+	log.Printf("Published config version %d to device %s", shadow.Version, shadow.SerialNumber)
+}
+
+// HandleConfigAck merges the configuration a device reports after applying
+// a config command into its shadow. An ack for an older version than the
+// desired one gets the current delta again.
+func (s *Service) HandleConfigAck(topic string, payload []byte) {
+	serialNumber, err := extractSerialNumberFromTopic(topic)
+	if err != nil {
+		log.Printf("Error extracting serial number: %v", err)
+		return
+	}
+
+	var ack ConfigAck
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		log.Printf("Error parsing config ack from device %s: %v", serialNumber, err)
+		return
+	}
+
+	shadow, err := s.updateReportedState(serialNumber, ack.Version, ack.Reported)
+	if err != nil {
+		log.Printf("Error updating reported state for device %s: %v", serialNumber, err)
+		return
+	}
+	log.Printf("Device %s acknowledged config version %d", serialNumber, ack.Version)
+
+	if ack.Version < shadow.Version && len(shadow.Delta) > 0 {
+		log.Printf("Device %s acknowledged stale config version %d, current is %d", serialNumber, ack.Version, shadow.Version)
+		s.publishShadowDelta(shadow)
+	}
+}
+
+// updateReportedState merges reported into the shadow with the same merge
+// patch semantics as desired state and returns the updated shadow. A
+// version of zero leaves the reported version untouched, which is how
+// telemetry derived settings are recorded.
+func (s *Service) updateReportedState(serialNumber string, version int, reported map[string]interface{}) (*DeviceShadow, error) {
+	var shadow *DeviceShadow
+	err := s.inTransaction(10*time.Second, func(ctx context.Context, tx *sql.Tx) error {
+		// Make sure there is a row to lock so concurrent reports for a new
+		// shadow merge instead of overwriting each other.
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO device_shadow (serial_number, desired, reported, version, reported_version)
+			VALUES ($1, '{}', '{}', 0, 0)
+			ON CONFLICT (serial_number) DO NOTHING
+		`, serialNumber)
+		if err != nil {
+			return err
+		}
+
+		row := tx.QueryRowContext(ctx,
+			`SELECT `+shadowColumns+` FROM device_shadow WHERE serial_number = $1 FOR UPDATE`, serialNumber)
+		if shadow, err = scanShadow(row, serialNumber); err != nil {
+			return err
+		}
+
+		shadow.Reported = mergePatch(shadow.Reported, reported)
+		shadow.ReportedVersion = max(shadow.ReportedVersion, version)
+		encoded, err := json.Marshal(shadow.Reported)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE device_shadow SET reported = $2, reported_version = $3, reported_at = NOW()
+			WHERE serial_number = $1
+		`, serialNumber, string(encoded), shadow.ReportedVersion)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	shadow.Delta = shadowDelta(shadow.Desired, shadow.Reported)
+	return shadow, nil
+}
+
+// reportPressureLimits records the limits a pressure device reports in its
+// telemetry as reported state. The shadow is only written when they change.
+func (s *Service) reportPressureLimits(serialNumber string, data []PressureData) {
+	limits := make(map[string]interface{})
+	for _, d := range data {
+		limits[strings.ReplaceAll(d.Measurement, " ", "_")] = map[string]interface{}{
+			"enable":     d.Enable,
+			"high_limit": d.HighLimit,
+			"low_limit":  d.LowLimit,
+		}
+	}
+	reported := map[string]interface{}{"pressure_limits": limits}
+
+	encoded, err := json.Marshal(reported)
+	if err != nil {
+		return
+	}
+	key := "shadow/reported_limits/" + serialNumber
+	if cached, err := s.redisClient.Rdb.Get(s.ctx, key).Result(); err == nil && cached == string(encoded) {
+		return
+	}
+
+	if _, err := s.updateReportedState(serialNumber, 0, reported); err != nil {
+		log.Printf("Error updating reported pressure limits for device %s: %v", serialNumber, err)
+		return
+	}
+	s.redisClient.Rdb.Set(s.ctx, key, encoded, 24*time.Hour)
+}
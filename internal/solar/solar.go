@@ -0,0 +1,225 @@
+// Package solar decodes the power block reported by solar powered tank
+// sites and derives energy balance, battery autonomy and alerts from it.
+package solar
+
+import (
+	"fmt"
+	"strings"
+)
+
+type BatteryState string
+type ChargerState string
+type LoadState string
+
+const (
+	BatteryNormal       BatteryState = "normal"
+	BatteryOverVoltage  BatteryState = "over_voltage"
+	BatteryUnderVoltage BatteryState = "under_voltage"
+	BatteryLowVoltage   BatteryState = "low_voltage_disconnect"
+	BatteryOverTemp     BatteryState = "over_temperature"
+	BatteryLowTemp      BatteryState = "low_temperature"
+	BatteryFault        BatteryState = "fault"
+
+	ChargerStandby  ChargerState = "standby"
+	ChargerRunning  ChargerState = "running"
+	ChargerFloat    ChargerState = "float"
+	ChargerBoost    ChargerState = "boost"
+	ChargerEqualize ChargerState = "equalize"
+	ChargerNoCharge ChargerState = "not_charging"
+	ChargerPVShort  ChargerState = "pv_short"
+	ChargerOverCurr ChargerState = "input_over_current"
+	ChargerMOSFET   ChargerState = "mosfet_short"
+	ChargerFault    ChargerState = "fault"
+
+	LoadOn          LoadState = "on"
+	LoadOff         LoadState = "off"
+	LoadOverCurrent LoadState = "over_current"
+	LoadShort       LoadState = "short_circuit"
+	LoadFault       LoadState = "fault"
+)
+
+var batteryStates = map[string]BatteryState{
+	"normal":              BatteryNormal,
+	"overvolt":            BatteryOverVoltage,
+	"over_voltage":        BatteryOverVoltage,
+	"undervolt":           BatteryUnderVoltage,
+	"under_voltage":       BatteryUnderVoltage,
+	"low_volt_disconnect": BatteryLowVoltage,
+	"low_voltage":         BatteryLowVoltage,
+	"over_temp":           BatteryOverTemp,
+	"over_temperature":    BatteryOverTemp,
+	"low_temp":            BatteryLowTemp,
+	"low_temperature":     BatteryLowTemp,
+	"fault":               BatteryFault,
+	"abnormal":            BatteryFault,
+}
+
+var chargerStates = map[string]ChargerState{
+	"standby":            ChargerStandby,
+	"running":            ChargerRunning,
+	"charging":           ChargerRunning,
+	"float":              ChargerFloat,
+	"boost":              ChargerBoost,
+	"equalize":           ChargerEqualize,
+	"equalization":       ChargerEqualize,
+	"no_charging":        ChargerNoCharge,
+	"not_charging":       ChargerNoCharge,
+	"pv_short":           ChargerPVShort,
+	"pv_input_short":     ChargerPVShort,
+	"input_over_current": ChargerOverCurr,
+	"input_overcurrent":  ChargerOverCurr,
+	"mosfet_short":       ChargerMOSFET,
+	"fault":              ChargerFault,
+}
+
+var loadStates = map[string]LoadState{
+	"on":            LoadOn,
+	"off":           LoadOff,
+	"over_current":  LoadOverCurrent,
+	"overcurrent":   LoadOverCurrent,
+	"short":         LoadShort,
+	"short_circuit": LoadShort,
+	"fault":         LoadFault,
+}
+
+func normalize(raw string) string {
+	s := strings.ToLower(strings.TrimSpace(raw))
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(s)
+}
+
+// Status is the decoded form of the battStat, solarStat and loadStat arrays.
+// Strings the firmware sends that are not known are kept in Unknown.
+type Status struct {
+	Battery []BatteryState `json:"battery"`
+	Charger []ChargerState `json:"charger"`
+	Load    []LoadState    `json:"load"`
+	Unknown []string       `json:"unknown,omitempty"`
+}
+
+func Decode(battery, charger, load []string) Status {
+	var st Status
+	for _, raw := range battery {
+		if v, ok := batteryStates[normalize(raw)]; ok {
+			st.Battery = append(st.Battery, v)
+		} else if raw != "" {
+			st.Unknown = append(st.Unknown, "battery:"+raw)
+		}
+	}
+	for _, raw := range charger {
+		if v, ok := chargerStates[normalize(raw)]; ok {
+			st.Charger = append(st.Charger, v)
+		} else if raw != "" {
+			st.Unknown = append(st.Unknown, "charger:"+raw)
+		}
+	}
+	for _, raw := range load {
+		if v, ok := loadStates[normalize(raw)]; ok {
+			st.Load = append(st.Load, v)
+		} else if raw != "" {
+			st.Unknown = append(st.Unknown, "load:"+raw)
+		}
+	}
+	return st
+}
+
+// Faults lists the charge controller, battery and load states that need a
+// field visit.
+func (st Status) Faults() []string {
+	var faults []string
+	for _, v := range st.Battery {
+		if v == BatteryFault || v == BatteryOverVoltage {
+			faults = append(faults, "battery:"+string(v))
+		}
+	}
+	for _, v := range st.Charger {
+		switch v {
+		case ChargerFault, ChargerPVShort, ChargerOverCurr, ChargerMOSFET:
+			faults = append(faults, "charger:"+string(v))
+		}
+	}
+	for _, v := range st.Load {
+		switch v {
+		case LoadFault, LoadOverCurrent, LoadShort:
+			faults = append(faults, "load:"+string(v))
+		}
+	}
+	return faults
+}
+
+// Energy holds the controller's daily energy counters in Wh. Index 0 of the
+// eGen and eCom arrays is today, the following entries are previous days.
+type Energy struct {
+	GeneratedToday float64 `json:"generated_today"`
+	ConsumedToday  float64 `json:"consumed_today"`
+	BalanceToday   float64 `json:"balance_today"`
+	AvgConsumed    float64 `json:"avg_consumed"`
+}
+
+func ComputeEnergy(eGen, eCom []int) Energy {
+	var e Energy
+	if len(eGen) > 0 {
+		e.GeneratedToday = float64(eGen[0])
+	}
+	if len(eCom) > 0 {
+		e.ConsumedToday = float64(eCom[0])
+	}
+	e.BalanceToday = e.GeneratedToday - e.ConsumedToday
+
+	// Today is still accumulating, so average over complete days when the
+	// controller reports any.
+	days := eCom
+	if len(eCom) > 1 {
+		days = eCom[1:]
+	}
+	var total float64
+	for _, v := range days {
+		total += float64(v)
+	}
+	if len(days) > 0 {
+		e.AvgConsumed = total / float64(len(days))
+	}
+	return e
+}
+
+// AutonomyDays estimates how long the battery lasts without sun, or -1 when
+// consumption is unknown.
+func AutonomyDays(batteryLevel int, capacityWh float64, avgConsumedWh float64) float64 {
+	if avgConsumedWh <= 0 {
+		return -1
+	}
+	return float64(batteryLevel) / 100 * capacityWh / avgConsumedWh
+}
+
+type Thresholds struct {
+	LowBattery  int
+	HighTemp    int
+	LowAutonomy float64
+}
+
+type Alert struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+// Evaluate returns the alert conditions currently active for a reading.
+func Evaluate(st Status, batteryLevel, batteryTemp int, autonomy float64, th Thresholds) []Alert {
+	var alerts []Alert
+	if batteryLevel < th.LowBattery {
+		alerts = append(alerts, Alert{"low_battery", fmt.Sprintf("battery level %d%% below %d%%", batteryLevel, th.LowBattery)})
+	}
+	if batteryTemp > th.HighTemp {
+		alerts = append(alerts, Alert{"battery_over_temperature", fmt.Sprintf("battery temperature %d above %d", batteryTemp, th.HighTemp)})
+	}
+	for _, v := range st.Battery {
+		if v == BatteryOverTemp && batteryTemp <= th.HighTemp {
+			alerts = append(alerts, Alert{"battery_over_temperature", "controller reports battery over temperature"})
+		}
+	}
+	if faults := st.Faults(); len(faults) > 0 {
+		alerts = append(alerts, Alert{"charge_controller_fault", strings.Join(faults, ", ")})
+	}
+	if autonomy >= 0 && autonomy < th.LowAutonomy {
+		alerts = append(alerts, Alert{"low_autonomy", fmt.Sprintf("estimated autonomy %.1f days below %.1f days", autonomy, th.LowAutonomy)})
+	}
+	return alerts
+}
@@ -0,0 +1,132 @@
+package solar
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name                   string
+		battery, charger, load []string
+		want                   Status
+	}{
+		{
+			name:    "known states",
+			battery: []string{"NORMAL"},
+			charger: []string{"MPPT", "Charging", "float"},
+			load:    []string{"ON"},
+			want: Status{
+				Battery: []BatteryState{BatteryNormal},
+				Charger: []ChargerState{ChargerRunning, ChargerFloat},
+				Load:    []LoadState{LoadOn},
+				Unknown: []string{"charger:MPPT"},
+			},
+		},
+		{
+			name:    "aliases",
+			battery: []string{"Low Volt Disconnect", "over-temp"},
+			charger: []string{"PV input short"},
+			load:    []string{"Short"},
+			want: Status{
+				Battery: []BatteryState{BatteryLowVoltage, BatteryOverTemp},
+				Charger: []ChargerState{ChargerPVShort},
+				Load:    []LoadState{LoadShort},
+			},
+		},
+		{
+			name:    "empty strings",
+			battery: []string{""},
+			load:    []string{"", "off"},
+			want:    Status{Load: []LoadState{LoadOff}},
+		},
+		{
+			name: "nothing reported",
+			want: Status{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Decode(tt.battery, tt.charger, tt.load); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFaults(t *testing.T) {
+	st := Status{
+		Battery: []BatteryState{BatteryNormal, BatteryOverVoltage},
+		Charger: []ChargerState{ChargerRunning, ChargerMOSFET},
+		Load:    []LoadState{LoadOn, LoadOverCurrent},
+	}
+	want := []string{"battery:over_voltage", "charger:mosfet_short", "load:over_current"}
+	if got := st.Faults(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Faults() = %v, want %v", got, want)
+	}
+	if got := (Status{Battery: []BatteryState{BatteryLowTemp}}).Faults(); got != nil {
+		t.Errorf("Faults() = %v, want none", got)
+	}
+}
+
+func TestComputeEnergy(t *testing.T) {
+	tests := []struct {
+		name       string
+		eGen, eCom []int
+		want       Energy
+	}{
+		{"complete days", []int{120, 130}, []int{80, 90, 100}, Energy{GeneratedToday: 120, ConsumedToday: 80, BalanceToday: 40, AvgConsumed: 95}},
+		{"today only", []int{50}, []int{70}, Energy{GeneratedToday: 50, ConsumedToday: 70, BalanceToday: -20, AvgConsumed: 70}},
+		{"no counters", nil, nil, Energy{}},
+	}
+	for _, tt := range tests {
+		if got := ComputeEnergy(tt.eGen, tt.eCom); got != tt.want {
+			t.Errorf("%s: ComputeEnergy() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAutonomyDays(t *testing.T) {
+	if got := AutonomyDays(50, 2400, 300); got != 4 {
+		t.Errorf("AutonomyDays(50, 2400, 300) = %v, want 4", got)
+	}
+	if got := AutonomyDays(50, 2400, 0); got != -1 {
+		t.Errorf("AutonomyDays with unknown consumption = %v, want -1", got)
+	}
+}
+
+func alertTypes(alerts []Alert) []string {
+	types := []string{}
+	for _, a := range alerts {
+		types = append(types, a.Type)
+	}
+	return types
+}
+
+func TestEvaluate(t *testing.T) {
+	th := Thresholds{LowBattery: 20, HighTemp: 50, LowAutonomy: 2}
+	tests := []struct {
+		name        string
+		st          Status
+		level, temp int
+		autonomy    float64
+		want        []string
+	}{
+		{"healthy", Status{}, 80, 25, 5, []string{}},
+		{"low battery", Status{}, 10, 25, 5, []string{"low_battery"}},
+		{"hot battery", Status{}, 80, 55, 5, []string{"battery_over_temperature"}},
+		{"controller reports hot battery", Status{Battery: []BatteryState{BatteryOverTemp}}, 80, 25, 5, []string{"battery_over_temperature"}},
+		{"hot battery reported once", Status{Battery: []BatteryState{BatteryOverTemp}}, 80, 55, 5, []string{"battery_over_temperature"}},
+		{"fault", Status{Charger: []ChargerState{ChargerFault}}, 80, 25, 5, []string{"charge_controller_fault"}},
+		{"low autonomy", Status{}, 80, 25, 1.5, []string{"low_autonomy"}},
+		{"unknown autonomy", Status{}, 80, 25, -1, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := alertTypes(Evaluate(tt.st, tt.level, tt.temp, tt.autonomy, th))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
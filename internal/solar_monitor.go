@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"log"
+	"time"
+
+	"medical-gas-transport-service/internal/solar"
+)
+
+const solarAlertChannel = "solar:alert"
+
+type SolarAlertEvent struct {
+	Type         string    `json:"type"`
+	State        string    `json:"state"`
+	SerialNumber string    `json:"serial_number"`
+	Detail       string    `json:"detail"`
+	Time         time.Time `json:"time"`
+}
+
+type SolarSummary struct {
+	Status       solar.Status `json:"status"`
+	Energy       solar.Energy `json:"energy"`
+	AutonomyDays float64      `json:"autonomy_days"`
+}
+
+// power returns the power block of a level reading, zero for devices that
+// don't report one.
+func (d SensorLevelData) power() SolarPower {
+	if d.Solar == nil {
+		return SolarPower{}
+	}
+	return *d.Solar
+}
+
+// solarSummary decodes the power block of a level reading.
+func (s *Service) solarSummary(levelData SensorLevelData) SolarSummary {
+	power := levelData.power()
+	summary := SolarSummary{
+		Status: solar.Decode(power.SolarBattStatus, power.SolarDeviceStatus, power.SolarLoadStatus),
+		Energy: solar.ComputeEnergy(power.SolarEGen, power.SolarECom),
+	}
+	summary.AutonomyDays = solar.AutonomyDays(power.SolarBattLevel, s.cfg.Solar.CapacityWh, summary.Energy.AvgConsumed)
+
+	if len(summary.Status.Unknown) > 0 {
+		log.Printf("Unknown solar status values from device %s: %v", levelData.SerialNumber, summary.Status.Unknown)
+	}
+	return summary
+}
+
+// trackSolar records the daily energy balance of a stored level reading and
+// raises or clears solar alerts for the site. Readings without a power block
+// are skipped.
+func (s *Service) trackSolar(levelData SensorLevelData, summary SolarSummary) {
+	if levelData.Solar == nil {
+		return
+	}
+	power := *levelData.Solar
+	local := levelData.Timestamp.In(s.cfg.Consumption.Location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	err := s.writeToTimescaleDBWithRetry(`
+		INSERT INTO solar_energy_daily (
+			day, serial_number, generated_wh, consumed_wh, balance_wh, battery_level, autonomy_days, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (day, serial_number) DO UPDATE SET
+			generated_wh = EXCLUDED.generated_wh, consumed_wh = EXCLUDED.consumed_wh,
+			balance_wh = EXCLUDED.balance_wh, battery_level = EXCLUDED.battery_level,
+			autonomy_days = EXCLUDED.autonomy_days, updated_at = EXCLUDED.updated_at
+		WHERE solar_energy_daily.updated_at <= EXCLUDED.updated_at
+	`,
+		day,
+		levelData.SerialNumber,
+		summary.Energy.GeneratedToday,
+		summary.Energy.ConsumedToday,
+		summary.Energy.BalanceToday,
+		power.SolarBattLevel,
+		summary.AutonomyDays,
+		levelData.Timestamp,
+	)
+	if err != nil {
+		log.Printf("Error writing solar energy for device %s: %v", levelData.SerialNumber, err)
+	}
+
+	alerts := solar.Evaluate(summary.Status, power.SolarBattLevel, power.SolarBattTemp, summary.AutonomyDays, solar.Thresholds{
+		LowBattery:  s.cfg.Solar.LowBattery,
+		HighTemp:    s.cfg.Solar.HighTemp,
+		LowAutonomy: s.cfg.Solar.LowAutonomy,
+	})
+	s.updateSolarAlerts(levelData.SerialNumber, levelData.Timestamp, alerts)
+}
+
+// updateSolarAlerts publishes alerts that became active and clears the ones
+// that are no longer reported. The Redis set makes alerts edge triggered
+// across instances.
+func (s *Service) updateSolarAlerts(serialNumber string, timestamp time.Time, alerts []solar.Alert) {
+	key := "solar:active/" + serialNumber
+	rdb := s.redisClient.Rdb
+
+	current := make(map[string]solar.Alert)
+	for _, a := range alerts {
+		if _, ok := current[a.Type]; !ok {
+			current[a.Type] = a
+		}
+	}
+
+	for _, a := range current {
+		added, err := rdb.SAdd(s.ctx, key, a.Type).Result()
+		if err != nil {
+			log.Printf("Error recording solar alert for device %s: %v", serialNumber, err)
+			continue
+		}
+		if added > 0 {
+			s.emitSolarAlert(SolarAlertEvent{Type: a.Type, State: "raised", SerialNumber: serialNumber, Detail: a.Detail, Time: timestamp})
+		}
+	}
+
+	active, err := rdb.SMembers(s.ctx, key).Result()
+	if err != nil {
+		log.Printf("Error reading solar alerts for device %s: %v", serialNumber, err)
+		return
+	}
+	for _, alertType := range active {
+		if _, ok := current[alertType]; ok {
+			continue
+		}
+		if removed, err := rdb.SRem(s.ctx, key, alertType).Result(); err == nil && removed > 0 {
+			s.emitSolarAlert(SolarAlertEvent{Type: alertType, State: "cleared", SerialNumber: serialNumber, Time: timestamp})
+		}
+	}
+}
+
+func (s *Service) emitSolarAlert(event SolarAlertEvent) {
+	log.Printf("Solar alert %s %s for device %s: %s", event.Type, event.State, event.SerialNumber, event.Detail)
+
+	err := s.writeToTimescaleDBWithRetry(
+		`INSERT INTO solar_alert (time, serial_number, type, state, detail) VALUES ($1, $2, $3, $4, $5)`,
+		event.Time, event.SerialNumber, event.Type, event.State, event.Detail,
+	)
+	if err != nil {
+		log.Printf("Error writing solar alert for device %s: %v", event.SerialNumber, err)
+	}
+
+	if err := s.publishEvent(solarAlertChannel, event); err != nil {
+		log.Printf("Error publishing solar alert for device %s: %v", event.SerialNumber, err)
+	}
+}
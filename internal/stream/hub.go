@@ -0,0 +1,139 @@
+// Package stream fans sensor events out to streaming clients and keeps a
+// bounded history so clients can resume after reconnecting.
+package stream
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+type Event struct {
+	ID           uint64          `json:"id"`
+	Type         string          `json:"type"`
+	SerialNumber string          `json:"serial_number"`
+	Hospital     string          `json:"-"`
+	Data         json.RawMessage `json:"data"`
+}
+
+// Filter selects the events a client receives. Empty fields match anything.
+type Filter struct {
+	Hospital      string
+	SerialNumbers map[string]bool
+	Types         map[string]bool
+}
+
+func (f Filter) Match(e Event) bool {
+	if f.Hospital != "" && e.Hospital != f.Hospital {
+		return false
+	}
+	if len(f.SerialNumbers) > 0 && !f.SerialNumbers[e.SerialNumber] {
+		return false
+	}
+	if len(f.Types) > 0 && !f.Types[e.Type] {
+		return false
+	}
+	return true
+}
+
+// Hub assigns event IDs, keeps the last events in a ring buffer and delivers
+// new events to subscriptions. IDs start from the hub's creation time, so
+// IDs handed out before a restart are always older than the buffer and
+// resuming from them reports a gap.
+type Hub struct {
+	mu         sync.Mutex
+	ring       []Event
+	start      int
+	count      int
+	nextID     uint64
+	bufferSize int
+	subs       map[*Subscription]struct{}
+}
+
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	filter Filter
+	hub    *Hub
+}
+
+func NewHub(historySize, clientBuffer int) *Hub {
+	return &Hub{
+		ring:       make([]Event, historySize),
+		nextID:     uint64(time.Now().UnixMicro()),
+		bufferSize: clientBuffer,
+		subs:       make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish assigns the next ID to e and delivers it. Subscriptions that can't
+// keep up are closed rather than blocking the hub.
+func (h *Hub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e.ID = h.nextID
+	h.nextID++
+
+	if len(h.ring) > 0 {
+		if h.count < len(h.ring) {
+			h.ring[(h.start+h.count)%len(h.ring)] = e
+			h.count++
+		} else {
+			h.ring[h.start] = e
+			h.start = (h.start + 1) % len(h.ring)
+		}
+	}
+
+	for sub := range h.subs {
+		if !sub.filter.Match(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			h.remove(sub)
+		}
+	}
+}
+
+// Subscribe registers a subscription. When resume is set, buffered events
+// after lastID that match the filter are returned for replay; gap reports
+// that some events after lastID are no longer buffered.
+func (h *Hub) Subscribe(filter Filter, lastID uint64, resume bool) (sub *Subscription, replay []Event, gap bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if resume {
+		oldest := h.nextID
+		if h.count > 0 {
+			oldest = h.ring[h.start].ID
+		}
+		gap = lastID+1 < oldest
+		for i := 0; i < h.count; i++ {
+			e := h.ring[(h.start+i)%len(h.ring)]
+			if e.ID > lastID && filter.Match(e) {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	ch := make(chan Event, h.bufferSize)
+	sub = &Subscription{C: ch, ch: ch, filter: filter, hub: h}
+	h.subs[sub] = struct{}{}
+	return sub, replay, gap
+}
+
+// Close unregisters the subscription and closes its channel.
+func (sub *Subscription) Close() {
+	sub.hub.mu.Lock()
+	defer sub.hub.mu.Unlock()
+	sub.hub.remove(sub)
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
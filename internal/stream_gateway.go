@@ -0,0 +1,279 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"medical-gas-transport-service/internal/stream"
+
+	"github.com/gorilla/websocket"
+)
+
+var streamChannels = map[string]string{
+	"sensor:level":    "level",
+	"sensor:flow":     "flow",
+	"sensor:pressure": "pressure",
+}
+
+// startStreamGateway relays sensor events from Redis to the streaming hub
+// that serves SSE and WebSocket clients.
+func (s *Service) startStreamGateway() {
+	s.streamHub = stream.NewHub(s.cfg.Stream.HistorySize, s.cfg.Stream.ClientBuffer)
+
+	channels := make([]string, 0, len(streamChannels))
+	for channel := range streamChannels {
+		channels = append(channels, channel)
+	}
+	pubsub := s.redisClient.Rdb.Subscribe(s.ctx, channels...)
+
+	go func() {
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				s.relayStreamEvent(streamChannels[msg.Channel], []byte(msg.Payload))
+			}
+		}
+	}()
+}
+
+// relayStreamEvent publishes an event to the hub. Sensor events carry the
+// device's hospital; for events published without it the device is looked
+// up off the relay goroutine so a slow lookup doesn't hold up other events.
+func (s *Service) relayStreamEvent(eventType string, payload []byte) {
+	var event struct {
+		SerialNumber string `json:"serial_number"`
+		Hospital     string `json:"hospital"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil || event.SerialNumber == "" {
+		log.Printf("Ignoring malformed %s event on stream gateway: %v", eventType, err)
+		return
+	}
+
+	publish := func(hospital string) {
+		s.streamHub.Publish(stream.Event{
+			Type:         eventType,
+			SerialNumber: event.SerialNumber,
+			Hospital:     hospital,
+			Data:         payload,
+		})
+	}
+	if event.Hospital != "" {
+		publish(event.Hospital)
+		return
+	}
+
+	go func() {
+		var hospital string
+		if device, err := s.getDeviceFromCacheOrService(event.SerialNumber); err == nil && device != nil {
+			hospital = deviceHospital(device)
+		}
+		publish(hospital)
+	}()
+}
+
+// streamFilter builds a client filter from the hospital, serial_number and
+// type query parameters, restricted to the API key's hospital.
+func streamFilter(w http.ResponseWriter, r *http.Request) (stream.Filter, bool) {
+	q := r.URL.Query()
+	hospital, ok := scopedHospital(w, r, q.Get("hospital"))
+	if !ok {
+		return stream.Filter{}, false
+	}
+
+	filter := stream.Filter{Hospital: hospital}
+	if v := q.Get("serial_number"); v != "" {
+		filter.SerialNumbers = make(map[string]bool)
+		for _, serialNumber := range strings.Split(v, ",") {
+			filter.SerialNumbers[serialNumber] = true
+		}
+	}
+	if v := q.Get("type"); v != "" {
+		filter.Types = make(map[string]bool)
+		for _, t := range strings.Split(v, ",") {
+			if !containsString(readingTypes, t) {
+				writeError(w, http.StatusBadRequest, "type must be one of "+strings.Join(readingTypes, ", "))
+				return stream.Filter{}, false
+			}
+			filter.Types[t] = true
+		}
+	}
+	return filter, true
+}
+
+// lastEventID reads the position to resume from, sent by EventSource in the
+// Last-Event-ID header or by other clients as the last_event_id parameter.
+func lastEventID(w http.ResponseWriter, r *http.Request) (uint64, bool, bool) {
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = r.URL.Query().Get("last_event_id")
+	}
+	if raw == "" {
+		return 0, false, true
+	}
+	id, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "last event ID must be an unsigned integer")
+		return 0, false, false
+	}
+	return id, true, true
+}
+
+// handleEventStream streams events as Server-Sent Events. A "reset" event
+// tells a resuming client that events were missed and it should reload.
+func (s *Service) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	filter, ok := streamFilter(w, r)
+	if !ok {
+		return
+	}
+	lastID, resume, ok := lastEventID(w, r)
+	if !ok {
+		return
+	}
+
+	sub, replay, gap := s.streamHub.Subscribe(filter, lastID, resume)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if gap {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range replay {
+		writeSSE(w, e)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(s.cfg.Stream.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case e, ok := <-sub.C:
+			if !ok {
+				log.Printf("Closing slow event stream client %s", r.RemoteAddr)
+				return
+			}
+			writeSSE(w, e)
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSE(w http.ResponseWriter, e stream.Event) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+}
+
+// handleWebSocketStream streams events as JSON messages over a WebSocket.
+// Heartbeats are ping frames; clients that stop answering are disconnected.
+func (s *Service) handleWebSocketStream(w http.ResponseWriter, r *http.Request) {
+	filter, ok := streamFilter(w, r)
+	if !ok {
+		return
+	}
+	lastID, resume, ok := lastEventID(w, r)
+	if !ok {
+		return
+	}
+
+	upgrader := websocket.Upgrader{}
+	if len(s.cfg.Stream.AllowedOrigins) > 0 {
+		upgrader.CheckOrigin = func(r *http.Request) bool {
+			return containsString(s.cfg.Stream.AllowedOrigins, r.Header.Get("Origin"))
+		}
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Error upgrading stream connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	sub, replay, gap := s.streamHub.Subscribe(filter, lastID, resume)
+	defer sub.Close()
+
+	heartbeat := s.cfg.Stream.Heartbeat
+	conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	})
+
+	// Client messages are not used, but reading is needed to process pongs
+	// and notice disconnects.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(v interface{}) bool {
+		conn.SetWriteDeadline(time.Now().Add(heartbeat))
+		return conn.WriteJSON(v) == nil
+	}
+
+	if gap && !write(map[string]string{"type": "reset"}) {
+		return
+	}
+	for _, e := range replay {
+		if !write(e) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-s.ctx.Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(heartbeat)); err != nil {
+				return
+			}
+		case e, ok := <-sub.C:
+			if !ok {
+				log.Printf("Closing slow WebSocket stream client %s", r.RemoteAddr)
+				return
+			}
+			if !write(e) {
+				return
+			}
+		}
+	}
+}
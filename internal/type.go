@@ -1,23 +1,53 @@
 package internal
 
 import (
+	"encoding/json"
 	"time"
+
+	"medical-gas-transport-service/internal/pki"
 )
 type ProvisionRequest struct {
 	SerialNumber string `json:"serialNumber"`
+	ClaimToken   string `json:"claimToken,omitempty"`
+	Nonce        string `json:"nonce,omitempty"`
+	// PublicKey is a base64 X25519 key the credentials are encrypted to.
+	PublicKey string `json:"publicKey,omitempty"`
 }
 
 type ProvisionResponse struct {
 	Pattern string                `json:"pattern"`
 	Data    ProvisionResponseData `json:"data"`
+	// Encrypted holds the sealed ProvisionResponseData when the request
+	// carried a public key; Data then only has the status.
+	Encrypted *pki.Sealed `json:"encrypted,omitempty"`
 }
 
 type ProvisionResponseData struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
 	Status   string `json:"status"`
 }
 
+type CertificateProvisionRequest struct {
+	SerialNumber string `json:"serialNumber"`
+	CSR          string `json:"csr"`
+	ClaimToken   string `json:"claimToken,omitempty"`
+	Nonce        string `json:"nonce,omitempty"`
+}
+
+type CertificateProvisionResponse struct {
+	Pattern string                           `json:"pattern"`
+	Data    CertificateProvisionResponseData `json:"data"`
+}
+
+type CertificateProvisionResponseData struct {
+	Status      string    `json:"status"`
+	Reason      string    `json:"reason,omitempty"`
+	Certificate string    `json:"certificate,omitempty"`
+	CAChain     string    `json:"caChain,omitempty"`
+	ExpiresAt   time.Time `json:"expiresAt,omitempty"`
+}
+
 type Device struct {
 	DeviceUptime 			int     `json:"uptime"`
 	DeviceTemp   			float64 `json:"temp"`
@@ -36,35 +66,52 @@ type Device struct {
 type SensorLevelData struct {
 	Timestamp    time.Time `json:"-"`
 	SerialNumber string    `json:"-"`
+	// DeviceTime is Ts as reported, ReceivedAt when the broker delivered
+	// the reading and ClockOffset the device clock skew Timestamp is
+	// corrected by.
+	DeviceTime  time.Time     `json:"-"`
+	ReceivedAt  time.Time     `json:"-"`
+	ClockOffset time.Duration `json:"-"`
 
 	Ts     int64  `json:"ts"`
+	TsUnit string `json:"tsUnit,omitempty"`
 	Device Device `json:"device"`
 
 	Level float64 `json:"level"`
-	Solar struct {
-		SolarBattStatus   []string `json:"battStat"`
-		SolarDeviceStatus []string `json:"solarStat"`
-		SolarLoadStatus   []string `json:"loadStat"`
-		SolarBattTemp     int      `json:"battTemp"`
-		SolarBattLevel    int      `json:"battLevel"`
-		SolarBattVolt	  int      `json:"battVolt"`
-		SolarEGen         []int    `json:"eGen"`
-		SolarECom         []int    `json:"eCom"`
-	} `json:"power"`
+	// Solar is nil for devices that don't report a power block.
+	Solar *SolarPower `json:"power"`
 }
 
+type SolarPower struct {
+	SolarBattStatus   []string `json:"battStat"`
+	SolarDeviceStatus []string `json:"solarStat"`
+	SolarLoadStatus   []string `json:"loadStat"`
+	SolarBattTemp     int      `json:"battTemp"`
+	SolarBattLevel    int      `json:"battLevel"`
+	SolarBattVolt     int      `json:"battVolt"`
+	SolarEGen         []int    `json:"eGen"`
+	SolarECom         []int    `json:"eCom"`
+}
+
+
 type SensorFlowData struct {
-	Timestamp    time.Time `json:"-"`
-	SerialNumber string    `json:"-"`
-	Ts           int64     `json:"ts"`
-	Device       Device    `json:"device"`
-	TotalVolume  float64   `json:"-"` // (vHi*65536) + (vLo) + (vDec/1000)
-	VHi          float64   `json:"vHi"`
-	VLo          float64   `json:"vLo"`
-	VDec         float64   `json:"vDec"`
-	FlowRate     float64   `json:"-"` // ((fRateHi * 65536) + fRateLo)/1000
-	FRateHi      float64   `json:"fRateHi"`
-	FRateLo      float64   `json:"fRateLo"`
+	Timestamp        time.Time     `json:"-"`
+	SerialNumber     string        `json:"-"`
+	DeviceTime       time.Time     `json:"-"`
+	ReceivedAt       time.Time     `json:"-"`
+	ClockOffset      time.Duration `json:"-"`
+	Ts               int64         `json:"ts"`
+	TsUnit           string        `json:"tsUnit,omitempty"`
+	Device           Device        `json:"device"`
+	TotalVolume      float64       `json:"-"` // (vHi*65536) + (vLo) + (vDec/1000)
+	VHi              float64       `json:"vHi"`
+	VLo              float64       `json:"vLo"`
+	VDec             float64       `json:"vDec"`
+	FlowRate         float64       `json:"-"` // ((fRateHi * 65536) + fRateLo)/1000
+	FRateHi          float64       `json:"fRateHi"`
+	FRateLo          float64       `json:"fRateLo"`
+	CumulativeVolume float64       `json:"-"` // TotalVolume corrected for counter resets and wraps
+	DeltaVolume      float64       `json:"-"` // volume since the previous reading
 }
 
 type PressureData struct {
@@ -78,19 +125,43 @@ type PressureData struct {
 
 type SensorPressureData struct {
 	Ts           int64          `json:"ts"`
+	TsUnit       string         `json:"tsUnit,omitempty"`
 	Device       Device         `json:"device"`
 	Data         []PressureData `json:"data"`
 	SerialNumber string         `json:"-"`
 	Timestamp    time.Time      `json:"-"`
+	DeviceTime   time.Time      `json:"-"`
+	ReceivedAt   time.Time      `json:"-"`
+	ClockOffset  time.Duration  `json:"-"`
 }
 
 type MqttMessage struct {
-	Topic   string
-	Payload []byte
+	Topic       string
+	Payload     []byte
+	ContentType string
+	ReceivedAt  time.Time
+}
+
+// BackfillPayload carries readings a device buffered while offline, sent on
+// JI/v2/<serial>/backfill.
+type BackfillPayload struct {
+	// Readings are kept raw so each one can be validated on its own.
+	Nonce    string            `json:"nonce,omitempty"`
+	Level    []json.RawMessage `json:"level"`
+	Flow     []json.RawMessage `json:"flow"`
+	Pressure []json.RawMessage `json:"pressure"`
+}
+
+type BackfillResponse struct {
+	Nonce    string          `json:"nonce,omitempty"`
+	Level    *BackfillResult `json:"level,omitempty"`
+	Flow     *BackfillResult `json:"flow,omitempty"`
+	Pressure *BackfillResult `json:"pressure,omitempty"`
 }
 
 type FillingPayload struct {
 	Ts       			int64     `json:"ts"`
+	TsUnit   			string    `json:"tsUnit,omitempty"`
 	FillingState  int16     `json:"filling-state"`
 	Level    			float64   `json:"level"`
 	NanoID   			string    `json:"nano_id,omitempty"`
@@ -98,6 +169,8 @@ type FillingPayload struct {
 	State    			bool      `json:"-"`
 	SerialNumber 	string    `json:"-"`
 	Timestamp   	time.Time `json:"-"`
+	DeviceTime  	time.Time `json:"-"`
+	ReceivedAt  	time.Time `json:"-"`
 }
 
 type FillingResponsePayload struct {
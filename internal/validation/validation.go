@@ -0,0 +1,320 @@
+// Package validation checks device payloads against declarative rules per
+// payload type before they are stored, and reports every failed rule as a
+// reason code.
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"medical-gas-transport-service/internal/clock"
+)
+
+// Code identifies why a field failed a rule.
+type Code string
+
+const (
+	Malformed   Code = "malformed"
+	Missing     Code = "missing"
+	WrongType   Code = "wrong_type"
+	NotFinite   Code = "not_finite"
+	OutOfRange  Code = "out_of_range"
+	Unknown     Code = "unknown_value"
+	ZeroTime    Code = "zero_timestamp"
+	ClockFuture Code = "clock_future"
+	ClockPast   Code = "clock_past"
+)
+
+type Violation struct {
+	Field  string `json:"field"`
+	Code   Code   `json:"code"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Reason is the field and code, the key violations are counted under.
+func (v Violation) Reason() string {
+	return v.Field + ":" + string(v.Code)
+}
+
+func (v Violation) String() string {
+	if v.Detail == "" {
+		return v.Reason()
+	}
+	return v.Reason() + " (" + v.Detail + ")"
+}
+
+// Limits are the rule parameters that depend on deployment rather than on
+// the payload format.
+type Limits struct {
+	// MaxFuture and MaxPast bound how far a reading's timestamp may be from
+	// the server clock.
+	MaxFuture time.Duration
+	MaxPast   time.Duration
+}
+
+// Env is what checks get besides the value itself.
+type Env struct {
+	Now    time.Time
+	Limits Limits
+	// TsUnit is the payload's tsUnit, set by Validate.
+	TsUnit string
+}
+
+// Check tests one value, returning the code and detail of the failure or an
+// empty code when the value passes.
+type Check func(v interface{}, env Env) (Code, string)
+
+// Rule applies a check to a field. Field is a dotted path into the payload;
+// a segment ending in [] applies the rest of the path to every element of
+// that array.
+type Rule struct {
+	Field    string
+	Required bool
+	Check    Check
+}
+
+// Number accepts any finite number.
+func Number() Check {
+	return Range(math.Inf(-1), math.Inf(1))
+}
+
+// Range accepts finite numbers between min and max inclusive.
+func Range(min, max float64) Check {
+	return func(v interface{}, env Env) (Code, string) {
+		f, code := number(v)
+		if code != "" {
+			return code, ""
+		}
+		if f < min || f > max {
+			return OutOfRange, fmt.Sprintf("%g not in [%g, %g]", f, min, max)
+		}
+		return "", ""
+	}
+}
+
+// AtLeast accepts finite numbers of at least min.
+func AtLeast(min float64) Check {
+	return func(v interface{}, env Env) (Code, string) {
+		f, code := number(v)
+		if code != "" {
+			return code, ""
+		}
+		if f < min {
+			return OutOfRange, fmt.Sprintf("%g below %g", f, min)
+		}
+		return "", ""
+	}
+}
+
+// OneOf accepts the given strings.
+func OneOf(values ...string) Check {
+	return func(v interface{}, env Env) (Code, string) {
+		s, ok := v.(string)
+		if !ok {
+			return WrongType, ""
+		}
+		for _, value := range values {
+			if s == value {
+				return "", ""
+			}
+		}
+		return Unknown, fmt.Sprintf("%q", s)
+	}
+}
+
+// String accepts any string.
+func String() Check {
+	return func(v interface{}, env Env) (Code, string) {
+		if _, ok := v.(string); !ok {
+			return WrongType, ""
+		}
+		return "", ""
+	}
+}
+
+// Array accepts any array.
+func Array() Check {
+	return func(v interface{}, env Env) (Code, string) {
+		if _, ok := v.([]interface{}); !ok {
+			return WrongType, ""
+		}
+		return "", ""
+	}
+}
+
+// Timestamp accepts Unix timestamps in the payload's unit within the clock
+// skew limits of the server time.
+func Timestamp() Check {
+	return func(v interface{}, env Env) (Code, string) {
+		f, code := number(v)
+		if code != "" {
+			return code, ""
+		}
+		if f == 0 {
+			return ZeroTime, ""
+		}
+		t := clock.FromUnix(int64(f), env.TsUnit)
+		if env.Limits.MaxFuture > 0 && t.After(env.Now.Add(env.Limits.MaxFuture)) {
+			return ClockFuture, fmt.Sprintf("%s ahead", t.Sub(env.Now).Round(time.Second))
+		}
+		if env.Limits.MaxPast > 0 && t.Before(env.Now.Add(-env.Limits.MaxPast)) {
+			return ClockPast, fmt.Sprintf("%s behind", env.Now.Sub(t).Round(time.Second))
+		}
+		return "", ""
+	}
+}
+
+func number(v interface{}) (float64, Code) {
+	var f float64
+	switch n := v.(type) {
+	case json.Number:
+		var err error
+		if f, err = n.Float64(); err != nil {
+			return 0, NotFinite
+		}
+	case float64:
+		f = n
+	default:
+		return 0, WrongType
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, NotFinite
+	}
+	return f, ""
+}
+
+// PressureMeasurements are the gas lines a pressure sensor reports.
+var PressureMeasurements = []string{"nitrous oxide", "oxygen", "medical air", "vacuum"}
+
+// tsUnits are the accepted tsUnit values. Empty means the unit is inferred,
+// and is what Protobuf payloads that leave tsUnit unset decode to.
+var tsUnits = append([]string{""}, clock.Units...)
+
+var deviceRules = []Rule{
+	{Field: "device.uptime", Check: Range(0, math.MaxInt32)},
+	{Field: "device.temp", Check: Range(-40, 125)},
+	{Field: "device.hum", Check: Range(0, 100)},
+	{Field: "device.long", Check: Range(-180, 180)},
+	{Field: "device.lat", Check: Range(-90, 90)},
+	{Field: "device.rssi", Check: Range(-150, 0)},
+	{Field: "device.memory", Check: Range(0, math.MaxInt32)},
+	{Field: "device.fwVer", Check: String()},
+}
+
+// Rules holds the rules per payload type, keyed like the topic suffix.
+//
+// Required only catches fields missing from JSON and CBOR payloads. Protobuf
+// payloads are converted with proto3 defaults for absent scalar fields, so a
+// missing field arrives as zero or "" and is only caught by its check, e.g.
+// Timestamp rejects a missing ts as zero_timestamp while a missing level
+// passes as 0.
+var Rules = map[string][]Rule{
+	"level": append([]Rule{
+		{Field: "ts", Required: true, Check: Timestamp()},
+		{Field: "tsUnit", Check: OneOf(tsUnits...)},
+		{Field: "level", Required: true, Check: AtLeast(0)},
+		{Field: "power.battLevel", Check: Range(0, 100)},
+	}, deviceRules...),
+	"flow": append([]Rule{
+		{Field: "ts", Required: true, Check: Timestamp()},
+		{Field: "tsUnit", Check: OneOf(tsUnits...)},
+		{Field: "vHi", Required: true, Check: AtLeast(0)},
+		{Field: "vLo", Required: true, Check: AtLeast(0)},
+		{Field: "vDec", Required: true, Check: AtLeast(0)},
+		{Field: "fRateHi", Required: true, Check: AtLeast(0)},
+		{Field: "fRateLo", Required: true, Check: AtLeast(0)},
+	}, deviceRules...),
+	"pressure": append([]Rule{
+		{Field: "ts", Required: true, Check: Timestamp()},
+		{Field: "tsUnit", Check: OneOf(tsUnits...)},
+		{Field: "data", Required: true, Check: Array()},
+		{Field: "data[].measurement", Required: true, Check: OneOf(PressureMeasurements...)},
+		{Field: "data[].value", Required: true, Check: Number()},
+		{Field: "data[].high_limit", Check: Number()},
+		{Field: "data[].low_limit", Check: Number()},
+	}, deviceRules...),
+	"filling": {
+		{Field: "ts", Required: true, Check: Timestamp()},
+		{Field: "tsUnit", Check: OneOf(tsUnits...)},
+		{Field: "filling-state", Required: true, Check: Range(0, 1)},
+		{Field: "level", Required: true, Check: AtLeast(0)},
+	},
+}
+
+// Validate checks a JSON payload of the given type and returns every rule it
+// breaks. Types without rules always pass.
+func Validate(kind string, payload []byte, env Env) []Violation {
+	rules, ok := Rules[kind]
+	if !ok {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return []Violation{{Field: "$", Code: Malformed, Detail: err.Error()}}
+	}
+	env.TsUnit, _ = doc["tsUnit"].(string)
+
+	var violations []Violation
+	for _, rule := range rules {
+		for _, value := range lookup(doc, strings.Split(rule.Field, ".")) {
+			switch {
+			case !value.found || value.v == nil:
+				if rule.Required {
+					violations = append(violations, Violation{Field: rule.Field, Code: Missing})
+				}
+			case rule.Check != nil:
+				if code, detail := rule.Check(value.v, env); code != "" {
+					violations = append(violations, Violation{Field: rule.Field, Code: code, Detail: detail})
+				}
+			}
+		}
+	}
+	return violations
+}
+
+type found struct {
+	v     interface{}
+	found bool
+}
+
+// lookup resolves a path, returning one result per array element for
+// segments ending in []. An empty or missing array yields nothing, so rules
+// on its elements only apply when there are elements.
+func lookup(v interface{}, path []string) []found {
+	if len(path) == 0 {
+		return []found{{v: v, found: true}}
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return []found{{}}
+	}
+	name, each := strings.CutSuffix(path[0], "[]")
+	child, ok := obj[name]
+	if !ok {
+		if each {
+			return nil
+		}
+		return []found{{}}
+	}
+	if !each {
+		return lookup(child, path[1:])
+	}
+
+	elems, ok := child.([]interface{})
+	if !ok {
+		return []found{{}}
+	}
+	var results []found
+	for _, elem := range elems {
+		results = append(results, lookup(elem, path[1:])...)
+	}
+	return results
+}
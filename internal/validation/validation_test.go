@@ -0,0 +1,96 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var now = time.Unix(1700000000, 0)
+
+var env = Env{Now: now, Limits: Limits{MaxFuture: time.Hour, MaxPast: 24 * time.Hour}}
+
+func reasons(violations []Violation) []string {
+	r := []string{}
+	for _, v := range violations {
+		r = append(r, v.Reason())
+	}
+	return r
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		payload string
+		want    []string
+	}{
+		{"valid level", "level", `{"ts": 1700000000, "tsUnit": "s", "level": 42.5, "device": {"temp": 21.5, "rssi": -70, "fwVer": "1.4.0"}, "power": {"battLevel": 87}}`, []string{}},
+		{"inferred unit", "level", `{"ts": 1700000000000, "level": 1}`, []string{}},
+		{"empty unit", "level", `{"ts": 1700000000, "tsUnit": "", "level": 1}`, []string{}},
+		{"unknown unit", "level", `{"ts": 1700000000, "tsUnit": "ns", "level": 1}`, []string{"tsUnit:unknown_value"}},
+		{"missing fields", "level", `{"tsUnit": "s"}`, []string{"ts:missing", "level:missing"}},
+		{"null field", "level", `{"ts": 1700000000, "level": null}`, []string{"level:missing"}},
+		{"wrong types", "level", `{"ts": "now", "level": 1, "device": {"fwVer": 14}}`, []string{"ts:wrong_type", "device.fwVer:wrong_type"}},
+		{"out of range", "level", `{"ts": 1700000000, "level": -1, "device": {"hum": 120, "lat": -91}, "power": {"battLevel": 101}}`, []string{"level:out_of_range", "power.battLevel:out_of_range", "device.hum:out_of_range", "device.lat:out_of_range"}},
+		{"zero timestamp", "filling", `{"ts": 0, "filling-state": 1, "level": 5}`, []string{"ts:zero_timestamp"}},
+		{"clock ahead", "filling", `{"ts": 1700007200, "filling-state": 1, "level": 5}`, []string{"ts:clock_future"}},
+		{"clock behind", "filling", `{"ts": 1699900000, "filling-state": 1, "level": 5}`, []string{"ts:clock_past"}},
+		{"millisecond clock ahead", "filling", `{"ts": 1700007200000, "tsUnit": "ms", "filling-state": 1, "level": 5}`, []string{"ts:clock_future"}},
+		{"valid flow", "flow", `{"ts": 1700000000, "vHi": 0, "vLo": 345.5, "vDec": 0.25, "fRateHi": 0, "fRateLo": 0.75}`, []string{}},
+		{"missing flow counters", "flow", `{"ts": 1700000000, "vHi": 0}`, []string{"vLo:missing", "vDec:missing", "fRateHi:missing", "fRateLo:missing"}},
+		{"valid pressure", "pressure", `{"ts": 1700000000, "data": [{"measurement": "oxygen", "value": 4.2}, {"measurement": "vacuum", "value": -0.5, "high_limit": 0}]}`, []string{}},
+		{"empty pressure data", "pressure", `{"ts": 1700000000, "data": []}`, []string{}},
+		{"missing pressure data", "pressure", `{"ts": 1700000000}`, []string{"data:missing"}},
+		{"bad pressure elements", "pressure", `{"ts": 1700000000, "data": [{"measurement": "helium", "value": 1}, {"measurement": "oxygen"}]}`, []string{"data[].measurement:unknown_value", "data[].value:missing"}},
+		{"pressure data not an array", "pressure", `{"ts": 1700000000, "data": {"measurement": "oxygen"}}`, []string{"data:wrong_type", "data[].measurement:missing", "data[].value:missing"}},
+		{"malformed", "level", `{"ts":`, []string{"$:malformed"}},
+		{"no rules", "status", `not even JSON`, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reasons(Validate(tt.kind, []byte(tt.payload), env)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecks(t *testing.T) {
+	tests := []struct {
+		name  string
+		check Check
+		v     interface{}
+		want  Code
+	}{
+		{"range inside", Range(0, 10), 10.0, ""},
+		{"range outside", Range(0, 10), 10.5, OutOfRange},
+		{"range wrong type", Range(0, 10), "5", WrongType},
+		{"at least", AtLeast(0), 0.0, ""},
+		{"below", AtLeast(0), -0.1, OutOfRange},
+		{"number", Number(), 1e300, ""},
+		{"one of", OneOf("a", "b"), "b", ""},
+		{"not one of", OneOf("a", "b"), "c", Unknown},
+		{"one of wrong type", OneOf("a"), 1.0, WrongType},
+		{"string", String(), "x", ""},
+		{"not a string", String(), 1.0, WrongType},
+		{"array", Array(), []interface{}{}, ""},
+		{"not an array", Array(), map[string]interface{}{}, WrongType},
+	}
+	for _, tt := range tests {
+		if got, _ := tt.check(tt.v, env); got != tt.want {
+			t.Errorf("%s: code = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestViolationString(t *testing.T) {
+	v := Violation{Field: "level", Code: OutOfRange, Detail: "-1 below 0"}
+	if got := v.String(); got != "level:out_of_range (-1 below 0)" {
+		t.Errorf("String() = %q", got)
+	}
+	v.Detail = ""
+	if got := v.String(); got != "level:out_of_range" {
+		t.Errorf("String() = %q", got)
+	}
+}
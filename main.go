@@ -6,6 +6,8 @@ import (
 	"log"
 	"medical-gas-transport-service/config"
 	"medical-gas-transport-service/internal"
+	"medical-gas-transport-service/internal/export"
+	"medical-gas-transport-service/internal/pki"
 	"medical-gas-transport-service/internal/services"
 	"os"
 	"os/signal"
@@ -70,8 +72,28 @@ func main() {
 		log.Fatalf("Error creating Timescaledb client: %v", err)
 	}
 
+	// Load the device certificate authority
+	var ca *pki.CA
+	if cfg.PKI.CACertFile != "" {
+		log.Printf("Setup Certificate Authority")
+		ca, err = pki.LoadCA(cfg.PKI.CACertFile, cfg.PKI.CAKeyFile, cfg.PKI.Organization)
+		if err != nil {
+			log.Fatalf("Error loading certificate authority: %v", err)
+		}
+	}
+
+	// Create the event exporter
+	var exporter *export.Exporter
+	if cfg.Export.Sink != "" {
+		log.Printf("Setup Event Export (%s)", cfg.Export.Sink)
+		exporter, err = export.Open(cfg.Export.Sink, cfg.Export.Brokers, cfg.Export.TopicPrefix, cfg.Export.Format, cfg.Export.Channels)
+		if err != nil {
+			log.Fatalf("Error creating event exporter: %v", err)
+		}
+	}
+
 	// Start the service
-	svc := internal.NewService(ctx, mqttClient, redisClient, jayaClient, timescaleClient, cfg)
+	svc := internal.NewService(ctx, mqttClient, redisClient, jayaClient, timescaleClient, ca, exporter, cfg)
 	svc.Start()
 
 	log.Println("Service started. Waiting for shutdown signal.")
@@ -79,5 +101,10 @@ func main() {
 	// Wait for context cancellation
 	<-ctx.Done()
 	services.DisconnectMQTTClient(mqttClient.Client)
+	if exporter != nil {
+		if err := exporter.Close(); err != nil {
+			log.Printf("Error closing event exporter: %v", err)
+		}
+	}
 	log.Println("Shutting down service...")
 }
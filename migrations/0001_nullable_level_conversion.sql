@@ -0,0 +1,6 @@
+-- Level readings outside the tank conversion table are stored without a
+-- derived mass or volume instead of being converted with a default formula.
+ALTER TABLE sensor_level ALTER COLUMN level_kg DROP NOT NULL;
+ALTER TABLE sensor_level ALTER COLUMN level_meter_cubic DROP NOT NULL;
+ALTER TABLE filling_transaction ALTER COLUMN level_kg DROP NOT NULL;
+ALTER TABLE filling_transaction ALTER COLUMN level_meter_cubic DROP NOT NULL;
@@ -0,0 +1,8 @@
+-- Monotonic volume derived from the raw meter totalizer, corrected for
+-- counter resets and wraps, and the volume consumed since the previous row.
+ALTER TABLE sensor_flow ADD COLUMN IF NOT EXISTS cumulative_volume DOUBLE PRECISION;
+ALTER TABLE sensor_flow ADD COLUMN IF NOT EXISTS delta_volume DOUBLE PRECISION;
+
+-- The totalizer of a reading is derived from the neighbouring rows of its
+-- device.
+CREATE INDEX IF NOT EXISTS sensor_flow_serial_time_idx ON sensor_flow (serial_number, time DESC);
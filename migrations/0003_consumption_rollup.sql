@@ -0,0 +1,19 @@
+-- Flow consumption attributed to the installation point location hierarchy.
+-- scope_key is the path from the hospital down to the scope, e.g.
+-- "<hospital>/<building>/<floor>/<room>".
+CREATE TABLE IF NOT EXISTS consumption_rollup (
+    bucket     TIMESTAMPTZ      NOT NULL,
+    period     TEXT             NOT NULL,
+    scope      TEXT             NOT NULL,
+    scope_key  TEXT             NOT NULL,
+    hospital   TEXT             NOT NULL,
+    building   TEXT,
+    floor      TEXT,
+    room       TEXT,
+    bed        TEXT,
+    volume     DOUBLE PRECISION NOT NULL DEFAULT 0,
+    readings   INTEGER          NOT NULL DEFAULT 0,
+    PRIMARY KEY (period, scope, scope_key, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS consumption_rollup_hospital_idx ON consumption_rollup (hospital, period, bucket);
@@ -0,0 +1,27 @@
+-- Latest health score per device, derived from the Device block of every
+-- reading, and the log of health events raised and cleared.
+CREATE TABLE IF NOT EXISTS device_health (
+    serial_number      TEXT PRIMARY KEY,
+    time               TIMESTAMPTZ      NOT NULL,
+    score              INTEGER          NOT NULL,
+    reboots            INTEGER          NOT NULL DEFAULT 0,
+    last_reboot        TIMESTAMPTZ,
+    reset_reason       INTEGER,
+    reset_reason_name  TEXT,
+    rssi               INTEGER,
+    temp               DOUBLE PRECISION,
+    hum                DOUBLE PRECISION,
+    mem_usage          INTEGER,
+    uptime             INTEGER,
+    active_conditions  JSONB            NOT NULL DEFAULT '[]'
+);
+
+CREATE TABLE IF NOT EXISTS device_health_event (
+    time           TIMESTAMPTZ NOT NULL,
+    serial_number  TEXT        NOT NULL,
+    type           TEXT        NOT NULL,
+    detail         TEXT,
+    score          INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS device_health_event_serial_idx ON device_health_event (serial_number, time DESC);
@@ -0,0 +1,23 @@
+-- Daily energy balance and battery autonomy of solar powered tank sites.
+CREATE TABLE IF NOT EXISTS solar_energy_daily (
+    day            TIMESTAMPTZ      NOT NULL,
+    serial_number  TEXT             NOT NULL,
+    generated_wh   DOUBLE PRECISION NOT NULL,
+    consumed_wh    DOUBLE PRECISION NOT NULL,
+    balance_wh     DOUBLE PRECISION NOT NULL,
+    battery_level  INTEGER,
+    autonomy_days  DOUBLE PRECISION,
+    updated_at     TIMESTAMPTZ      NOT NULL,
+    PRIMARY KEY (day, serial_number)
+);
+
+-- Solar alerts as they are raised and cleared.
+CREATE TABLE IF NOT EXISTS solar_alert (
+    time           TIMESTAMPTZ NOT NULL,
+    serial_number  TEXT        NOT NULL,
+    type           TEXT        NOT NULL,
+    state          TEXT        NOT NULL,
+    detail         TEXT
+);
+
+CREATE INDEX IF NOT EXISTS solar_alert_serial_idx ON solar_alert (serial_number, time DESC);
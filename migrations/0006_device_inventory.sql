@@ -0,0 +1,31 @@
+-- Hardware and firmware inventory maintained from incoming telemetry.
+CREATE TABLE IF NOT EXISTS device_inventory (
+    serial_number  TEXT PRIMARY KEY,
+    device_type    TEXT        NOT NULL,
+    hospital       TEXT        NOT NULL DEFAULT '',
+    model          TEXT        NOT NULL DEFAULT '',
+    hw_ver         TEXT        NOT NULL DEFAULT '',
+    fw_ver         TEXT        NOT NULL DEFAULT '',
+    rd_ver         TEXT        NOT NULL DEFAULT '',
+    first_seen     TIMESTAMPTZ NOT NULL,
+    last_seen      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS device_inventory_model_idx ON device_inventory (model, fw_ver);
+CREATE INDEX IF NOT EXISTS device_inventory_hospital_idx ON device_inventory (hospital);
+
+-- Every model or version change a device reported.
+CREATE TABLE IF NOT EXISTS device_version_event (
+    time           TIMESTAMPTZ NOT NULL,
+    serial_number  TEXT        NOT NULL,
+    component      TEXT        NOT NULL,
+    old_value      TEXT        NOT NULL,
+    new_value      TEXT        NOT NULL,
+    change         TEXT        NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS device_version_event_serial_idx ON device_version_event (serial_number, time DESC);
+
+-- Version events are written before the change is recorded in Redis, so
+-- instances racing on the same reading must not store it twice.
+CREATE UNIQUE INDEX IF NOT EXISTS device_version_event_unique_idx ON device_version_event (serial_number, component, time);
@@ -0,0 +1,40 @@
+-- Firmware images registered for over-the-air updates. Files live in
+-- OTA_ARTIFACT_DIR as <id>.bin.
+CREATE TABLE IF NOT EXISTS firmware_artifact (
+    id          TEXT PRIMARY KEY,
+    model       TEXT        NOT NULL,
+    hw_ver      TEXT        NOT NULL DEFAULT '',
+    version     TEXT        NOT NULL,
+    filename    TEXT        NOT NULL,
+    size        BIGINT      NOT NULL,
+    sha256      TEXT        NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL
+);
+
+-- Staged rollout of one artifact. stages holds cumulative percentages.
+CREATE TABLE IF NOT EXISTS ota_campaign (
+    id                 TEXT PRIMARY KEY,
+    artifact_id        TEXT             NOT NULL REFERENCES firmware_artifact (id),
+    stages             JSONB            NOT NULL,
+    current_stage      INTEGER          NOT NULL DEFAULT 0,
+    failure_threshold  DOUBLE PRECISION NOT NULL,
+    status             TEXT             NOT NULL,
+    halt_reason        TEXT,
+    created_at         TIMESTAMPTZ      NOT NULL,
+    updated_at         TIMESTAMPTZ      NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS ota_campaign_device (
+    campaign_id     TEXT        NOT NULL REFERENCES ota_campaign (id),
+    serial_number   TEXT        NOT NULL,
+    stage           INTEGER     NOT NULL,
+    status          TEXT        NOT NULL,
+    progress        INTEGER     NOT NULL DEFAULT 0,
+    detail          TEXT,
+    sent_at         TIMESTAMPTZ,
+    -- Commands that couldn't be published are retried with backoff.
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ,
+    updated_at      TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (campaign_id, serial_number)
+);
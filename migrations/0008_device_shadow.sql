@@ -0,0 +1,11 @@
+-- Desired configuration set by operators and the configuration devices
+-- reported back, per serial number.
+CREATE TABLE IF NOT EXISTS device_shadow (
+    serial_number     TEXT PRIMARY KEY,
+    desired           JSONB       NOT NULL DEFAULT '{}',
+    reported          JSONB       NOT NULL DEFAULT '{}',
+    version           INTEGER     NOT NULL DEFAULT 0,
+    reported_version  INTEGER     NOT NULL DEFAULT 0,
+    updated_at        TIMESTAMPTZ,
+    reported_at       TIMESTAMPTZ
+);
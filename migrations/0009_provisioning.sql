@@ -0,0 +1,21 @@
+-- Serial numbers allowed to provision. When claim_token_hash is set the
+-- device must present the matching claim token (stored as SHA-256 hex).
+CREATE TABLE IF NOT EXISTS provisioning_allowlist (
+    serial_number     TEXT PRIMARY KEY,
+    claim_token_hash  TEXT,
+    created_at        TIMESTAMPTZ NOT NULL,
+    claimed_at        TIMESTAMPTZ
+);
+
+-- Every provisioning request and its outcome.
+CREATE TABLE IF NOT EXISTS provisioning_audit (
+    time                 TIMESTAMPTZ NOT NULL,
+    serial_number        TEXT        NOT NULL,
+    method               TEXT        NOT NULL,
+    outcome              TEXT        NOT NULL,
+    reason               TEXT        NOT NULL DEFAULT '',
+    nonce_hash           TEXT        NOT NULL DEFAULT '',
+    claim_token_present  BOOLEAN     NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS provisioning_audit_serial_idx ON provisioning_audit (serial_number, time DESC);
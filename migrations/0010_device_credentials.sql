@@ -0,0 +1,28 @@
+-- Username currently issued to each device. Passwords are never stored.
+CREATE TABLE IF NOT EXISTS device_credential (
+    serial_number  TEXT PRIMARY KEY,
+    username       TEXT        NOT NULL,
+    status         TEXT        NOT NULL,
+    issued_at      TIMESTAMPTZ NOT NULL,
+    revoked_at     TIMESTAMPTZ,
+    revoke_reason  TEXT
+);
+
+-- Credential rotations: pending until the device confirms over a connection
+-- made with the new credentials, then revoke_pending until the old ones are
+-- revoked and confirmed; or expired, failed or cancelled.
+CREATE TABLE IF NOT EXISTS credential_rotation (
+    id             TEXT PRIMARY KEY,
+    serial_number  TEXT        NOT NULL,
+    old_username   TEXT        NOT NULL DEFAULT '',
+    new_username   TEXT        NOT NULL,
+    status         TEXT        NOT NULL,
+    reason         TEXT        NOT NULL DEFAULT '',
+    requested_at   TIMESTAMPTZ NOT NULL,
+    completed_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS credential_rotation_serial_idx ON credential_rotation (serial_number, requested_at DESC);
+
+-- At most one rotation per device waits for its device.
+CREATE UNIQUE INDEX IF NOT EXISTS credential_rotation_pending_idx ON credential_rotation (serial_number) WHERE status = 'pending';
@@ -0,0 +1,10 @@
+-- Client certificates issued to devices by the local CA.
+CREATE TABLE IF NOT EXISTS device_certificate (
+    serial_number  TEXT        NOT NULL,
+    cert_serial    TEXT        NOT NULL,
+    fingerprint    TEXT        NOT NULL,
+    not_after      TIMESTAMPTZ NOT NULL,
+    issued_at      TIMESTAMPTZ NOT NULL,
+    revoked_at     TIMESTAMPTZ,
+    PRIMARY KEY (serial_number, cert_serial)
+);
@@ -0,0 +1,17 @@
+-- Events written in the same transaction as the rows they announce. The
+-- relay publishes pending entries and marks them delivered.
+CREATE TABLE IF NOT EXISTS event_outbox (
+    id              BIGSERIAL   PRIMARY KEY,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    target          TEXT        NOT NULL, -- redis or mqtt
+    destination     TEXT        NOT NULL, -- Redis channel or MQTT topic
+    qos             SMALLINT    NOT NULL DEFAULT 0,
+    payload         BYTEA       NOT NULL,
+    attempts        INT         NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error      TEXT,
+    delivered_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS event_outbox_pending_idx ON event_outbox (next_attempt_at) WHERE delivered_at IS NULL;
+CREATE INDEX IF NOT EXISTS event_outbox_delivered_idx ON event_outbox (delivered_at) WHERE delivered_at IS NOT NULL;
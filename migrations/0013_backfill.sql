@@ -0,0 +1,5 @@
+-- Readings uploaded from a device buffer after the fact rather than received
+-- live.
+ALTER TABLE sensor_level ADD COLUMN IF NOT EXISTS backfilled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE sensor_flow ADD COLUMN IF NOT EXISTS backfilled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE sensor_pressure ADD COLUMN IF NOT EXISTS backfilled BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,16 @@
+-- Readings that failed validation, kept with the rules they broke instead of
+-- being stored as sensor data.
+CREATE TABLE IF NOT EXISTS sensor_quarantine (
+    id            BIGSERIAL   PRIMARY KEY,
+    received_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    serial_number TEXT        NOT NULL,
+    payload_type  TEXT        NOT NULL,
+    topic         TEXT        NOT NULL,
+    fw_version    TEXT,
+    reasons       TEXT[]      NOT NULL,
+    violations    JSONB       NOT NULL,
+    payload       BYTEA       NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sensor_quarantine_device_idx ON sensor_quarantine (serial_number, received_at DESC);
+CREATE INDEX IF NOT EXISTS sensor_quarantine_reasons_idx ON sensor_quarantine USING GIN (reasons);
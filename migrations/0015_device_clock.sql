@@ -0,0 +1,11 @@
+-- The timestamp as reported by the device and the time the broker delivered
+-- the reading. time holds the device timestamp, corrected for clock skew
+-- when correction is enabled.
+ALTER TABLE sensor_level ADD COLUMN IF NOT EXISTS device_time TIMESTAMPTZ;
+ALTER TABLE sensor_level ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ;
+ALTER TABLE sensor_flow ADD COLUMN IF NOT EXISTS device_time TIMESTAMPTZ;
+ALTER TABLE sensor_flow ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ;
+ALTER TABLE sensor_pressure ADD COLUMN IF NOT EXISTS device_time TIMESTAMPTZ;
+ALTER TABLE sensor_pressure ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ;
+ALTER TABLE filling_transaction ADD COLUMN IF NOT EXISTS device_time TIMESTAMPTZ;
+ALTER TABLE filling_transaction ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ;